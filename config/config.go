@@ -0,0 +1,594 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config is the effective service configuration. Values are resolved with the precedence
+// flags > environment > config file > defaults (see Load). Leaf fields carry:
+//   - yaml/toml: key in the config file (the flag name is the dotted key path, e.g. -storage.bucket)
+//   - env: environment variable overriding the file
+//   - secret: redacted by `config print`
+//   - reload: "restart" if a changed value only takes effect after a restart (see Watch)
+type Config struct {
+	// file is the config file this was loaded from, watched for changes
+	file string
+
+	Server     ServerConfig     `yaml:"server" toml:"server"`
+	CORS       CORSConfig       `yaml:"cors" toml:"cors"`
+	Database   DatabaseConfig   `yaml:"database" toml:"database"`
+	Storage    StorageConfig    `yaml:"storage" toml:"storage"`
+	Upload     UploadConfig     `yaml:"upload" toml:"upload"`
+	Download   DownloadConfig   `yaml:"download" toml:"download"`
+	Delete     DeleteConfig     `yaml:"delete" toml:"delete"`
+	Admin      AdminConfig      `yaml:"admin" toml:"admin"`
+	Bulk       BulkConfig       `yaml:"bulk" toml:"bulk"`
+	Versions   VersionsConfig   `yaml:"versions" toml:"versions"`
+	S3Gateway  S3GatewayConfig  `yaml:"s3_gateway" toml:"s3_gateway"`
+	Content    ContentConfig    `yaml:"content_types" toml:"content_types"`
+	Scanner    ScannerConfig    `yaml:"scanner" toml:"scanner"`
+	Encryption EncryptionConfig `yaml:"encryption" toml:"encryption"`
+	Logging    LoggingConfig    `yaml:"logging" toml:"logging"`
+	Tracing    TracingConfig    `yaml:"tracing" toml:"tracing"`
+	Worker     WorkerConfig     `yaml:"worker" toml:"worker"`
+}
+
+type ServerConfig struct {
+	Port            int      `yaml:"port" toml:"port" env:"PORT" reload:"restart" help:"HTTP listen port"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"How long in-flight requests may drain after SIGTERM"`
+	ReloadInterval  Duration `yaml:"reload_interval" toml:"reload_interval" env:"CONFIG_RELOAD_INTERVAL" help:"How often the config file is checked for changes; 0 disables polling (SIGHUP still reloads)"`
+}
+
+// CORSConfig is the CORS policy applied to the API
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" help:"Origins allowed to call the API (comma separated); * for any, https://*.example.com for subdomains"`
+	AllowedMethods   []string `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_ALLOWED_METHODS" help:"Methods allowed in preflight requests"`
+	AllowedHeaders   []string `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_ALLOWED_HEADERS" help:"Request headers allowed in preflight requests; * echoes the requested headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers" toml:"exposed_headers" env:"CORS_EXPOSED_HEADERS" help:"Response headers readable by browser scripts"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" help:"Allow cookies/Authorization on cross-origin requests (requires explicit origins)"`
+	MaxAge           Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE" help:"How long browsers may cache preflight results"`
+}
+
+// BucketCORSConfig is the CORS policy written to the bucket for direct browser
+// uploads/downloads via presigned URLs. It is only applied when Manage is set.
+type BucketCORSConfig struct {
+	Manage         bool     `yaml:"manage" toml:"manage" env:"BUCKET_CORS_MANAGE" reload:"restart" help:"Overwrite the bucket CORS configuration at startup"`
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins" env:"BUCKET_CORS_ALLOWED_ORIGINS" reload:"restart" help:"Origins allowed to use presigned URLs"`
+	AllowedMethods []string `yaml:"allowed_methods" toml:"allowed_methods" env:"BUCKET_CORS_ALLOWED_METHODS" reload:"restart" help:"Methods allowed on the bucket"`
+	AllowedHeaders []string `yaml:"allowed_headers" toml:"allowed_headers" env:"BUCKET_CORS_ALLOWED_HEADERS" reload:"restart" help:"Request headers allowed on the bucket"`
+	ExposedHeaders []string `yaml:"exposed_headers" toml:"exposed_headers" env:"BUCKET_CORS_EXPOSED_HEADERS" reload:"restart" help:"Response headers exposed by the bucket"`
+	MaxAge         Duration `yaml:"max_age" toml:"max_age" env:"BUCKET_CORS_MAX_AGE" reload:"restart" help:"Preflight cache duration for the bucket"`
+}
+
+type DatabaseConfig struct {
+	Path string `yaml:"path" toml:"path" env:"DB_PATH" reload:"restart" help:"SQLite database file"`
+}
+
+type StorageConfig struct {
+	Endpoint  string   `yaml:"endpoint" toml:"endpoint" env:"CEPH_ENDPOINT" reload:"restart" help:"Ceph/S3 endpoint URL"`
+	AccessKey string   `yaml:"access_key" toml:"access_key" env:"CEPH_ACCESS_KEY" secret:"true" reload:"restart" help:"Ceph/S3 access key"`
+	SecretKey string   `yaml:"secret_key" toml:"secret_key" env:"CEPH_SECRET_KEY" secret:"true" reload:"restart" help:"Ceph/S3 secret key"`
+	Bucket    string   `yaml:"bucket" toml:"bucket" env:"CEPH_BUCKET" reload:"restart" help:"Bucket holding artifact content"`
+	Region    string   `yaml:"region" toml:"region" env:"CEPH_REGION" reload:"restart" help:"Region sent to the S3 API (Ceph ignores it)"`
+	Quota     ByteSize `yaml:"quota" toml:"quota" env:"STORAGE_QUOTA" help:"Total space reported by the storage usage endpoint, e.g. 10GiB"`
+
+	BucketCORS  BucketCORSConfig  `yaml:"bucket_cors" toml:"bucket_cors"`
+	Compression CompressionConfig `yaml:"compression" toml:"compression"`
+	ObjectLock  ObjectLockConfig  `yaml:"object_lock" toml:"object_lock"`
+}
+
+// CompressionConfig selects which uploads are compressed at rest
+type CompressionConfig struct {
+	Algorithm string   `yaml:"algorithm" toml:"algorithm" env:"STORAGE_COMPRESSION" help:"Compression at rest for new uploads: none, gzip or zstd"`
+	Level     int      `yaml:"level" toml:"level" env:"STORAGE_COMPRESSION_LEVEL" help:"Compression level, 0 for the algorithm's default (gzip 1-9, zstd 1-4)"`
+	MinSize   ByteSize `yaml:"min_size" toml:"min_size" env:"STORAGE_COMPRESSION_MIN_SIZE" help:"Smaller uploads are stored uncompressed"`
+	Types     []string `yaml:"types" toml:"types" env:"STORAGE_COMPRESSION_TYPES" help:"Detected content types that are compressed (image/* patterns); empty compresses every type"`
+}
+
+// ObjectLockConfig mirrors legal holds and retention periods to S3 Object Lock. The
+// bucket must have been created with Object Lock enabled.
+type ObjectLockConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled" env:"STORAGE_OBJECT_LOCK" help:"Apply legal holds and retention to the objects with S3 Object Lock"`
+	Mode    string `yaml:"mode" toml:"mode" env:"STORAGE_OBJECT_LOCK_MODE" help:"Retention mode: GOVERNANCE (admins can shorten retention) or COMPLIANCE (nobody can)"`
+}
+
+type UploadConfig struct {
+	MaxSize ByteSize `yaml:"max_size" toml:"max_size" env:"UPLOAD_MAX_SIZE" help:"Largest accepted upload, 0 for no limit"`
+}
+
+type DownloadConfig struct {
+	InlineTypes       []string `yaml:"inline_types" toml:"inline_types" env:"DOWNLOAD_INLINE_TYPES" help:"Content types that may be shown inline with ?disposition=inline; everything else is an attachment"`
+	BundleMaxItems    int      `yaml:"bundle_max_items" toml:"bundle_max_items" env:"DOWNLOAD_BUNDLE_MAX_ITEMS" help:"Most artifacts a single ZIP/TAR bundle may contain"`
+	ArchiveMaxEntries int      `yaml:"archive_max_entries" toml:"archive_max_entries" env:"DOWNLOAD_ARCHIVE_MAX_ENTRIES" help:"Most entries of an archive artifact that are listed, or read from a TAR to find an entry to extract"`
+}
+
+type DeleteConfig struct {
+	GracePeriod Duration `yaml:"grace_period" toml:"grace_period" env:"DELETE_GRACE_PERIOD" help:"How long deleted artifacts stay in the trash and can be restored, 0 to delete immediately"`
+}
+
+// AdminConfig lists the keys for admin-only operations, sent as "Authorization: Bearer <key>"
+type AdminConfig struct {
+	APIKeys []string `yaml:"api_keys" toml:"api_keys" env:"ADMIN_API_KEYS" secret:"true" help:"Keys for admin-only operations such as releasing legal holds"`
+}
+
+// BulkConfig limits bulk jobs
+type BulkConfig struct {
+	MaxItems     int      `yaml:"max_items" toml:"max_items" env:"BULK_MAX_ITEMS" help:"Most artifacts a single bulk job may select"`
+	JobRetention Duration `yaml:"job_retention" toml:"job_retention" env:"BULK_JOB_RETENTION" help:"How long the progress and results of finished bulk jobs are kept"`
+}
+
+// VersionsConfig sets the default retention of named versions
+type VersionsConfig struct {
+	Keep int `yaml:"keep" toml:"keep" env:"VERSIONS_KEEP" help:"How many downloadable versions of each name are kept, older ones are moved to the trash; 0 keeps all"`
+}
+
+// S3GatewayConfig configures the S3-compatible API, served on its own port. Requests are
+// signed with SigV4 using one of the credentials, which are separate from admin.api_keys.
+type S3GatewayConfig struct {
+	Enabled         bool                    `yaml:"enabled" toml:"enabled" env:"S3_GATEWAY_ENABLED" reload:"restart" help:"Serve the S3-compatible API"`
+	Port            int                     `yaml:"port" toml:"port" env:"S3_GATEWAY_PORT" reload:"restart" help:"Listen port of the S3-compatible API"`
+	Region          string                  `yaml:"region" toml:"region" env:"S3_GATEWAY_REGION" help:"Region clients sign requests for"`
+	Credentials     map[string]S3Credential `yaml:"credentials" toml:"credentials" secret:"true" help:"Access keys by access key ID, with their secret and the buckets (projects) they may use (config file only)"`
+	MultipartDir    string                  `yaml:"multipart_dir" toml:"multipart_dir" env:"S3_GATEWAY_MULTIPART_DIR" reload:"restart" help:"Directory holding the parts of multipart uploads until they are completed, shared by all replicas serving the gateway; empty for a directory in the system temp dir"`
+	MultipartExpiry Duration                `yaml:"multipart_expiry" toml:"multipart_expiry" env:"S3_GATEWAY_MULTIPART_EXPIRY" help:"How long an unfinished multipart upload is kept before its parts are removed"`
+}
+
+// S3Credential is the secret of an S3 gateway access key and the buckets it may use,
+// every bucket if none are listed
+type S3Credential struct {
+	Secret  string   `yaml:"secret" toml:"secret" secret:"true"`
+	Buckets []string `yaml:"buckets" toml:"buckets"`
+}
+
+// ContentConfig restricts the detected (sniffed) and declared content types of uploads.
+// Patterns are media types, optionally with a wildcard subtype ("image/*").
+type ContentConfig struct {
+	Allow    []string                     `yaml:"allow" toml:"allow" env:"CONTENT_TYPES_ALLOW" help:"Content types accepted on upload, e.g. image/*; empty accepts everything not denied"`
+	Deny     []string                     `yaml:"deny" toml:"deny" env:"CONTENT_TYPES_DENY" help:"Content types rejected on upload, checked before allow"`
+	Projects map[string]ContentTypePolicy `yaml:"projects" toml:"projects" help:"Per project policies (config file only)"`
+}
+
+// ContentTypePolicy overrides the global lists for one project: its allow list replaces
+// the global one if set, its deny list is added to the global one.
+type ContentTypePolicy struct {
+	Allow []string `yaml:"allow" toml:"allow"`
+	Deny  []string `yaml:"deny" toml:"deny"`
+}
+
+type ScannerConfig struct {
+	Mode    string   `yaml:"mode" toml:"mode" env:"SCANNER_MODE" help:"Malware scanner: none, clamd or command"`
+	Address string   `yaml:"address" toml:"address" env:"SCANNER_ADDRESS" help:"clamd socket, unix:///var/run/clamav/clamd.ctl or tcp://host:3310"`
+	Command string   `yaml:"command" toml:"command" env:"SCANNER_COMMAND" help:"Command reading the artifact on stdin; exit 0 = clean, 1 = infected (signature on stdout)"`
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"SCANNER_TIMEOUT" help:"Maximum time for scanning one artifact"`
+}
+
+// EncryptionConfig selects the master key provider for envelope encryption
+type EncryptionConfig struct {
+	Provider      string `yaml:"provider" toml:"provider" env:"ENCRYPTION_PROVIDER" reload:"restart" help:"Master key provider for encrypting new uploads: none, file, command or a registered plugin"`
+	KeyFile       string `yaml:"key_file" toml:"key_file" env:"ENCRYPTION_KEY_FILE" reload:"restart" help:"Key file with one \"<key id> <base64 32 byte key>\" per line; the last key wraps new data keys"`
+	KeyCommand    string `yaml:"key_command" toml:"key_command" env:"ENCRYPTION_KEY_COMMAND" reload:"restart" help:"Program wrapping data keys (key-id, wrap, unwrap <id>), e.g. a KMS client"`
+	PresignedSSEC bool   `yaml:"presigned_sse_c" toml:"presigned_sse_c" env:"ENCRYPTION_PRESIGNED_SSE_C" help:"Have storage encrypt presigned uploads with SSE-C using a per-artifact key"`
+}
+
+type LoggingConfig struct {
+	Mode       string `yaml:"mode" toml:"mode" env:"LOG_MODE" help:"Audit log mode: INTERNAL, EXTERNAL, SYSLOG or CEF"`
+	ServiceURL string `yaml:"service_url" toml:"service_url" env:"LOG_SERVICE_URL" help:"External log service URL or syslog target (udp://, tcp://, unix://)"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" reload:"restart" help:"OpenTelemetry exporter: none, otlp or stdout"`
+}
+
+type WorkerConfig struct {
+	StatusCheckInterval Duration `yaml:"status_check_interval" toml:"status_check_interval" env:"STATUS_CHECK_INTERVAL" help:"How often PENDING artifacts are checked in storage"`
+	PendingExpiry       Duration `yaml:"pending_expiry" toml:"pending_expiry" env:"PENDING_EXPIRY" help:"How long an artifact may stay PENDING before it is marked EXPIRED"`
+	ScanInterval        Duration `yaml:"scan_interval" toml:"scan_interval" env:"SCAN_INTERVAL" help:"How often SCANNING artifacts are picked up again (new uploads are scanned right away)"`
+	ReconcileInterval   Duration `yaml:"reconcile_interval" toml:"reconcile_interval" env:"RECONCILE_INTERVAL" help:"How often the bucket is compared with the database, 0 to only run at startup"`
+	ReconcileFix        bool     `yaml:"reconcile_fix" toml:"reconcile_fix" env:"RECONCILE_FIX" help:"Delete orphan objects and mark artifacts without content MISSING instead of only reporting them"`
+	PurgeInterval       Duration `yaml:"purge_interval" toml:"purge_interval" env:"PURGE_INTERVAL" help:"How often artifacts past delete.grace_period are removed from the trash"`
+	IntentTimeout       Duration `yaml:"intent_timeout" toml:"intent_timeout" env:"INTENT_TIMEOUT" help:"How long an upload or delete may run before the reconciler completes or rolls it back"`
+}
+
+// Default returns the built-in defaults, matching the behaviour before configuration existed
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            8080,
+			ShutdownTimeout: Duration(30 * time.Second),
+			ReloadInterval:  Duration(10 * time.Second),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Accept", "Origin", "Cache-Control", "X-Requested-With"},
+			MaxAge:         Duration(10 * time.Minute),
+		},
+		Database: DatabaseConfig{
+			Path: "files.db",
+		},
+		Storage: StorageConfig{
+			Bucket: "artifacts",
+			Region: "us-east-1",
+			Quota:  10 * GiB,
+			BucketCORS: BucketCORSConfig{
+				AllowedMethods: []string{"GET", "PUT", "POST", "HEAD"},
+				AllowedHeaders: []string{"*"},
+				ExposedHeaders: []string{"ETag"},
+				MaxAge:         Duration(50 * time.Minute),
+			},
+			Compression: CompressionConfig{
+				Algorithm: "none",
+				MinSize:   4 * KiB,
+				// Text formats compress well; images, archives and media are compressed already
+				Types: []string{"text/*", "application/json", "application/xml", "application/x-ndjson", "application/javascript", "image/svg+xml"},
+			},
+			ObjectLock: ObjectLockConfig{
+				Mode: "GOVERNANCE",
+			},
+		},
+		Upload: UploadConfig{
+			MaxSize: 5 * GiB,
+		},
+		Download: DownloadConfig{
+			InlineTypes:       []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"},
+			BundleMaxItems:    1000,
+			ArchiveMaxEntries: 10000,
+		},
+		Delete: DeleteConfig{
+			GracePeriod: Duration(7 * 24 * time.Hour),
+		},
+		Bulk: BulkConfig{
+			MaxItems:     10000,
+			JobRetention: Duration(7 * 24 * time.Hour),
+		},
+		S3Gateway: S3GatewayConfig{
+			Port:            8081,
+			Region:          "us-east-1",
+			MultipartExpiry: Duration(24 * time.Hour),
+		},
+		Content: ContentConfig{
+			// Executables and scripts, whatever they claim to be
+			Deny: []string{
+				"application/vnd.microsoft.portable-executable",
+				"application/x-executable",
+				"application/x-mach-binary",
+				"text/x-shellscript",
+			},
+		},
+		Scanner: ScannerConfig{
+			Mode:    "none",
+			Timeout: Duration(10 * time.Minute),
+		},
+		Encryption: EncryptionConfig{
+			Provider: "none",
+		},
+		Logging: LoggingConfig{
+			Mode: "INTERNAL",
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+		Worker: WorkerConfig{
+			StatusCheckInterval: Duration(60 * time.Second),
+			PendingExpiry:       Duration(30 * time.Minute),
+			ScanInterval:        Duration(30 * time.Second),
+			ReconcileInterval:   Duration(time.Hour),
+			PurgeInterval:       Duration(time.Hour),
+			IntentTimeout:       Duration(6 * time.Hour),
+		},
+	}
+}
+
+// Validate checks the configuration and reports every problem at once
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port: must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		add("server.shutdown_timeout: must not be negative")
+	}
+	if c.Server.ReloadInterval < 0 {
+		add("server.reload_interval: must not be negative")
+	}
+
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		add("cors.allowed_origins: * cannot be combined with cors.allow_credentials, list the origins explicitly")
+	}
+	if c.CORS.MaxAge < 0 {
+		add("cors.max_age: must not be negative")
+	}
+
+	if c.Database.Path == "" {
+		add("database.path: must be set")
+	}
+
+	if c.Storage.Endpoint == "" {
+		add("storage.endpoint: must be set (CEPH_ENDPOINT)")
+	} else if u, err := url.Parse(c.Storage.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		add("storage.endpoint: must be an absolute URL like http://ceph:80, got %q", c.Storage.Endpoint)
+	}
+	if c.Storage.AccessKey == "" {
+		add("storage.access_key: must be set (CEPH_ACCESS_KEY)")
+	}
+	if c.Storage.SecretKey == "" {
+		add("storage.secret_key: must be set (CEPH_SECRET_KEY)")
+	}
+	if c.Storage.Bucket == "" {
+		add("storage.bucket: must be set")
+	}
+	if c.Storage.Quota < 0 {
+		add("storage.quota: must not be negative")
+	}
+	if c.Upload.MaxSize < 0 {
+		add("upload.max_size: must not be negative")
+	}
+	switch c.Storage.ObjectLock.Mode {
+	case "GOVERNANCE", "COMPLIANCE":
+	default:
+		add("storage.object_lock.mode: must be GOVERNANCE or COMPLIANCE, got %q", c.Storage.ObjectLock.Mode)
+	}
+	for _, key := range c.Admin.APIKeys {
+		if len(key) < 16 {
+			add("admin.api_keys: keys must be at least 16 characters")
+			break
+		}
+	}
+	if c.Storage.BucketCORS.Manage && len(c.Storage.BucketCORS.AllowedOrigins) == 0 {
+		add("storage.bucket_cors.allowed_origins: must be set when storage.bucket_cors.manage is enabled")
+	}
+
+	checkPatterns := func(path string, patterns []string) {
+		for _, pattern := range patterns {
+			if !strings.Contains(pattern, "/") {
+				add("%s: %q is not a media type pattern like image/png or image/*", path, pattern)
+			}
+		}
+	}
+	switch c.Storage.Compression.Algorithm {
+	case "none":
+	case "gzip":
+		if c.Storage.Compression.Level < 0 || c.Storage.Compression.Level > 9 {
+			add("storage.compression.level: must be between 0 and 9 for gzip, got %d", c.Storage.Compression.Level)
+		}
+	case "zstd":
+		if c.Storage.Compression.Level < 0 || c.Storage.Compression.Level > 4 {
+			add("storage.compression.level: must be between 0 and 4 for zstd, got %d", c.Storage.Compression.Level)
+		}
+	default:
+		add("storage.compression.algorithm: must be none, gzip or zstd, got %q", c.Storage.Compression.Algorithm)
+	}
+	if c.Storage.Compression.MinSize < 0 {
+		add("storage.compression.min_size: must not be negative")
+	}
+	checkPatterns("storage.compression.types", c.Storage.Compression.Types)
+	checkPatterns("content_types.allow", c.Content.Allow)
+	checkPatterns("content_types.deny", c.Content.Deny)
+	for _, project := range slices.Sorted(maps.Keys(c.Content.Projects)) {
+		checkPatterns("content_types.projects."+project+".allow", c.Content.Projects[project].Allow)
+		checkPatterns("content_types.projects."+project+".deny", c.Content.Projects[project].Deny)
+	}
+
+	switch c.Scanner.Mode {
+	case "none":
+	case "clamd":
+		if u, err := url.Parse(c.Scanner.Address); err != nil || (u.Scheme != "unix" && u.Scheme != "tcp") {
+			add("scanner.address: must be unix:///path or tcp://host:port when scanner.mode is clamd, got %q", c.Scanner.Address)
+		}
+	case "command":
+		if strings.TrimSpace(c.Scanner.Command) == "" {
+			add("scanner.command: must be set when scanner.mode is command")
+		}
+	default:
+		add("scanner.mode: must be none, clamd or command, got %q", c.Scanner.Mode)
+	}
+	if c.Scanner.Timeout <= 0 {
+		add("scanner.timeout: must be positive")
+	}
+
+	switch c.Encryption.Provider {
+	case "":
+		add("encryption.provider: must be set, none disables encryption")
+	case "file":
+		if c.Encryption.KeyFile == "" {
+			add("encryption.key_file: must be set when encryption.provider is file")
+		}
+	case "command":
+		if strings.TrimSpace(c.Encryption.KeyCommand) == "" {
+			add("encryption.key_command: must be set when encryption.provider is command")
+		}
+	}
+	if c.Encryption.PresignedSSEC && c.Encryption.Provider == "none" {
+		add("encryption.presigned_sse_c: requires an encryption.provider to wrap the SSE-C keys")
+	}
+	if c.Encryption.PresignedSSEC && !strings.HasPrefix(c.Storage.Endpoint, "https://") {
+		// S3 refuses SSE-C keys over plain HTTP
+		add("encryption.presigned_sse_c: requires an https storage.endpoint")
+	}
+
+	switch c.Logging.Mode {
+	case "INTERNAL":
+	case "EXTERNAL", "SYSLOG", "CEF":
+		if c.Logging.ServiceURL == "" {
+			add("logging.service_url: must be set when logging.mode is %s", c.Logging.Mode)
+		}
+	default:
+		add("logging.mode: must be INTERNAL, EXTERNAL, SYSLOG or CEF, got %q", c.Logging.Mode)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		add("tracing.exporter: must be none, otlp or stdout, got %q", c.Tracing.Exporter)
+	}
+
+	if c.Worker.StatusCheckInterval <= 0 {
+		add("worker.status_check_interval: must be positive")
+	}
+	if c.Worker.PendingExpiry <= 0 {
+		add("worker.pending_expiry: must be positive")
+	}
+	if c.Worker.ScanInterval <= 0 {
+		add("worker.scan_interval: must be positive")
+	}
+	if c.Worker.ReconcileInterval < 0 {
+		add("worker.reconcile_interval: must not be negative")
+	}
+	if c.Worker.PurgeInterval <= 0 {
+		add("worker.purge_interval: must be positive")
+	}
+	if c.Delete.GracePeriod < 0 {
+		add("delete.grace_period: must not be negative")
+	}
+	if c.Download.BundleMaxItems < 1 {
+		add("download.bundle_max_items: must be at least 1")
+	}
+	if c.Download.ArchiveMaxEntries < 1 {
+		add("download.archive_max_entries: must be at least 1")
+	}
+	if c.Versions.Keep < 0 {
+		add("versions.keep: must not be negative")
+	}
+	if c.S3Gateway.Enabled {
+		if c.S3Gateway.Port < 1 || c.S3Gateway.Port > 65535 || c.S3Gateway.Port == c.Server.Port {
+			add("s3_gateway.port: must be between 1 and 65535 and differ from server.port, got %d", c.S3Gateway.Port)
+		}
+		if len(c.S3Gateway.Credentials) == 0 {
+			add("s3_gateway.enabled: requires s3_gateway.credentials to sign requests with")
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(c.S3Gateway.Credentials)) {
+		cred := c.S3Gateway.Credentials[id]
+		if id == "" || strings.ContainsAny(id, "/ ") {
+			add("s3_gateway.credentials: access key ID %q must not be empty or contain '/' or spaces", id)
+		}
+		if len(cred.Secret) < 16 {
+			add("s3_gateway.credentials.%s.secret: must be at least 16 characters", id)
+		}
+		if slices.Contains(c.Admin.APIKeys, cred.Secret) {
+			add("s3_gateway.credentials.%s.secret: must not be an admin.api_keys entry", id)
+		}
+	}
+	if c.S3Gateway.Region == "" {
+		add("s3_gateway.region: must not be empty")
+	}
+	if c.S3Gateway.MultipartExpiry <= 0 {
+		add("s3_gateway.multipart_expiry: must be positive")
+	}
+	if c.Bulk.MaxItems < 1 {
+		add("bulk.max_items: must be at least 1")
+	}
+	if c.Bulk.JobRetention <= 0 {
+		add("bulk.job_retention: must be positive")
+	}
+	if c.Worker.IntentTimeout <= 0 {
+		add("worker.intent_timeout: must be positive")
+	}
+
+	return errors.Join(errs...)
+}
+
+// File returns the path of the config file cfg was loaded from, if any
+func (c *Config) File() string {
+	return c.file
+}
+
+var current atomic.Pointer[Config]
+
+// Get returns the active configuration. Callers must treat it as read-only.
+func Get() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
+	}
+	return Default()
+}
+
+// Set installs cfg as the active configuration
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// Duration is a time.Duration written as "30s", "5m" in config files and env vars
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(string(text)))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (expected e.g. 30s, 5m, 1h)", string(text))
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ByteSize is a size in bytes, written as a plain number or with a unit ("512MiB", "10GB")
+type ByteSize int64
+
+const (
+	KiB ByteSize = 1 << 10
+	MiB ByteSize = 1 << 20
+	GiB ByteSize = 1 << 30
+	TiB ByteSize = 1 << 40
+)
+
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"TIB", TiB}, {"GIB", GiB}, {"MIB", MiB}, {"KIB", KiB},
+	{"TB", 1000 * 1000 * 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"MB", 1000 * 1000}, {"KB", 1000},
+	{"B", 1},
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range []struct {
+		suffix string
+		size   ByteSize
+	}{{"TiB", TiB}, {"GiB", GiB}, {"MiB", MiB}, {"KiB", KiB}} {
+		if b != 0 && b%unit.size == 0 {
+			return strconv.FormatInt(int64(b/unit.size), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	multiplier := ByteSize(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q (expected e.g. 1048576, 512MiB, 10GB)", string(text))
+	}
+	*b = ByteSize(n) * multiplier
+	return nil
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load resolves the configuration from defaults, the config file, environment variables
+// and command line flags (in increasing order of precedence) and validates it.
+// The config file is taken from -config or CONFIG_FILE; .yaml/.yml and .toml are supported.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML or TOML config file (env CONFIG_FILE)")
+	flagValues := map[string]*flagValue{}
+	walkLeaves(reflect.ValueOf(cfg).Elem(), "", func(leaf leafField) {
+		if leaf.value.Kind() == reflect.Map {
+			return // Nested settings like per project policies only come from the file
+		}
+		fv := &flagValue{isBool: leaf.value.Kind() == reflect.Bool}
+		flagValues[leaf.path] = fv
+		usage := leaf.field.Tag.Get("help")
+		if env := leaf.field.Tag.Get("env"); env != "" {
+			usage += " (env " + env + ")"
+		}
+		fs.Var(fv, leaf.path, usage)
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// 1. Config file
+	if *configPath != "" {
+		if err := loadFile(cfg, *configPath); err != nil {
+			return nil, err
+		}
+		cfg.file = *configPath
+	}
+
+	// 2. Environment, then 3. flags
+	var errs []error
+	walkLeaves(reflect.ValueOf(cfg).Elem(), "", func(leaf leafField) {
+		if env := leaf.field.Tag.Get("env"); env != "" {
+			if raw, ok := os.LookupEnv(env); ok && raw != "" {
+				if err := setLeaf(leaf.value, raw); err != nil {
+					errs = append(errs, fmt.Errorf("%s (env %s): %w", leaf.path, env, err))
+				}
+			}
+		}
+		if fv := flagValues[leaf.path]; fv != nil && fv.set {
+			if err := setLeaf(leaf.value, fv.raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s (flag -%s): %w", leaf.path, leaf.path, err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// loadFile decodes a YAML or TOML file on top of cfg, rejecting unknown keys so typos surface
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			var strictErr *toml.StrictMissingError
+			if errors.As(err, &strictErr) {
+				return fmt.Errorf("failed to parse config file %s: unknown keys:\n%s", path, strictErr.String())
+			}
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	return nil
+}
+
+type leafField struct {
+	path  string
+	field reflect.StructField
+	value reflect.Value
+}
+
+// walkLeaves calls fn for every non-struct field, with its dotted yaml key path
+func walkLeaves(v reflect.Value, prefix string, fn func(leafField)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			walkLeaves(fv, path, fn)
+			continue
+		}
+		fn(leafField{path: path, field: field, value: fv})
+	}
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// setLeaf parses raw into a leaf field
+func setLeaf(v reflect.Value, raw string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// flagValue records a flag's raw string so it can be applied after the file and env
+type flagValue struct {
+	raw    string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string { return f.raw }
+
+func (f *flagValue) Set(s string) error {
+	f.raw = s
+	f.set = true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
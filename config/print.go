@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// Redacted returns a copy of cfg with every secret field masked
+func Redacted(cfg *Config) *Config {
+	clone := *cfg
+	walkLeaves(reflect.ValueOf(&clone).Elem(), "", func(leaf leafField) {
+		if leaf.field.Tag.Get("secret") != "true" {
+			return
+		}
+		switch {
+		case leaf.value.Kind() == reflect.String && leaf.value.String() != "":
+			leaf.value.SetString(redacted)
+		case leaf.value.Kind() == reflect.Slice && leaf.value.Type().Elem().Kind() == reflect.String && leaf.value.Len() > 0:
+			// The clone shares the slice with cfg, so it gets a new one
+			masked := reflect.MakeSlice(leaf.value.Type(), leaf.value.Len(), leaf.value.Len())
+			for i := range masked.Len() {
+				masked.Index(i).SetString(redacted)
+			}
+			leaf.value.Set(masked)
+		case leaf.value.Kind() == reflect.Map && leaf.value.Type().Elem().Kind() == reflect.Struct && leaf.value.Len() > 0:
+			// Keys such as access key IDs stay visible, the secret fields of the values are masked
+			masked := reflect.MakeMapWithSize(leaf.value.Type(), leaf.value.Len())
+			for iter := leaf.value.MapRange(); iter.Next(); {
+				value := reflect.New(iter.Value().Type()).Elem()
+				value.Set(iter.Value())
+				for i := range value.NumField() {
+					if value.Type().Field(i).Tag.Get("secret") == "true" && value.Field(i).Kind() == reflect.String {
+						value.Field(i).SetString(redacted)
+					}
+				}
+				masked.SetMapIndex(iter.Key(), value)
+			}
+			leaf.value.Set(masked)
+		}
+	})
+	return &clone
+}
+
+// Print writes the effective configuration as YAML with secrets redacted
+func Print(w io.Writer, cfg *Config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(Redacted(cfg)); err != nil {
+		return fmt.Errorf("failed to render configuration: %w", err)
+	}
+	return enc.Close()
+}
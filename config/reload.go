@@ -0,0 +1,170 @@
+package config
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	Path            string
+	Old             string
+	New             string
+	RequiresRestart bool
+}
+
+// Diff lists the settings that differ between old and new. Secret values are redacted.
+func Diff(old, new *Config) []Change {
+	newValues := map[string]reflect.Value{}
+	walkLeaves(reflect.ValueOf(new).Elem(), "", func(leaf leafField) {
+		newValues[leaf.path] = leaf.value
+	})
+
+	var changes []Change
+	walkLeaves(reflect.ValueOf(old).Elem(), "", func(leaf leafField) {
+		newValue := newValues[leaf.path]
+		if reflect.DeepEqual(leaf.value.Interface(), newValue.Interface()) {
+			return
+		}
+		change := Change{
+			Path:            leaf.path,
+			Old:             render(leaf.value),
+			New:             render(newValue),
+			RequiresRestart: leaf.field.Tag.Get("reload") == "restart",
+		}
+		if leaf.field.Tag.Get("secret") == "true" {
+			change.Old, change.New = redacted, redacted
+		}
+		changes = append(changes, change)
+	})
+	return changes
+}
+
+// render formats a leaf value the way it is written in config files
+func render(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// keepRestartSettings copies the settings that need a restart from running into next,
+// so Get keeps describing what the process actually uses until it is restarted.
+func keepRestartSettings(running, next *Config) {
+	runningValues := map[string]reflect.Value{}
+	walkLeaves(reflect.ValueOf(running).Elem(), "", func(leaf leafField) {
+		runningValues[leaf.path] = leaf.value
+	})
+	walkLeaves(reflect.ValueOf(next).Elem(), "", func(leaf leafField) {
+		if leaf.field.Tag.Get("reload") == "restart" {
+			leaf.value.Set(runningValues[leaf.path])
+		}
+	})
+}
+
+// Reload re-resolves the configuration with the same args as Load and installs it with Set.
+// Settings that need a restart keep their running value. Invalid configurations are
+// rejected and the active one stays in place.
+func Reload(args []string) ([]Change, error) {
+	next, err := Load(args)
+	if err != nil {
+		return nil, err
+	}
+
+	running := Get()
+	changes := Diff(running, next)
+	keepRestartSettings(running, next)
+	Set(next)
+	return changes, nil
+}
+
+// Watch reloads the configuration on SIGHUP and whenever the config file's modification
+// time changes (checked every server.reload_interval), calling apply with the previous
+// and the new configuration after each reload that changed something. It returns when
+// ctx is cancelled.
+func Watch(ctx context.Context, args []string, apply func(old, new *Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		path := Get().File()
+		lastMod := modTime(path)
+
+		timer := time.NewTimer(pollInterval())
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Println("Config: SIGHUP received, reloading")
+			case <-timer.C:
+				timer.Reset(pollInterval())
+				if Get().Server.ReloadInterval <= 0 || modTime(path).Equal(lastMod) {
+					continue
+				}
+				log.Printf("Config: %s changed, reloading", path)
+			}
+			lastMod = modTime(path)
+
+			old := Get()
+			changes, err := Reload(args)
+			if err != nil {
+				log.Printf("Config: reload failed, keeping the current configuration: %v", err)
+				continue
+			}
+			if len(changes) == 0 {
+				log.Println("Config: reloaded, no settings changed")
+				continue
+			}
+			for _, change := range changes {
+				if change.RequiresRestart {
+					log.Printf("Config: %s changed (%s -> %s) but requires a restart to take effect", change.Path, change.Old, change.New)
+				} else {
+					log.Printf("Config: %s changed (%s -> %s)", change.Path, change.Old, change.New)
+				}
+			}
+			apply(old, Get())
+		}
+	}()
+}
+
+// pollInterval is the current server.reload_interval. With polling disabled the timer
+// still wakes up now and then, so re-enabling it through SIGHUP takes effect.
+func pollInterval() time.Duration {
+	if interval := Get().Server.ReloadInterval.Std(); interval > 0 {
+		return interval
+	}
+	return time.Minute
+}
+
+// modTime returns the modification time of path, zero if there is no file
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,140 @@
+package contenttype
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"ArtifactService/config"
+)
+
+// SniffLen is how many leading bytes Detect looks at
+const SniffLen = 512
+
+// Detect returns the media type of content from its first bytes (without parameters).
+// It extends http.DetectContentType with executables, which it reports as octet-stream.
+func Detect(head []byte) string {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("MZ")):
+		return "application/vnd.microsoft.portable-executable"
+	case bytes.HasPrefix(head, []byte("\x7fELF")):
+		return "application/x-executable"
+	case bytes.HasPrefix(head, []byte{0xfe, 0xed, 0xfa, 0xce}), bytes.HasPrefix(head, []byte{0xfe, 0xed, 0xfa, 0xcf}),
+		bytes.HasPrefix(head, []byte{0xce, 0xfa, 0xed, 0xfe}), bytes.HasPrefix(head, []byte{0xcf, 0xfa, 0xed, 0xfe}):
+		return "application/x-mach-binary"
+	case bytes.HasPrefix(head, []byte("#!")):
+		return "text/x-shellscript"
+	}
+
+	return MediaType(http.DetectContentType(head))
+}
+
+// MediaType strips parameters and normalizes case, "Text/Plain; charset=utf-8" -> "text/plain"
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// Policy is an allow/deny list of media type patterns
+type Policy struct {
+	Allow []string
+	Deny  []string
+}
+
+// ForProject returns the global policy with the project's overrides applied
+func ForProject(cfg config.ContentConfig, project string) Policy {
+	policy := Policy{Allow: cfg.Allow, Deny: cfg.Deny}
+	if override, ok := cfg.Projects[project]; ok && project != "" {
+		if len(override.Allow) > 0 {
+			policy.Allow = override.Allow
+		}
+		policy.Deny = append(slices.Clone(policy.Deny), override.Deny...)
+	}
+	return policy
+}
+
+// Check returns an error naming the rule mediaType violates, nil if it is accepted
+func (p Policy) Check(mediaType string) error {
+	mediaType = MediaType(mediaType)
+	for _, pattern := range p.Deny {
+		if matches(pattern, mediaType) {
+			return fmt.Errorf("content type %s is not allowed", mediaType)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if matches(pattern, mediaType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("content type %s is not in the allowed types %v", mediaType, p.Allow)
+}
+
+// CheckUpload applies the project policy and an optional token restriction to both
+// the declared and the detected type of an upload. detected may be empty when the
+// content isn't available yet (presigned uploads before completion).
+func CheckUpload(project string, tokenAllowed []string, declared, detected string) error {
+	policies := []Policy{ForProject(config.Get().Content, project)}
+	if len(tokenAllowed) > 0 {
+		policies = append(policies, Policy{Allow: tokenAllowed})
+	}
+
+	for _, policy := range policies {
+		if declared != "" {
+			if err := policy.Check(declared); err != nil {
+				return fmt.Errorf("declared %w", err)
+			}
+		}
+		if detected != "" {
+			if err := policy.Check(detected); err != nil {
+				return fmt.Errorf("detected %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// SplitList parses a comma separated list of patterns as stored in the tokens table
+func SplitList(s string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(s, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// ValidatePatterns rejects patterns that aren't media types like image/png or image/*
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") || strings.Contains(pattern, ",") {
+			return fmt.Errorf("%q is not a media type pattern like image/png or image/*", pattern)
+		}
+	}
+	return nil
+}
+
+// matches reports whether mediaType matches pattern ("image/png", "image/*" or "*/*")
+func matches(pattern, mediaType string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return false
+}
@@ -1,21 +1,30 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	_ "modernc.org/sqlite"
 )
 
 var DB *sql.DB
 
-func InitDB() {
+// InitDB opens the SQLite database at path and ensures the schema exists
+func InitDB(path string) {
 	var err error
-	// Switch to SQLite, simple file based DB
-	connStr := "files.db"
-	
-	DB, err = sql.Open("sqlite", connStr)
+	// Switch to SQLite, simple file based DB. Writers from concurrent requests wait
+	// for each other's lock instead of failing with SQLITE_BUSY.
+	connStr := path + "?_pragma=busy_timeout(5000)"
+
+	// Wrap the driver so every query becomes a span under the calling request
+	DB, err = otelsql.Open("sqlite", connStr,
+		otelsql.WithAttributes(semconv.DBSystemSqlite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		log.Fatal("Failed to connect to database: ", err)
 	}
@@ -29,6 +38,25 @@ func InitDB() {
 	createTable()
 }
 
+// Close closes the database connection pool
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	return DB.Close()
+}
+
+// addColumn adds a column to an existing table if it isn't there yet
+func addColumn(table, column, definition string) {
+	if _, err := DB.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 1", column, table)); err == nil {
+		return
+	}
+	fmt.Printf("Migrating %s table: adding %s column...\n", table, column)
+	if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition)); err != nil {
+		log.Printf("Warning: Failed to add %s column: %v", column, err)
+	}
+}
+
 func createTable() {
 	// SQLite syntax
 	query := `
@@ -59,6 +87,25 @@ func createTable() {
 		}
 	}
 	
+	addColumn("Artifacts", "digest", "TEXT")
+	addColumn("Artifacts", "labels", "TEXT")
+	addColumn("Artifacts", "detected_content_type", "TEXT")
+	addColumn("Artifacts", "project", "TEXT")
+	addColumn("Artifacts", "upload_token", "TEXT")
+	addColumn("Artifacts", "scan_result", "TEXT")
+	addColumn("Artifacts", "scan_signature", "TEXT")
+	addColumn("Artifacts", "scanned_at", "TIMESTAMP")
+	addColumn("Artifacts", "content_encoding", "TEXT")
+	addColumn("Artifacts", "stored_size", "BIGINT")
+	addColumn("Artifacts", "encryption", "TEXT")
+	addColumn("Artifacts", "key_id", "TEXT")
+	addColumn("Artifacts", "wrapped_key", "TEXT")
+	addColumn("Artifacts", "deleted_at", "TIMESTAMP")
+	addColumn("Artifacts", "deleted_by", "TEXT")
+	addColumn("Artifacts", "deleted_status", "TEXT")
+	addColumn("Artifacts", "legal_hold", "INTEGER NOT NULL DEFAULT 0")
+	addColumn("Artifacts", "retain_until", "TIMESTAMP")
+
 	fmt.Println("Table 'Artifacts' ensured")
 
 	// Create tokens table
@@ -79,5 +126,152 @@ func createTable() {
 	if err != nil {
 		log.Fatal("Failed to create table tokens: ", err)
 	}
+	addColumn("tokens", "allowed_types", "TEXT")
+	addColumn("tokens", "project", "TEXT")
+	addColumn("tokens", "revoked_at", "TIMESTAMP")
+
 	fmt.Println("Table 'tokens' ensured")
+
+	// Storage changes in flight, see BeginIntent
+	queryIntents := `
+	CREATE TABLE IF NOT EXISTS intents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		operation TEXT NOT NULL,
+		artifact_uuid TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);`
+
+	_, err = DB.Exec(queryIntents)
+	if err != nil {
+		log.Fatal("Failed to create table intents: ", err)
+	}
+
+	fmt.Println("Table 'intents' ensured")
+
+	// Bulk jobs and their per-artifact results
+	queryBulk := `
+	CREATE TABLE IF NOT EXISTS bulk_jobs (
+		id TEXT PRIMARY KEY,
+		operation TEXT NOT NULL,
+		params TEXT NOT NULL,
+		status TEXT NOT NULL,
+		total INTEGER NOT NULL,
+		created_by TEXT,
+		admin TEXT,
+		created_at TIMESTAMP NOT NULL,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS bulk_items (
+		job_id TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		artifact_uuid TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		error TEXT,
+		token TEXT,
+		PRIMARY KEY (job_id, seq)
+	);`
+
+	_, err = DB.Exec(queryBulk)
+	if err != nil {
+		log.Fatal("Failed to create bulk job tables: ", err)
+	}
+
+	fmt.Println("Tables 'bulk_jobs' and 'bulk_items' ensured")
+
+	// Named versions: names, their immutable version history and movable tags
+	queryVersions := `
+	CREATE TABLE IF NOT EXISTS artifact_names (
+		name TEXT PRIMARY KEY,
+		keep_versions INTEGER,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS artifact_versions (
+		name TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		artifact_uuid TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (name, version)
+	);
+	CREATE TABLE IF NOT EXISTS artifact_tags (
+		name TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (name, tag)
+	);`
+
+	_, err = DB.Exec(queryVersions)
+	if err != nil {
+		log.Fatal("Failed to create version tables: ", err)
+	}
+
+	fmt.Println("Tables 'artifact_names', 'artifact_versions' and 'artifact_tags' ensured")
+
+	// Multipart uploads of the S3 gateway; the parts are files in s3_gateway.multipart_dir
+	queryMultipart := `
+	CREATE TABLE IF NOT EXISTS s3_multipart_uploads (
+		upload_id TEXT PRIMARY KEY,
+		bucket TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		content_type TEXT,
+		labels TEXT,
+		created_by TEXT,
+		created_at TIMESTAMP NOT NULL,
+		state TEXT NOT NULL DEFAULT 'open'
+	);
+	CREATE TABLE IF NOT EXISTS s3_multipart_parts (
+		upload_id TEXT NOT NULL,
+		part_number INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (upload_id, part_number)
+	);`
+
+	_, err = DB.Exec(queryMultipart)
+	if err != nil {
+		log.Fatal("Failed to create multipart upload tables: ", err)
+	}
+	addColumn("s3_multipart_uploads", "state", "TEXT NOT NULL DEFAULT 'open'")
+
+	fmt.Println("Tables 's3_multipart_uploads' and 's3_multipart_parts' ensured")
+
+	// Download tokens for bundles, artifacts is a JSON array of UUIDs
+	queryBundleTokens := `
+	CREATE TABLE IF NOT EXISTS bundle_tokens (
+		token TEXT PRIMARY KEY,
+		artifacts TEXT NOT NULL,
+		format TEXT NOT NULL,
+		name TEXT NOT NULL,
+		valid_from TIMESTAMP,
+		valid_to TIMESTAMP,
+		max_downloads BIGINT,
+		current_downloads BIGINT DEFAULT 0,
+		allowed_cidr TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	_, err = DB.Exec(queryBundleTokens)
+	if err != nil {
+		log.Fatal("Failed to create table bundle_tokens: ", err)
+	}
+
+	fmt.Println("Table 'bundle_tokens' ensured")
+}
+
+// GetUsage returns the artifact count and the bytes all artifacts take up in storage,
+// which is less than their total size when they are compressed at rest
+func GetUsage(ctx context.Context) (fileCount int64, usedSpace int64, err error) {
+	fileCount, usedSpace, _, err = GetUsageDetails(ctx)
+	return fileCount, usedSpace, err
+}
+
+// GetUsageDetails is GetUsage plus the total uncompressed size of all artifacts
+func GetUsageDetails(ctx context.Context) (fileCount, usedSpace, logicalSpace int64, err error) {
+	// COALESCE(SUM(...), 0) handles the case where the table is empty (returns 0 instead of NULL).
+	// stored_size is only known for uploads through the service, presigned uploads are stored as is.
+	err = DB.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(COALESCE(stored_size, size)), 0), COALESCE(SUM(size), 0) FROM Artifacts").
+		Scan(&fileCount, &usedSpace, &logicalSpace)
+	return fileCount, usedSpace, logicalSpace, err
 }
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Operations recorded in the intents table
+const (
+	IntentUpload = "upload"
+	IntentDelete = "delete"
+)
+
+// Execer is implemented by *sql.DB and *sql.Tx, so intents can be written in the
+// transaction that records the outcome of the operation
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Queryer is implemented by *sql.DB and *sql.Tx, so checks can run in the transaction
+// whose outcome depends on them
+type Queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Intent is a storage change that was started but not yet recorded as done
+type Intent struct {
+	ID           int64
+	Operation    string
+	ArtifactUUID string
+	CreatedAt    time.Time
+}
+
+// BeginIntent records that operation is about to change the storage object of uuid and
+// returns the intent ID. The caller removes it with EndIntent once the database matches
+// storage again; intents left behind by a crash or failure are completed or rolled back
+// by the reconciler.
+func BeginIntent(ctx context.Context, exec Execer, operation, uuid string) (int64, error) {
+	res, err := exec.ExecContext(ctx, "INSERT INTO intents (operation, artifact_uuid, created_at) VALUES (?, ?, ?)",
+		operation, uuid, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// EndIntent removes an intent whose operation is complete
+func EndIntent(ctx context.Context, exec Execer, id int64) error {
+	_, err := exec.ExecContext(ctx, "DELETE FROM intents WHERE id = ?", id)
+	return err
+}
+
+// HasIntent reports whether an operation on uuid is still in flight
+func HasIntent(ctx context.Context, uuid string) (bool, error) {
+	var exists bool
+	err := DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM intents WHERE artifact_uuid = ?)", uuid).Scan(&exists)
+	return exists, err
+}
+
+// ListIntents returns all open intents, oldest first
+func ListIntents(ctx context.Context) ([]Intent, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id, operation, artifact_uuid, created_at FROM intents ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var intents []Intent
+	for rows.Next() {
+		var i Intent
+		if err := rows.Scan(&i.ID, &i.Operation, &i.ArtifactUUID, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		intents = append(intents, i)
+	}
+	return intents, rows.Err()
+}
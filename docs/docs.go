@@ -17,7 +17,7 @@ const docTemplate = `{
     "paths": {
         "/artifact-service/v1/artifacts/": {
             "get": {
-                "description": "Retrieves a list of all uploaded artifacts with their metadata",
+                "description": "Retrieves a list of all uploaded artifacts with their metadata. Deleted artifacts are listed in the trash instead.",
                 "produces": [
                     "application/json"
                 ],
@@ -47,7 +47,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Uploads a file and saves metadata to the database",
+                "description": "Uploads a file and saves metadata to the database. The multipart body is spooled before it is stored; large files are better sent to PUT /artifact-service/v1/artifacts/{uuid}/content.",
                 "consumes": [
                     "multipart/form-data"
                 ],
@@ -65,6 +65,24 @@ const docTemplate = `{
                         "name": "file",
                         "in": "formData",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Project, selects the content type policy (content_types.projects)",
+                        "name": "project",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Labels as a JSON object, e.g. {\\",
+                        "name": "labels",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project; the upload becomes the next version of project/name",
+                        "name": "name",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
@@ -86,6 +104,24 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -99,15 +135,15 @@ const docTemplate = `{
             }
         },
         "/artifact-service/v1/artifacts/{uuid}": {
-            "delete": {
-                "description": "Deletes an artifact by its UUID from both database and storage",
+            "get": {
+                "description": "Returns the metadata of one artifact, including its storage ETag and whether the object exists in storage (in_storage).\nin_storage is omitted if storage could not be reached.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "files"
                 ],
-                "summary": "Delete an artifact",
+                "summary": "Get artifact metadata",
                 "parameters": [
                     {
                         "type": "string",
@@ -121,10 +157,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/models.Artifact"
                         }
                     },
                     "404": {
@@ -146,18 +179,16 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/artifact-service/v1/artifacts/{uuid}/action/downloadFile": {
-            "get": {
-                "description": "Downloads a file by its UUID",
+            },
+            "delete": {
+                "description": "Moves an artifact to the trash: it gets the DELETED status, can no longer be downloaded and its tokens are revoked.\nIt can be restored until delete.grace_period has passed, then the purge worker removes it from the database and storage.\nWith a grace period of 0 the artifact is removed right away; if storage can't be reached the content is removed later by the reconciler (202).\nArtifacts under a legal hold or retention period can't be deleted (423).",
                 "produces": [
-                    "application/octet-stream"
+                    "application/json"
                 ],
                 "tags": [
                     "files"
                 ],
-                "summary": "Download a file",
+                "summary": "Delete an artifact",
                 "parameters": [
                     {
                         "type": "string",
@@ -171,11 +202,14 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "file"
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "202": {
+                        "description": "Accepted",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -183,33 +217,20 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
                                 "type": "string"
                             }
                         }
-                    }
-                }
-            }
-        },
-        "/artifact-service/v1/storage/usage": {
-            "get": {
-                "description": "Retrieves current storage usage including total space, used space, remaining space, and file count.",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "storage"
-                ],
-                "summary": "Get storage usage statistics",
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    },
+                    "423": {
+                        "description": "Locked",
                         "schema": {
-                            "$ref": "#/definitions/handlers.StorageUsage"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "500": {
@@ -224,40 +245,83 @@ const docTemplate = `{
                 }
             }
         },
-        "/artifacts/upload/{token}": {
-            "post": {
-                "description": "Generate a presigned upload URL using a token, enforcing constraints. Client uploads directly to S3.",
-                "consumes": [
-                    "application/json"
-                ],
+        "/artifact-service/v1/artifacts/{uuid}/action/downloadFile": {
+            "get": {
+                "description": "Downloads a file by its UUID. Supports Range/If-Range for resuming (206, multiple ranges as multipart/byteranges)\nand If-None-Match/If-Modified-Since (304) using the storage ETag and Last-Modified.\nArtifacts compressed at rest are sent as stored with Content-Encoding if Accept-Encoding allows it and no Range is requested, otherwise decompressed.",
                 "produces": [
-                    "application/json"
+                    "application/octet-stream"
                 ],
                 "tags": [
-                    "tokens"
+                    "files"
                 ],
-                "summary": "Upload file with Token",
+                "summary": "Download a file",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Upload Token",
-                        "name": "token",
+                        "description": "File UUID",
+                        "name": "uuid",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Upload metadata",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.UploadRequest"
-                        }
+                        "enum": [
+                            "attachment",
+                            "inline"
+                        ],
+                        "type": "string",
+                        "description": "inline to display previewable types (download.inline_types) in the browser",
+                        "name": "disposition",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Byte ranges, e.g. bytes=0-1023",
+                        "name": "Range",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag or date; the range is only honoured if the file is unchanged",
+                        "name": "If-Range",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the client already has",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Date of the client's copy",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "gzip and/or zstd to receive compressed artifacts as stored",
+                        "name": "Accept-Encoding",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Not modified"
+                    },
+                    "403": {
+                        "description": "Artifact is QUARANTINED",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -265,8 +329,8 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "403": {
-                        "description": "Forbidden",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -274,8 +338,17 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "409": {
+                        "description": "Artifact still SCANNING",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "410": {
+                        "description": "Artifact is in the trash",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -283,6 +356,9 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "416": {
+                        "description": "Range not satisfiable"
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -293,36 +369,88 @@ const docTemplate = `{
                         }
                     }
                 }
+            },
+            "head": {
+                "description": "Returns the download headers (Content-Length, Content-Type, ETag, Last-Modified) without a body.\nX-Artifact-In-Storage tells whether the object exists in storage; when it is false the sizes come from the database.\nFor artifacts compressed at rest the headers depend on Accept-Encoding and Range like for GET.",
+                "tags": [
+                    "files"
+                ],
+                "summary": "Check a file without downloading it",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Headers only"
+                    },
+                    "403": {
+                        "description": "Artifact is QUARANTINED"
+                    },
+                    "404": {
+                        "description": "Artifact not found"
+                    },
+                    "409": {
+                        "description": "Artifact still SCANNING"
+                    },
+                    "410": {
+                        "description": "Artifact is in the trash"
+                    },
+                    "500": {
+                        "description": "Database or storage error"
+                    }
+                }
             }
         },
-        "/artifacts/{token}": {
+        "/artifact-service/v1/artifacts/{uuid}/action/extract": {
             "get": {
-                "description": "Download a file using a token, enforcing constraints. Returns a 302 redirect to S3 presigned URL for direct download.",
+                "description": "Streams a single file out of a stored ZIP, TAR or tar.gz artifact, decompressed. Only the entry is read from storage for ZIP and TAR.\nThe entry is always sent as an attachment, whatever its type.",
                 "produces": [
                     "application/octet-stream"
                 ],
                 "tags": [
-                    "tokens"
+                    "files"
                 ],
-                "summary": "Download file with Presigned URL",
+                "summary": "Download one entry of an archive",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Access Token",
-                        "name": "token",
+                        "description": "File UUID",
+                        "name": "uuid",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path of the entry, as listed by /entries",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
                     }
                 ],
                 "responses": {
-                    "302": {
-                        "description": "Redirect to S3 presigned URL",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Path missing or a directory",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
                     "403": {
-                        "description": "Forbidden",
+                        "description": "Artifact is QUARANTINED",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -339,45 +467,17 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "409": {
+                        "description": "Artifact is not a ZIP or TAR archive, still SCANNING, or the entry can't be decompressed",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
                                 "type": "string"
                             }
                         }
-                    }
-                }
-            }
-        },
-        "/genDownloadPresignedURL": {
-            "post": {
-                "description": "Generates a token for temporary file download access with constraints. Requires existing artifact UUID.",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "tokens"
-                ],
-                "summary": "Generate a Download Token",
-                "parameters": [
-                    {
-                        "description": "Token constraints with artifact UUID",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.GenTokenRequest"
-                        }
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    },
+                    "410": {
+                        "description": "Artifact is in the trash",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -385,8 +485,8 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "413": {
+                        "description": "TAR entry not among the first download.archive_max_entries",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -406,9 +506,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/genUploadPresignedURL": {
+        "/artifact-service/v1/artifacts/{uuid}/complete": {
             "post": {
-                "description": "Generates a token for temporary file upload access with constraints. No artifact UUID needed.",
+                "description": "Allows client to notify server that upload to S3 is complete. Server verifies file existence and updates status.",
                 "consumes": [
                     "application/json"
                 ],
@@ -416,18 +516,16 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tokens"
+                    "files"
                 ],
-                "summary": "Generate an Upload Token",
+                "summary": "Mark upload as complete",
                 "parameters": [
                     {
-                        "description": "Token constraints without artifact UUID",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.GenUploadTokenRequest"
-                        }
+                        "type": "string",
+                        "description": "Artifact UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -440,8 +538,17 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -460,49 +567,2528 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.StorageUsage": {
-            "type": "object",
-            "properties": {
-                "file_count": {
+        },
+        "/artifact-service/v1/artifacts/{uuid}/content": {
+            "put": {
+                "description": "Streams the request body straight to storage, without multipart buffering. The body may be sent with Content-Length or chunked.\nThe client picks the artifact UUID, which must not exist yet. The filename comes from the filename query parameter or a Content-Disposition header.\nUploads larger than upload.max_size are refused before reading (Content-Length) or aborted when the limit is crossed (chunked).",
+                "consumes": [
+                    "application/octet-stream"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "Upload a file as the raw request body",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Artifact UUID chosen by the client",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filename, defaults to the Content-Disposition filename or the UUID",
+                        "name": "filename",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Project, selects the content type policy (content_types.projects)",
+                        "name": "project",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Labels as a JSON object, e.g. {\\",
+                        "name": "labels",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project; the upload becomes the next version of project/name once complete",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "description": "File content",
+                        "name": "file",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/artifacts/{uuid}/entries": {
+            "get": {
+                "description": "Lists the files and directories of a stored ZIP, TAR or tar.gz artifact, recognised by its content.\nFor ZIP only the central directory is read from storage with ranged reads; TAR is read header by header, skipping large entries; tar.gz has to be read in full.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "List the entries of an archive",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ArchiveListing"
+                        }
+                    },
+                    "403": {
+                        "description": "Artifact is QUARANTINED",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Artifact is not a ZIP or TAR archive, or still SCANNING",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "410": {
+                        "description": "Artifact is in the trash",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "413": {
+                        "description": "Archive has more than download.archive_max_entries entries",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/artifacts/{uuid}/legal-hold": {
+            "put": {
+                "description": "While an artifact is under a legal hold it can't be deleted or overwritten, and a deleted one isn't purged from the trash.\nAnyone may set a hold; releasing it needs an admin key (Authorization: Bearer \u003ckey\u003e). With storage.object_lock enabled the hold is applied to the object as well.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "locks"
+                ],
+                "summary": "Set or release a legal hold",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Hold on or off, with the reason for the audit log",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.LegalHoldRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Artifact"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/artifacts/{uuid}/restore": {
+            "post": {
+                "description": "Takes an artifact out of the trash with the status it had before it was deleted. Only possible until delete.grace_period has passed.\nTokens revoked by the delete stay revoked.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "Restore a deleted artifact",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Artifact"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/artifacts/{uuid}/retention": {
+            "put": {
+                "description": "Until retain_until the artifact can't be deleted or overwritten, and a deleted one isn't purged from the trash.\nAnyone may set or extend it; shortening or clearing it (retain_until null) needs an admin key (Authorization: Bearer \u003ckey\u003e).\nWith storage.object_lock enabled the retention is applied to the object as well; in COMPLIANCE mode it can't be shortened at all.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "locks"
+                ],
+                "summary": "Set the retention period",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "End of the retention period, with the reason for the audit log",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.RetentionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Artifact"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/bulk": {
+            "post": {
+                "description": "Applies an operation to many artifacts in the background: delete, relabel, retention or token.\nThe artifacts are given as a uuids list or selected by a filter (project, status, labels, created_after, created_before), resolved when the job is created.\nEvery artifact follows the rules of the single endpoint, e.g. locked artifacts aren't deleted and shortening retention needs an admin key.\nProgress is reported by GET /artifact-service/v1/bulk/{id}, the per-artifact outcome by GET /artifact-service/v1/bulk/{id}/results.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bulk"
+                ],
+                "summary": "Start a bulk job",
+                "parameters": [
+                    {
+                        "description": "Operation, selection and the operation's parameters",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BulkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.BulkJob"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/bulk/{id}": {
+            "get": {
+                "description": "Returns the job's status (queued, running or completed) and how many of its artifacts succeeded, failed or were skipped so far.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bulk"
+                ],
+                "summary": "Get the progress of a bulk job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.BulkJob"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/bulk/{id}/results": {
+            "get": {
+                "description": "Returns the outcome for every selected artifact in selection order, pending until the job gets to it. Token jobs include the issued tokens and their URLs.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bulk"
+                ],
+                "summary": "Get the per-artifact results of a bulk job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "pending",
+                            "succeeded",
+                            "failed",
+                            "skipped"
+                        ],
+                        "type": "string",
+                        "description": "Only results with this status",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.BulkItemResult"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/bundles": {
+            "post": {
+                "description": "Streams a ZIP (zip64) or tar.gz archive built on the fly, with one entry per artifact named after its filename (\"name (2).ext\" for duplicates).\nThe artifacts are listed in uuids, or selected by labels (and optionally project); only UPLOADED artifacts are included.\nA listed artifact that can't be downloaded fails the request with 409, artifacts selected by labels that can't be downloaded are left out.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/zip",
+                    "application/gzip"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "Download several artifacts as one archive",
+                "parameters": [
+                    {
+                        "description": "Artifacts, archive format and name",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BundleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/names/{project}/{name}/retention": {
+            "put": {
+                "description": "Overrides versions.keep for one name: the number of newest downloadable versions kept, 0 to keep all, null for the default.\nApplied right away; older untagged versions are moved to the trash like a delete, locked ones stay. Returns the UUIDs of the trashed artifacts.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Set how many versions a name keeps",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Versions to keep",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.VersionRetentionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/names/{project}/{name}/tags/{tag}": {
+            "put": {
+                "description": "Creates or moves a tag, e.g. \"stable\" or \"release-1.2\", so the version can be fetched by it. Tagged versions are exempt from version retention.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Point a tag at a version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag, starting with a letter; latest is reserved",
+                        "name": "tag",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Version to tag",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TagRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ArtifactVersion"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Version is deleted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes a tag; the version it pointed at is subject to version retention again.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Remove a tag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag",
+                        "name": "tag",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/names/{project}/{name}/versions": {
+            "get": {
+                "description": "Returns the version history of the named artifact \"project/name\", newest first, with its tags and the version \"latest\" resolves to.\nVersions whose artifact is in the trash are listed with status DELETED; purged ones without an artifact.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "List the versions of a name",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.VersionListing"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Records an existing artifact of the same project as the next version of \"project/name\", creating the name on first use.\nVersion numbers count up from 1 and are never reused; an artifact can be a version of one name only. Version retention is applied afterwards.\nUploads can do the same in one step with the name form field or query parameter.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Add an artifact as the next version of a name",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Artifact to add",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.AddVersionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.ArtifactVersion"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Artifact is deleted, of another project or already a version",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/names/{project}/{name}/versions/{version}": {
+            "get": {
+                "description": "Resolves a version number, \"latest\" (the newest uploaded and scanned version) or a tag to the version and its artifact.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Get a version of a name",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version number, latest or a tag",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ArtifactVersion"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/names/{project}/{name}/versions/{version}/download": {
+            "get": {
+                "description": "Downloads the artifact of a version number, \"latest\" or a tag, like downloadFile (ranges, conditional requests, content encoding).\nThe resolved version and artifact are returned in X-Artifact-Version and X-Artifact-UUID.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Download a version of a name",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name within the project",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version number, latest or a tag",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Not modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Artifact is QUARANTINED",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Artifact still SCANNING",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "410": {
+                        "description": "Artifact is in the trash",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/storage/usage": {
+            "get": {
+                "description": "Retrieves current storage usage including total space, used space, remaining space, and file count.\nused_space counts stored bytes, so compressed artifacts count with their compressed size; logical_space is the uncompressed total.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "storage"
+                ],
+                "summary": "Get storage usage statistics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.StorageUsage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifact-service/v1/trash": {
+            "get": {
+                "description": "Returns the artifacts in the trash, most recently deleted first, with when they will be purged.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "List deleted artifacts",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Artifact"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifacts/bundle/{token}": {
+            "get": {
+                "description": "Streams the ZIP or tar.gz archive of a bundle token, enforcing its constraints. Artifacts that can no longer be downloaded are left out.",
+                "produces": [
+                    "application/zip",
+                    "application/gzip"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Download a bundle with Presigned URL",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bundle Token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "403": {
+                        "description": "Token constraint violated",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "410": {
+                        "description": "None of the artifacts can be downloaded anymore",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifacts/upload/{token}": {
+            "post": {
+                "description": "Generate a presigned upload URL using a token, enforcing constraints. Client uploads directly to S3.\nThe declared content_type must pass the token's allowed_types and the project policy; the uploaded bytes are checked again on completion.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Upload file with Token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Upload Token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Upload metadata",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UploadRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/artifacts/{token}": {
+            "get": {
+                "description": "Download a file using a token, enforcing constraints. Returns a 302 redirect to S3 presigned URL for direct download.\nEncrypted artifacts, and artifacts compressed at rest with an encoding Accept-Encoding doesn't allow, are streamed by the service instead (200).",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Download file with Presigned URL",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access Token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "attachment",
+                            "inline"
+                        ],
+                        "type": "string",
+                        "description": "inline to display previewable types (download.inline_types) in the browser",
+                        "name": "disposition",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "gzip and/or zstd to be redirected to compressed artifacts as stored",
+                        "name": "Accept-Encoding",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Content of an encrypted or compressed artifact, streamed by the service",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "302": {
+                        "description": "Redirect to S3 presigned URL",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Token constraint violated or artifact QUARANTINED",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Artifact still SCANNING",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/genBundlePresignedURL": {
+            "post": {
+                "description": "Generates a token that downloads several artifacts as one ZIP or tar.gz archive, with the constraints of a download token.\nThe artifacts are resolved now, as for POST /artifact-service/v1/bundles. Artifacts deleted or otherwise made unavailable later are left out of the archive.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Generate a Bundle Download Token",
+                "parameters": [
+                    {
+                        "description": "Artifacts, archive format and name, token constraints",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.GenBundleTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/genDownloadPresignedURL": {
+            "post": {
+                "description": "Generates a token for temporary file download access with constraints. Requires existing artifact UUID.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Generate a Download Token",
+                "parameters": [
+                    {
+                        "description": "Token constraints with artifact UUID",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.GenTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/genUploadPresignedURL": {
+            "post": {
+                "description": "Generates a token for temporary file upload access with constraints. No artifact UUID needed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Generate an Upload Token",
+                "parameters": [
+                    {
+                        "description": "Token constraints without artifact UUID",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.GenUploadTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Returns 200 as long as the process is serving HTTP. Does not check dependencies.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Pings the database, checks the storage bucket with HeadBucket and verifies the status checker ran recently. Returns 503 if any dependency fails.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ReadinessResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ReadinessResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/repo/{project}/{path}": {
+            "get": {
+                "description": "Downloads the latest version of a repository path, like downloadFile (ranges, conditional requests, content encoding).\nAn older version or a tag can be picked with the version query parameter. The resolved version and artifact are returned in X-Artifact-Version and X-Artifact-UUID.\nOnly UPLOADED versions are served; deleted versions and ones still being scanned or quarantined answer 404.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "repository"
+                ],
+                "summary": "Resolve a file from the generic repository",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "File path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version number or tag, default latest",
+                        "name": "version",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Not modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Stores the request body under a repository path, like a Maven or raw repository deploy (curl -T, mvn deploy).\nThe path is the versioned name project/path: every PUT adds a new artifact as its next version, earlier ones stay in the version history.\nWhile the version the path serves is under a legal hold or retention period it can't be replaced (423).",
+                "consumes": [
+                    "application/octet-stream"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "repository"
+                ],
+                "summary": "Deploy a file to the generic repository",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "File path, e.g. com/acme/app/1.0/app-1.0.jar",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "File content",
+                        "name": "file",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "423": {
+                        "description": "Locked",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Moves every version of a repository path to the trash, so the path is gone; they can be restored like any deleted artifact.\nIf any version is under a legal hold or retention period nothing is deleted (423).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "repository"
+                ],
+                "summary": "Delete a file from the generic repository",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "File path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "423": {
+                        "description": "Locked",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "head": {
+                "description": "Returns the headers GET would return for the latest version of a repository path (or the one picked with version), without the body.",
+                "tags": [
+                    "repository"
+                ],
+                "summary": "Check a file in the generic repository",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Project",
+                        "name": "project",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "File path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version number or tag, default latest",
+                        "name": "version",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Headers only"
+                    },
+                    "404": {
+                        "description": "Not found"
+                    },
+                    "500": {
+                        "description": "Database or storage error"
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.DependencyStatus": {
+            "type": "object",
+            "properties": {
+                "details": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "ok / fail",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.ReadinessResponse": {
+            "type": "object",
+            "properties": {
+                "dependencies": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/handlers.DependencyStatus"
+                    }
+                },
+                "status": {
+                    "description": "ready / not_ready",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.StorageUsage": {
+            "type": "object",
+            "properties": {
+                "file_count": {
                     "type": "integer"
                 },
-                "remaining_space": {
-                    "type": "integer"
+                "logical_space": {
+                    "description": "total size of the artifacts before compression",
+                    "type": "integer"
+                },
+                "remaining_space": {
+                    "type": "integer"
+                },
+                "total_space": {
+                    "type": "integer"
+                },
+                "usage_percent": {
+                    "type": "number"
+                },
+                "used_space": {
+                    "description": "bytes in storage, after compression",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.AddVersionRequest": {
+            "type": "object",
+            "required": [
+                "artifact_uuid"
+            ],
+            "properties": {
+                "artifact_uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ArchiveEntry": {
+            "type": "object",
+            "properties": {
+                "compressed_size": {
+                    "description": "ZIP only",
+                    "type": "integer"
+                },
+                "dir": {
+                    "type": "boolean"
+                },
+                "modified": {
+                    "type": "string"
+                },
+                "name": {
+                    "description": "path inside the archive",
+                    "type": "string"
                 },
-                "total_space": {
+                "size": {
                     "type": "integer"
+                }
+            }
+        },
+        "models.ArchiveListing": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ArchiveEntry"
+                    }
                 },
-                "usage_percent": {
-                    "type": "number"
+                "format": {
+                    "description": "zip, tar or tar.gz",
+                    "type": "string"
                 },
-                "used_space": {
-                    "type": "integer"
+                "uuid": {
+                    "type": "string"
                 }
             }
         },
         "models.Artifact": {
             "type": "object",
             "properties": {
+                "content_encoding": {
+                    "description": "Compression at rest; Size stays the uncompressed size",
+                    "type": "string"
+                },
                 "content_type": {
+                    "description": "as declared by the client",
                     "type": "string"
                 },
                 "created_at": {
                     "type": "string"
                 },
+                "deleted_at": {
+                    "description": "Soft delete: set while the artifact is DELETED and can still be restored",
+                    "type": "string"
+                },
+                "deleted_by": {
+                    "type": "string"
+                },
+                "detected_content_type": {
+                    "description": "sniffed from the content",
+                    "type": "string"
+                },
+                "digest": {
+                    "description": "sha256:\u003chex\u003e, only known for uploads through the service",
+                    "type": "string"
+                },
+                "encryption": {
+                    "description": "Encryption at rest: envelope (by the service) or sse-c (by storage), with the master key wrapping the data key",
+                    "type": "string"
+                },
+                "etag": {
+                    "description": "Storage state, only filled in by the single artifact endpoint",
+                    "type": "string"
+                },
                 "filename": {
                     "type": "string"
                 },
+                "in_storage": {
+                    "type": "boolean"
+                },
+                "key_id": {
+                    "type": "string"
+                },
+                "labels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "legal_hold": {
+                    "description": "Locks: while either is in effect the artifact can't be deleted or overwritten",
+                    "type": "boolean"
+                },
+                "project": {
+                    "type": "string"
+                },
+                "purge_at": {
+                    "description": "when the purge worker removes it for good",
+                    "type": "string"
+                },
+                "retain_until": {
+                    "type": "string"
+                },
+                "scan_result": {
+                    "description": "Malware scan verdict: clean, infected or error; empty if never scanned",
+                    "type": "string"
+                },
+                "scan_signature": {
+                    "type": "string"
+                },
+                "scanned_at": {
+                    "type": "string"
+                },
                 "size": {
                     "type": "integer"
                 },
+                "status": {
+                    "type": "string"
+                },
+                "stored_size": {
+                    "description": "bytes in storage, known once the upload is complete",
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ArtifactVersion": {
+            "type": "object",
+            "properties": {
+                "artifact": {
+                    "description": "nil once the artifact is purged",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Artifact"
+                        }
+                    ]
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BulkFilter": {
+            "type": "object",
+            "properties": {
+                "created_after": {
+                    "type": "string"
+                },
+                "created_before": {
+                    "type": "string"
+                },
+                "labels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "project": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.BulkItemResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "presigned_url": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "pending, succeeded, failed or skipped",
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
                 "uuid": {
                     "type": "string"
                 }
             }
         },
+        "models.BulkJob": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "processed": {
+                    "type": "integer"
+                },
+                "skipped": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "queued, running or completed",
+                    "type": "string"
+                },
+                "succeeded": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BulkRequest": {
+            "type": "object",
+            "required": [
+                "operation"
+            ],
+            "properties": {
+                "filter": {
+                    "$ref": "#/definitions/models.BulkFilter"
+                },
+                "labels": {
+                    "description": "relabel: labels to add or change",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "operation": {
+                    "description": "delete, relabel, retention or token",
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "recorded in the audit log",
+                    "type": "string"
+                },
+                "remove_labels": {
+                    "description": "relabel: labels to remove",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "retain_until": {
+                    "description": "retention: end of the period, null to clear",
+                    "type": "string"
+                },
+                "token": {
+                    "description": "token: constraints of the issued tokens",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.BulkTokenParams"
+                        }
+                    ]
+                },
+                "uuids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.BulkTokenParams": {
+            "type": "object",
+            "properties": {
+                "allowed_cidr": {
+                    "type": "string"
+                },
+                "max_downloads": {
+                    "type": "integer"
+                },
+                "valid_from": {
+                    "type": "string"
+                },
+                "valid_to": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.BundleRequest": {
+            "type": "object",
+            "properties": {
+                "format": {
+                    "description": "zip (default) or tar.gz",
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "every label must match",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "description": "archive filename without extension, default \"artifacts\"",
+                    "type": "string"
+                },
+                "project": {
+                    "description": "with labels: only artifacts of this project",
+                    "type": "string"
+                },
+                "uuids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.GenBundleTokenRequest": {
+            "type": "object",
+            "properties": {
+                "allowed_cidr": {
+                    "type": "string"
+                },
+                "format": {
+                    "description": "zip (default) or tar.gz",
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "every label must match",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "max_downloads": {
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "archive filename without extension, default \"artifacts\"",
+                    "type": "string"
+                },
+                "project": {
+                    "description": "with labels: only artifacts of this project",
+                    "type": "string"
+                },
+                "uuids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "valid_from": {
+                    "type": "string"
+                },
+                "valid_to": {
+                    "type": "string"
+                }
+            }
+        },
         "models.GenTokenRequest": {
             "type": "object",
             "required": [
@@ -532,9 +3118,19 @@ const docTemplate = `{
                 "allowed_cidr": {
                     "type": "string"
                 },
+                "allowed_types": {
+                    "description": "e.g. [\"image/*\", \"application/pdf\"]",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "max_uploads": {
                     "type": "integer"
                 },
+                "project": {
+                    "type": "string"
+                },
                 "valid_from": {
                     "type": "string"
                 },
@@ -543,6 +3139,42 @@ const docTemplate = `{
                 }
             }
         },
+        "models.LegalHoldRequest": {
+            "type": "object",
+            "required": [
+                "enabled"
+            ],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.RetentionRequest": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "type": "string"
+                },
+                "retain_until": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.TagRequest": {
+            "type": "object",
+            "required": [
+                "version"
+            ],
+            "properties": {
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
         "models.UploadRequest": {
             "type": "object",
             "required": [
@@ -557,10 +3189,53 @@ const docTemplate = `{
                 "filename": {
                     "type": "string"
                 },
+                "labels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "size": {
                     "type": "integer"
                 }
             }
+        },
+        "models.VersionListing": {
+            "type": "object",
+            "properties": {
+                "keep_versions": {
+                    "description": "overrides versions.keep",
+                    "type": "integer"
+                },
+                "latest": {
+                    "description": "newest UPLOADED version",
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "versions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ArtifactVersion"
+                    }
+                }
+            }
+        },
+        "models.VersionRetentionRequest": {
+            "type": "object",
+            "properties": {
+                "keep_versions": {
+                    "type": "integer"
+                }
+            }
         }
     }
 }`
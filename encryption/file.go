@@ -0,0 +1,113 @@
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// FileProvider wraps data keys with master keys from a local key file. Every line
+// holds "<key id> <base64 32 byte key>"; the last key wraps new data keys, the older
+// ones are kept to unwrap data keys that haven't been rotated yet. Blank lines and
+// lines starting with # are ignored.
+type FileProvider struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewFileProvider reads the master keys from path
+func NewFileProvider(path string) (*FileProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && info.Mode().Perm()&0o077 != 0 {
+		log.Printf("Warning: key file %s is accessible by other users (mode %v)", path, info.Mode().Perm())
+	}
+
+	p := &FileProvider{keys: map[string]cipher.AEAD{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<key id> <base64 key>\"", path, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != DataKeySize {
+			return nil, fmt.Errorf("%s:%d: key %s must be %d bytes, base64 encoded", path, line, fields[0], DataKeySize)
+		}
+		if _, ok := p.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %s", path, line, fields[0])
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		p.keys[fields[0]] = aead
+		p.current = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if p.current == "" {
+		return nil, fmt.Errorf("%s: no keys found", path)
+	}
+	return p, nil
+}
+
+func (p *FileProvider) Name() string { return "file" }
+
+func (p *FileProvider) CurrentKeyID() string { return p.current }
+
+// Wrap seals dataKey with the current master key; the wrapped key is nonce || ciphertext
+func (p *FileProvider) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	aead := p.keys[p.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return p.current, aead.Seal(nonce, nonce, dataKey, []byte(p.current)), nil
+}
+
+func (p *FileProvider) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %s is not in the key file", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with master key %s: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// GenerateKeyLine returns a key file line with a new random master key. An empty id
+// defaults to the current date and time.
+func GenerateKeyLine(id string) (string, error) {
+	if id == "" {
+		id = time.Now().UTC().Format("20060102-150405")
+	}
+	key, err := NewDataKey()
+	if err != nil {
+		return "", err
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,147 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"ArtifactService/config"
+)
+
+// Encryption modes recorded in Artifacts.encryption
+const (
+	// ModeEnvelope is content encrypted by the service with the artifact's data key
+	ModeEnvelope = "envelope"
+	// ModeSSEC is content encrypted by storage with the artifact's data key as SSE-C key
+	ModeSSEC = "sse-c"
+)
+
+// KeyProvider holds the master keys that wrap per-artifact data keys, e.g. a local key
+// file or a KMS. Data keys are stored wrapped next to the artifact and only unwrapped
+// while its content is read or written.
+type KeyProvider interface {
+	// Name identifies the provider in logs
+	Name() string
+	// CurrentKeyID is the master key Wrap uses; data keys wrapped with another one are rotated
+	CurrentKeyID() string
+	// Wrap encrypts a data key with the current master key
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts a data key wrapped with master key keyID
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Factory creates a KeyProvider from the encryption settings
+type Factory func(cfg config.EncryptionConfig) (KeyProvider, error)
+
+var (
+	mu        sync.RWMutex
+	instance  KeyProvider
+	factories = map[string]Factory{
+		"file":    func(cfg config.EncryptionConfig) (KeyProvider, error) { return NewFileProvider(cfg.KeyFile) },
+		"command": func(cfg config.EncryptionConfig) (KeyProvider, error) { return NewCommandProvider(cfg.KeyCommand) },
+	}
+)
+
+// ErrDisabled is returned when a data key has to be unwrapped but no provider is configured
+var ErrDisabled = errors.New("encryption.provider is not configured")
+
+// Register makes a provider selectable as encryption.provider, e.g. a KMS client
+// compiled into the service. It must be called before Init.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[name] = factory
+}
+
+// Init sets up the configured key provider; provider none disables encryption of new uploads
+func Init(cfg config.EncryptionConfig) error {
+	var next KeyProvider
+	if cfg.Provider != "none" {
+		mu.RLock()
+		factory, ok := factories[cfg.Provider]
+		mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown encryption provider %q", cfg.Provider)
+		}
+		provider, err := factory(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize encryption provider %s: %w", cfg.Provider, err)
+		}
+		next = provider
+		log.Printf("Encryption initialized: provider=%s, current key=%s", provider.Name(), provider.CurrentKeyID())
+	} else {
+		log.Println("Encryption disabled, new uploads are stored unencrypted")
+	}
+
+	mu.Lock()
+	instance = next
+	mu.Unlock()
+	return nil
+}
+
+// Get returns the configured key provider, nil if encryption is disabled
+func Get() KeyProvider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return instance
+}
+
+// Enabled reports whether new uploads are encrypted
+func Enabled() bool {
+	return Get() != nil
+}
+
+// NewWrappedKey returns a new data key together with its key ID and wrapped form
+// (base64, as stored in Artifacts.wrapped_key)
+func NewWrappedKey(ctx context.Context) (dataKey []byte, keyID, wrapped string, err error) {
+	provider := Get()
+	if provider == nil {
+		return nil, "", "", ErrDisabled
+	}
+	dataKey, err = NewDataKey()
+	if err != nil {
+		return nil, "", "", err
+	}
+	keyID, raw, err := provider.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return dataKey, keyID, base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// UnwrapKey returns the data key stored as wrapped (base64) under master key keyID
+func UnwrapKey(ctx context.Context, keyID, wrapped string) ([]byte, error) {
+	provider := Get()
+	if provider == nil {
+		return nil, ErrDisabled
+	}
+	raw, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key: %w", err)
+	}
+	return provider.Unwrap(ctx, keyID, raw)
+}
+
+// RewrapKey wraps a stored data key with the current master key. It returns the new
+// key ID and wrapped key, or changed false if keyID already is the current key.
+func RewrapKey(ctx context.Context, keyID, wrapped string) (newKeyID, newWrapped string, changed bool, err error) {
+	provider := Get()
+	if provider == nil {
+		return "", "", false, ErrDisabled
+	}
+	if keyID == provider.CurrentKeyID() {
+		return keyID, wrapped, false, nil
+	}
+	dataKey, err := UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return "", "", false, err
+	}
+	newKeyID, raw, err := provider.Wrap(ctx, dataKey)
+	if err != nil {
+		return "", "", false, err
+	}
+	return newKeyID, base64.StdEncoding.EncodeToString(raw), true, nil
+}
@@ -0,0 +1,218 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream format: the content is split into ChunkSize chunks, each sealed with
+// AES-256-GCM under the artifact's data key. The nonce is the chunk index plus a flag
+// on the last chunk, so chunks can't be reordered or the stream cut short, and the
+// artifact UUID is the additional data, so objects can't be swapped. Empty content is
+// a single empty last chunk.
+const (
+	// ChunkSize is the plaintext size of every chunk but the last
+	ChunkSize = 64 * 1024
+	// DataKeySize is the size of a data key (AES-256)
+	DataKeySize = 32
+
+	tagSize         = 16
+	sealedChunkSize = ChunkSize + tagSize
+)
+
+// ErrAuthentication is returned when stored content doesn't decrypt, i.e. it was
+// modified, truncated or belongs to another artifact
+var ErrAuthentication = errors.New("encrypted content failed authentication")
+
+// NewDataKey returns a random key for one artifact
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// SealedSize returns the stored size of n bytes of content
+func SealedSize(n int64) int64 {
+	return n + chunkCount(n)*tagSize
+}
+
+// PlainSize returns the content size of a stream of sealed bytes, the inverse of SealedSize
+func PlainSize(sealed int64) int64 {
+	chunks := (sealed + sealedChunkSize - 1) / sealedChunkSize
+	return sealed - max(chunks, 1)*tagSize
+}
+
+// ChunkStart returns the chunk holding content offset and where that chunk starts in the sealed stream
+func ChunkStart(offset int64) (chunk, sealedOffset int64) {
+	chunk = offset / ChunkSize
+	return chunk, chunk * sealedChunkSize
+}
+
+func chunkCount(n int64) int64 {
+	return max((n+ChunkSize-1)/ChunkSize, 1)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(nonce []byte, index int64, last bool) []byte {
+	binary.BigEndian.PutUint64(nonce[:8], uint64(index))
+	nonce[8], nonce[9], nonce[10], nonce[11] = 0, 0, 0, 0
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptReader struct {
+	aead  cipher.AEAD
+	aad   []byte
+	src   io.Reader
+	index int64
+	nonce []byte
+	buf   []byte // one chunk plus one byte to tell whether more follows
+	carry bool   // buf[ChunkSize] is the first byte of the next chunk
+	out   []byte
+	done  bool
+}
+
+// NewEncryptReader returns the sealed stream of src under key, bound to aad (the artifact UUID)
+func NewEncryptReader(src io.Reader, key, aad []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		aead:  aead,
+		aad:   aad,
+		src:   src,
+		nonce: make([]byte, aead.NonceSize()),
+		buf:   make([]byte, ChunkSize+1),
+		out:   make([]byte, 0, sealedChunkSize),
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// seal reads the next chunk from src and seals it into out
+func (r *encryptReader) seal() error {
+	n := 0
+	if r.carry {
+		r.buf[0] = r.buf[ChunkSize]
+		n = 1
+	}
+	m, err := io.ReadFull(r.src, r.buf[n:])
+	n += m
+
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+		r.carry = false
+	case err != nil:
+		return err
+	default:
+		// A full chunk and at least one more byte
+		n = ChunkSize
+		r.carry = true
+	}
+
+	r.out = r.aead.Seal(r.out[:0], chunkNonce(r.nonce, r.index, last), r.buf[:n], r.aad)
+	r.index++
+	r.done = last
+	return nil
+}
+
+type decryptReader struct {
+	aead  cipher.AEAD
+	aad   []byte
+	src   io.Reader
+	index int64
+	last  int64
+	size  int64 // sealed size of the whole stream
+	nonce []byte
+	buf   []byte
+	out   []byte
+}
+
+// NewDecryptReader decrypts src, which must start at the beginning of chunk first
+// (see ChunkStart) of a sealed stream of sealedSize bytes in total
+func NewDecryptReader(src io.Reader, key, aad []byte, first, sealedSize int64) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		aead:  aead,
+		aad:   aad,
+		src:   src,
+		index: first,
+		last:  chunkCount(PlainSize(sealedSize)) - 1,
+		size:  sealedSize,
+		nonce: make([]byte, aead.NonceSize()),
+		buf:   make([]byte, sealedChunkSize),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.index > r.last {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// open reads and authenticates the next chunk from src
+func (r *decryptReader) open() error {
+	size := int64(sealedChunkSize)
+	if r.index == r.last {
+		size = r.size - r.last*sealedChunkSize
+	}
+	if size < tagSize {
+		return ErrAuthentication
+	}
+	if _, err := io.ReadFull(r.src, r.buf[:size]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, err := r.aead.Open(r.buf[:0], chunkNonce(r.nonce, r.index, r.index == r.last), r.buf[:size], r.aad)
+	if err != nil {
+		return fmt.Errorf("%w (chunk %d)", ErrAuthentication, r.index)
+	}
+	r.out = plain
+	r.index++
+	return nil
+}
@@ -0,0 +1,154 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+var testAAD = []byte("550e8400-e29b-41d4-a716-446655440000")
+
+// seal encrypts content under key, reading src one byte at a time so chunk
+// boundaries fall inside reads
+func seal(t *testing.T, key, content []byte) []byte {
+	t.Helper()
+	r, err := NewEncryptReader(iotest.OneByteReader(bytes.NewReader(content)), key, testAAD)
+	if err != nil {
+		t.Fatalf("NewEncryptReader: %v", err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	return sealed
+}
+
+// open decrypts sealed from the start of chunk first
+func open(key, sealed []byte, first int64) ([]byte, error) {
+	_, sealedOffset := ChunkStart(first * ChunkSize)
+	r, err := NewDecryptReader(bytes.NewReader(sealed[sealedOffset:]), key, testAAD, first, int64(len(sealed)))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := testKey(t)
+	tests := []struct {
+		name   string
+		size   int
+		chunks int64
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"chunk minus one", ChunkSize - 1, 1},
+		{"one chunk", ChunkSize, 1},
+		{"chunk plus one", ChunkSize + 1, 2},
+		{"two chunks", 2 * ChunkSize, 2},
+		{"two chunks plus one", 2*ChunkSize + 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := make([]byte, tt.size)
+			rand.Read(content)
+
+			sealed := seal(t, key, content)
+			if got, want := int64(len(sealed)), SealedSize(int64(tt.size)); got != want {
+				t.Fatalf("sealed %d bytes, SealedSize says %d", got, want)
+			}
+			if want := int64(tt.size) + tt.chunks*tagSize; int64(len(sealed)) != want {
+				t.Fatalf("sealed %d bytes, want %d chunks = %d", len(sealed), tt.chunks, want)
+			}
+			if got := PlainSize(int64(len(sealed))); got != int64(tt.size) {
+				t.Fatalf("PlainSize(%d) = %d, want %d", len(sealed), got, tt.size)
+			}
+
+			// Decrypting from any chunk yields the content from that chunk on
+			for first := int64(0); first < tt.chunks; first++ {
+				plain, err := open(key, sealed, first)
+				if err != nil {
+					t.Fatalf("decrypt from chunk %d: %v", first, err)
+				}
+				if !bytes.Equal(plain, content[first*ChunkSize:]) {
+					t.Fatalf("decrypt from chunk %d: content differs", first)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkStart(t *testing.T) {
+	tests := []struct {
+		offset, chunk, sealedOffset int64
+	}{
+		{0, 0, 0},
+		{ChunkSize - 1, 0, 0},
+		{ChunkSize, 1, sealedChunkSize},
+		{2*ChunkSize + 5, 2, 2 * sealedChunkSize},
+	}
+	for _, tt := range tests {
+		chunk, sealedOffset := ChunkStart(tt.offset)
+		if chunk != tt.chunk || sealedOffset != tt.sealedOffset {
+			t.Errorf("ChunkStart(%d) = %d, %d, want %d, %d", tt.offset, chunk, sealedOffset, tt.chunk, tt.sealedOffset)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key := testKey(t)
+	content := make([]byte, 2*ChunkSize+100)
+	rand.Read(content)
+	sealed := seal(t, key, content)
+
+	swapped := append([]byte{}, sealed...)
+	copy(swapped, sealed[sealedChunkSize:2*sealedChunkSize])
+	copy(swapped[sealedChunkSize:], sealed[:sealedChunkSize])
+
+	flipped := append([]byte{}, sealed...)
+	flipped[ChunkSize+tagSize+10] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		key    []byte
+		aad    []byte
+	}{
+		{"flipped bit", flipped, key, testAAD},
+		{"swapped chunks", swapped, key, testAAD},
+		// Cut at a chunk boundary: the new last chunk wasn't sealed as the last one
+		{"truncated at chunk boundary", sealed[:2*sealedChunkSize], key, testAAD},
+		{"truncated in chunk", sealed[:len(sealed)-1], key, testAAD},
+		{"other artifact", sealed, key, []byte("another-uuid")},
+		{"other key", sealed, testKey(t), testAAD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewDecryptReader(bytes.NewReader(tt.sealed), tt.key, tt.aad, 0, int64(len(tt.sealed)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrAuthentication) {
+				t.Errorf("got %v, want ErrAuthentication", err)
+			}
+		})
+	}
+
+	// A stream shorter than its declared size fails too
+	r, _ := NewDecryptReader(bytes.NewReader(sealed[:sealedChunkSize]), key, testAAD, 0, int64(len(sealed)))
+	if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short stream: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/models"
+	"ArtifactService/storage"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Archive formats that can be listed and extracted, recognised by their magic bytes
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// tarSeekThreshold is the smallest tar entry skipped with a new ranged read; smaller
+// ones are read through, which is cheaper than another GetObject
+const tarSeekThreshold = 1 << 20
+
+var (
+	// errEntryNotFound is returned when the archive has no entry with the requested path
+	errEntryNotFound = errors.New("entry not found")
+	// errEntryIsDir is returned when the requested entry is a directory
+	errEntryIsDir = errors.New("entry is a directory")
+	// errTooManyEntries is returned when the archive has more than
+	// download.archive_max_entries entries
+	errTooManyEntries = errors.New("too many entries")
+)
+
+// archiveSource is the content of an archive artifact, readable at any offset
+type archiveSource struct {
+	uuid   string
+	format string
+	size   int64
+	r      io.ReadSeekCloser
+}
+
+// ListArchiveEntries godoc
+// @Summary      List the entries of an archive
+// @Description  Lists the files and directories of a stored ZIP, TAR or tar.gz artifact, recognised by its content.
+// @Description  For ZIP only the central directory is read from storage with ranged reads; TAR is read header by header, skipping large entries; tar.gz has to be read in full.
+// @Tags         files
+// @Produce      json
+// @Param        uuid  path      string  true  "File UUID"
+// @Success      200   {object}  models.ArchiveListing
+// @Failure      403   {object}  map[string]string  "Artifact is QUARANTINED"
+// @Failure      404   {object}  map[string]string
+// @Failure      409   {object}  map[string]string  "Artifact is not a ZIP or TAR archive, or still SCANNING"
+// @Failure      410   {object}  map[string]string  "Artifact is in the trash"
+// @Failure      413   {object}  map[string]string  "Archive has more than download.archive_max_entries entries"
+// @Failure      500   {object}  map[string]string
+// @Router       /artifact-service/v1/artifacts/{uuid}/entries [get]
+func ListArchiveEntries(c *gin.Context) {
+	src, ok := openArchive(c, c.Param("uuid"))
+	if !ok {
+		return
+	}
+	defer src.r.Close()
+
+	listing := models.ArchiveListing{UUID: src.uuid, Format: src.format, Entries: []models.ArchiveEntry{}}
+	var err error
+	if src.format == archiveZip {
+		err = listZip(src, &listing)
+	} else {
+		err = listTar(src, &listing)
+	}
+	if err != nil {
+		archiveError(c, src, err)
+		return
+	}
+	c.JSON(http.StatusOK, listing)
+}
+
+// ExtractArchiveEntry godoc
+// @Summary      Download one entry of an archive
+// @Description  Streams a single file out of a stored ZIP, TAR or tar.gz artifact, decompressed. Only the entry is read from storage for ZIP and TAR.
+// @Description  The entry is always sent as an attachment, whatever its type.
+// @Tags         files
+// @Produce      octet-stream
+// @Param        uuid  path      string  true  "File UUID"
+// @Param        path  query     string  true  "Path of the entry, as listed by /entries"
+// @Success      200   {file}    file
+// @Failure      400   {object}  map[string]string  "Path missing or a directory"
+// @Failure      403   {object}  map[string]string  "Artifact is QUARANTINED"
+// @Failure      404   {object}  map[string]string
+// @Failure      409   {object}  map[string]string  "Artifact is not a ZIP or TAR archive, still SCANNING, or the entry can't be decompressed"
+// @Failure      410   {object}  map[string]string  "Artifact is in the trash"
+// @Failure      413   {object}  map[string]string  "TAR entry not among the first download.archive_max_entries"
+// @Failure      500   {object}  map[string]string
+// @Router       /artifact-service/v1/artifacts/{uuid}/action/extract [get]
+func ExtractArchiveEntry(c *gin.Context) {
+	name := c.Query("path")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+
+	src, ok := openArchive(c, c.Param("uuid"))
+	if !ok {
+		return
+	}
+	defer src.r.Close()
+
+	var entry io.Reader
+	var size int64
+	var err error
+	if src.format == archiveZip {
+		var body io.ReadCloser
+		body, size, err = openZipEntry(src, name)
+		if err == nil {
+			defer body.Close()
+			entry = body
+		}
+	} else {
+		entry, size, err = openTarEntry(src, name)
+	}
+	if err != nil {
+		archiveError(c, src, err)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, size, contentType, metrics.CountingReader(entry, metrics.DownloadBytes), map[string]string{
+		"Content-Disposition": contentDisposition("attachment", path.Base(name)),
+	})
+}
+
+// openArchive opens the content of an artifact and recognises its archive format;
+// otherwise the error response is written and false returned
+func openArchive(c *gin.Context, uuid string) (*archiveSource, bool) {
+	ctx := c.Request.Context()
+
+	var size int64
+	var status sql.NullString
+	err := db.DB.QueryRowContext(ctx, "SELECT size, status FROM Artifacts WHERE uuid = ?", uuid).Scan(&size, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		} else {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, false
+	}
+	// Never hand out content that hasn't passed the malware scan, not even its listing
+	switch status.String {
+	case "DELETED":
+		c.JSON(http.StatusGone, gin.H{"error": "Artifact is deleted"})
+		return nil, false
+	case "SCANNING":
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is being scanned, try again later"})
+		return nil, false
+	case "QUARANTINED":
+		logger.Record(logger.ActionDownload, uuid, c.ClientIP(), "", "FAILED", "Blocked: artifact is quarantined")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Artifact is quarantined"})
+		return nil, false
+	}
+
+	stored, err := worker.LoadContent(ctx, uuid)
+	if err != nil {
+		log.Printf("Failed to load content of %s: %v", uuid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to read file"})
+		return nil, false
+	}
+	info, err := stored.Stat(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File content not found"})
+		} else {
+			log.Println("Failed to stat file in Ceph:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage error"})
+		}
+		return nil, false
+	}
+	stored.StoredSize = info.Size
+
+	// Content stored as is can be read at any offset with ranged reads; content
+	// compressed at rest has to be decompressed from the start
+	src := &archiveSource{uuid: uuid, size: size}
+	if stored.Encoding == "" {
+		src.size = stored.EncodedSize(info.Size)
+		src.r = storage.NewObjectReader(ctx, stored, src.size)
+	} else {
+		src.r = storage.NewDecodedReader(ctx, stored, size)
+	}
+
+	head := make([]byte, 512)
+	n, err := storage.NewReaderAt(src.r, src.size).ReadAt(head, 0)
+	if err == nil || err == io.EOF {
+		_, err = src.r.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		src.r.Close()
+		log.Printf("Failed to read %s: %v", uuid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage error"})
+		return nil, false
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		src.format = archiveZip
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		src.format = archiveTarGz
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		src.format = archiveTar
+	default:
+		src.r.Close()
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is not a ZIP or TAR archive"})
+		return nil, false
+	}
+	return src, true
+}
+
+// archiveError writes the response for an error reading an archive
+func archiveError(c *gin.Context, src *archiveSource, err error) {
+	switch {
+	case errors.Is(err, errEntryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+	case errors.Is(err, errEntryIsDir):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is a directory"})
+	case errors.Is(err, errTooManyEntries):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Archive has more than download.archive_max_entries (%d) entries", config.Get().Download.ArchiveMaxEntries)})
+	case errors.Is(err, zip.ErrAlgorithm):
+		c.JSON(http.StatusConflict, gin.H{"error": "Entry uses an unsupported compression method"})
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, tar.ErrHeader), errors.Is(err, gzip.ErrHeader), errors.Is(err, io.ErrUnexpectedEOF):
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is not a valid " + src.format + " archive"})
+	default:
+		log.Printf("Failed to read archive %s: %v", src.uuid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage error"})
+	}
+}
+
+func openZip(src *archiveSource) (*zip.Reader, error) {
+	zr, err := zip.NewReader(storage.NewReaderAt(src.r, src.size), src.size)
+	// Entries with absolute or ../ paths are listed as they are; nothing is written to disk
+	if errors.Is(err, zip.ErrInsecurePath) {
+		err = nil
+	}
+	return zr, err
+}
+
+func listZip(src *archiveSource, listing *models.ArchiveListing) error {
+	zr, err := openZip(src)
+	if err != nil {
+		return err
+	}
+	if len(zr.File) > config.Get().Download.ArchiveMaxEntries {
+		return errTooManyEntries
+	}
+	for _, f := range zr.File {
+		listing.Entries = append(listing.Entries, models.ArchiveEntry{
+			Name:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Modified:       f.Modified,
+			Dir:            f.FileInfo().IsDir(),
+		})
+	}
+	return nil
+}
+
+func openZipEntry(src *archiveSource, name string) (io.ReadCloser, int64, error) {
+	zr, err := openZip(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			return nil, 0, errEntryIsDir
+		}
+		body, err := f.Open()
+		return body, int64(f.UncompressedSize64), err
+	}
+	return nil, 0, errEntryNotFound
+}
+
+// openTar returns a tar reader over the archive. Plain TAR entries of at least
+// tarSeekThreshold bytes are skipped by seeking, so their content isn't fetched.
+func openTar(src *archiveSource) (*tar.Reader, error) {
+	if src.format == archiveTar {
+		return tar.NewReader(&tarSkipper{src.r}), nil
+	}
+	gz, err := gzip.NewReader(src.r)
+	if err != nil {
+		return nil, err
+	}
+	return tar.NewReader(gz), nil
+}
+
+func listTar(src *archiveSource, listing *models.ArchiveListing) error {
+	tr, err := openTar(src)
+	if err != nil {
+		return err
+	}
+	maxEntries := config.Get().Download.ArchiveMaxEntries
+	for entries := 1; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entries > maxEntries {
+			return errTooManyEntries
+		}
+		// Links, devices and the like have no content to extract
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		listing.Entries = append(listing.Entries, models.ArchiveEntry{
+			Name:     hdr.Name,
+			Size:     hdr.Size,
+			Modified: hdr.ModTime,
+			Dir:      hdr.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+// openTarEntry returns a reader positioned at the content of the entry. Names match
+// with or without a leading "./".
+func openTarEntry(src *archiveSource, name string) (io.Reader, int64, error) {
+	tr, err := openTar(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	name = strings.TrimPrefix(name, "./")
+	maxEntries := config.Get().Download.ArchiveMaxEntries
+	for entries := 1; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, 0, errEntryNotFound
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if entries > maxEntries {
+			return nil, 0, errTooManyEntries
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != name {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			return tr, hdr.Size, nil
+		case tar.TypeDir:
+			return nil, 0, errEntryIsDir
+		}
+	}
+}
+
+// tarSkipper lets archive/tar seek over large entries only; it skips smaller ones by
+// reading them, which keeps the current response body instead of starting a new one
+type tarSkipper struct {
+	r io.ReadSeeker
+}
+
+func (s *tarSkipper) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *tarSkipper) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent && offset > 0 && offset < tarSeekThreshold {
+		if _, err := io.CopyN(io.Discard, s.r, offset); err != nil {
+			return 0, err
+		}
+		return s.r.Seek(0, io.SeekCurrent)
+	}
+	return s.r.Seek(offset, whence)
+}
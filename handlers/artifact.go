@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/models"
+	"ArtifactService/storage"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// artifactColumns is the column list scanArtifact expects
+const artifactColumns = "uuid, filename, content_type, detected_content_type, project, size, status, digest, labels, created_at, scan_result, scan_signature, scanned_at, content_encoding, stored_size, encryption, key_id, deleted_at, deleted_by, legal_hold, retain_until"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanArtifact reads a row selected with artifactColumns
+func scanArtifact(row rowScanner) (models.Artifact, error) {
+	var artifact models.Artifact
+	var detected, project, status, digest, labels, scanResult, scanSignature, encoding, encryptionMode, keyID, deletedBy sql.NullString
+	var scannedAt, deletedAt, retainUntil sql.NullTime
+	var storedSize sql.NullInt64
+	err := row.Scan(&artifact.UUID, &artifact.Filename, &artifact.ContentType, &detected, &project, &artifact.Size, &status, &digest, &labels, &artifact.CreatedAt,
+		&scanResult, &scanSignature, &scannedAt, &encoding, &storedSize, &encryptionMode, &keyID, &deletedAt, &deletedBy, &artifact.LegalHold, &retainUntil)
+	if err != nil {
+		return artifact, err
+	}
+	artifact.DetectedContentType = detected.String
+	artifact.Project = project.String
+	artifact.Status = status.String
+	artifact.Digest = digest.String
+	artifact.ScanResult = scanResult.String
+	artifact.ScanSignature = scanSignature.String
+	artifact.ContentEncoding = encoding.String
+	artifact.StoredSize = storedSize.Int64
+	artifact.Encryption = encryptionMode.String
+	artifact.KeyID = keyID.String
+	if scannedAt.Valid {
+		artifact.ScannedAt = &scannedAt.Time
+	}
+	if deletedAt.Valid {
+		artifact.DeletedAt = &deletedAt.Time
+		artifact.DeletedBy = deletedBy.String
+		purgeAt := deletedAt.Time.Add(config.Get().Delete.GracePeriod.Std())
+		artifact.PurgeAt = &purgeAt
+	}
+	if retainUntil.Valid {
+		artifact.RetainUntil = &retainUntil.Time
+	}
+	if labels.String != "" {
+		if err := json.Unmarshal([]byte(labels.String), &artifact.Labels); err != nil {
+			log.Printf("Invalid labels stored for %s: %v", artifact.UUID, err)
+		}
+	}
+	return artifact, nil
+}
+
+// statContent loads how the artifact is stored and stats its object, which needs the
+// SSE-C key of artifacts encrypted by storage
+func statContent(ctx context.Context, uuid string) (*storage.Content, *storage.ObjectInfo, error) {
+	stored, err := worker.LoadContent(ctx, uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := stored.Stat(ctx)
+	if err != nil {
+		return stored, nil, err
+	}
+	stored.StoredSize = info.Size
+	return stored, info, nil
+}
+
+// encodeLabels returns the labels column value, NULL for no labels
+func encodeLabels(labels map[string]string) (sql.NullString, error) {
+	if len(labels) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// GetArtifact godoc
+// @Summary      Get artifact metadata
+// @Description  Returns the metadata of one artifact, including its storage ETag and whether the object exists in storage (in_storage).
+// @Description  in_storage is omitted if storage could not be reached.
+// @Tags         files
+// @Produce      json
+// @Param        uuid   path      string  true  "File UUID"
+// @Success      200  {object}  models.Artifact
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /artifact-service/v1/artifacts/{uuid} [get]
+func GetArtifact(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	uuid := c.Param("uuid")
+
+	artifact, err := scanArtifact(db.DB.QueryRowContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", uuid))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		} else {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	// Reconcile with storage; a storage outage doesn't fail the metadata lookup
+	_, info, err := statContent(ctx, uuid)
+	switch {
+	case err == nil:
+		inStorage := true
+		artifact.InStorage = &inStorage
+		artifact.ETag = info.ETag
+	case errors.Is(err, storage.ErrNotFound):
+		inStorage := false
+		artifact.InStorage = &inStorage
+	default:
+		log.Printf("Failed to stat %s in storage: %v", uuid, err)
+	}
+
+	c.JSON(http.StatusOK, artifact)
+}
+
+// HeadDownloadFile godoc
+// @Summary      Check a file without downloading it
+// @Description  Returns the download headers (Content-Length, Content-Type, ETag, Last-Modified) without a body.
+// @Description  X-Artifact-In-Storage tells whether the object exists in storage; when it is false the sizes come from the database.
+// @Description  For artifacts compressed at rest the headers depend on Accept-Encoding and Range like for GET.
+// @Tags         files
+// @Param        uuid   path      string  true  "File UUID"
+// @Success      200  "Headers only"
+// @Failure      403  "Artifact is QUARANTINED"
+// @Failure      404  "Artifact not found"
+// @Failure      409  "Artifact still SCANNING"
+// @Failure      410  "Artifact is in the trash"
+// @Failure      500  "Database or storage error"
+// @Router       /artifact-service/v1/artifacts/{uuid}/action/downloadFile [head]
+func HeadDownloadFile(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	uuid := c.Param("uuid")
+
+	artifact, err := scanArtifact(db.DB.QueryRowContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", uuid))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Status(http.StatusNotFound)
+		} else {
+			log.Println("Database error:", err)
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+	if artifact.Status == "DELETED" {
+		c.Status(http.StatusGone)
+		return
+	}
+	if downloadBlocked(c, uuid, artifact.Status) {
+		return
+	}
+
+	stored, info, err := statContent(ctx, uuid)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to stat %s in storage: %v", uuid, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Content-Type", artifact.ContentType)
+	c.Header("X-Artifact-Status", artifact.Status)
+	if artifact.Digest != "" {
+		c.Header("X-Artifact-Digest", artifact.Digest)
+	}
+	if artifact.DetectedContentType != "" {
+		c.Header("X-Artifact-Detected-Content-Type", artifact.DetectedContentType)
+	}
+
+	if info == nil {
+		c.Header("Content-Length", strconv.FormatInt(artifact.Size, 10))
+		c.Header("X-Artifact-In-Storage", "false")
+	} else {
+		// Mirror the representation GET would send for the same request
+		etag := artifactETag(c, info)
+		switch {
+		case artifact.ContentEncoding == "":
+			c.Header("Content-Length", strconv.FormatInt(stored.EncodedSize(info.Size), 10))
+		case sendEncoded(c.Request, artifact.ContentEncoding):
+			c.Header("Content-Length", strconv.FormatInt(stored.EncodedSize(info.Size), 10))
+			c.Header("Content-Encoding", artifact.ContentEncoding)
+			etag = encodedETag(etag, artifact.ContentEncoding)
+		default:
+			c.Header("Content-Length", strconv.FormatInt(artifact.Size, 10))
+		}
+		if artifact.ContentEncoding != "" {
+			c.Header("Vary", "Accept-Encoding")
+		}
+		c.Header("Accept-Ranges", "bytes")
+		c.Header("X-Artifact-In-Storage", "true")
+		if etag != "" {
+			c.Header("ETag", etag)
+		}
+		if !info.LastModified.IsZero() {
+			c.Header("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+	c.Status(http.StatusOK)
+}
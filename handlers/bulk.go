@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/middleware"
+	"ArtifactService/models"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// errTooManyItems is returned when a bulk selection exceeds bulk.max_items
+type errTooManyItems struct{ max int }
+
+func (e errTooManyItems) Error() string {
+	return fmt.Sprintf("the selection exceeds bulk.max_items (%d)", e.max)
+}
+
+// CreateBulkJob godoc
+// @Summary      Start a bulk job
+// @Description  Applies an operation to many artifacts in the background: delete, relabel, retention or token.
+// @Description  The artifacts are given as a uuids list or selected by a filter (project, status, labels, created_after, created_before), resolved when the job is created.
+// @Description  Every artifact follows the rules of the single endpoint, e.g. locked artifacts aren't deleted and shortening retention needs an admin key.
+// @Description  Progress is reported by GET /artifact-service/v1/bulk/{id}, the per-artifact outcome by GET /artifact-service/v1/bulk/{id}/results.
+// @Tags         bulk
+// @Accept       json
+// @Produce      json
+// @Param        request  body      models.BulkRequest  true  "Operation, selection and the operation's parameters"
+// @Success      202      {object}  models.BulkJob
+// @Failure      400      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/bulk [post]
+func CreateBulkJob(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req models.BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := worker.ValidateBulkRequest(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 1. Resolve the selection now, so the job works on a fixed set
+	maxItems := config.Get().Bulk.MaxItems
+	var uuids []string
+	var err error
+	if req.Filter == nil {
+		uuids, err = uniqueUUIDs(req.UUIDs, maxItems)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		uuids, err = selectArtifacts(ctx, req.Filter, maxItems)
+		if errors.As(err, new(errTooManyItems)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			log.Println("Failed to select artifacts:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if len(uuids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No artifacts selected"})
+		return
+	}
+
+	// 2. Record the job, the bulk worker takes it from there
+	admin := middleware.AdminActor(c)
+	job, err := worker.CreateBulkJob(ctx, req, uuids, c.ClientIP(), admin)
+	if err != nil {
+		log.Println("Failed to create bulk job:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	logger.Record(logger.ActionBulk, "", c.ClientIP(), admin, "SUCCESS",
+		fmt.Sprintf("Bulk %s job %s created for %d artifacts", job.Operation, job.ID, job.Total))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetBulkJob godoc
+// @Summary      Get the progress of a bulk job
+// @Description  Returns the job's status (queued, running or completed) and how many of its artifacts succeeded, failed or were skipped so far.
+// @Tags         bulk
+// @Produce      json
+// @Param        id   path      string  true  "Job ID"
+// @Success      200  {object}  models.BulkJob
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /artifact-service/v1/bulk/{id} [get]
+func GetBulkJob(c *gin.Context) {
+	job, err := worker.LoadBulkJob(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bulk job not found"})
+		} else {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// GetBulkResults godoc
+// @Summary      Get the per-artifact results of a bulk job
+// @Description  Returns the outcome for every selected artifact in selection order, pending until the job gets to it. Token jobs include the issued tokens and their URLs.
+// @Tags         bulk
+// @Produce      json
+// @Param        id      path      string  true   "Job ID"
+// @Param        status  query     string  false  "Only results with this status" Enums(pending, succeeded, failed, skipped)
+// @Success      200     {array}   models.BulkItemResult
+// @Failure      404     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /artifact-service/v1/bulk/{id}/results [get]
+func GetBulkResults(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id := c.Param("id")
+	if _, err := worker.LoadBulkJob(ctx, id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bulk job not found"})
+		} else {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	items, err := worker.ListBulkItems(ctx, id, c.Query("status"))
+	if err != nil {
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Token URLs point at this service, like those of GenDownloadPresignedURL
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	for i := range items {
+		if items[i].Token != "" {
+			items[i].PresignedURL = scheme + "://" + c.Request.Host + "/artifacts/" + items[i].Token
+		}
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// uniqueUUIDs validates a uuids list and drops duplicates, keeping the order
+func uniqueUUIDs(list []string, maxItems int) ([]string, error) {
+	seen := map[string]bool{}
+	var uuids []string
+	for _, raw := range list {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a UUID", raw)
+		}
+		if seen[id.String()] {
+			continue
+		}
+		seen[id.String()] = true
+		uuids = append(uuids, id.String())
+	}
+	if len(uuids) > maxItems {
+		return nil, errTooManyItems{maxItems}
+	}
+	return uuids, nil
+}
+
+// selectArtifacts returns the UUIDs of the artifacts matching filter, oldest first
+func selectArtifacts(ctx context.Context, filter *models.BulkFilter, maxItems int) ([]string, error) {
+	var where []string
+	var args []any
+	if filter.Project != "" {
+		where = append(where, "project = ?")
+		args = append(args, filter.Project)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	} else {
+		where = append(where, "IFNULL(status, '') != 'DELETED'")
+	}
+	for key, value := range filter.Labels {
+		where = append(where, "json_extract(labels, ?) = ?")
+		args = append(args, `$."`+strings.ReplaceAll(key, `"`, `\"`)+`"`, value)
+	}
+	if filter.CreatedAfter != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.CreatedAfter.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if filter.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.CreatedBefore.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	// One more than allowed tells a selection that is too large
+	query := "SELECT uuid FROM Artifacts WHERE " + strings.Join(where, " AND ") + " ORDER BY created_at LIMIT " + strconv.Itoa(maxItems+1)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uuids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(uuids) > maxItems {
+		return nil, errTooManyItems{maxItems}
+	}
+	return uuids, nil
+}
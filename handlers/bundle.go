@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/models"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// bundleEntry is an artifact to be written into a bundle
+type bundleEntry struct {
+	uuid     string
+	filename string
+	size     int64
+	modified time.Time
+	status   string
+}
+
+// bundleDownloadable reports whether an artifact with status can go into a bundle:
+// only artifacts that passed upload verification and the malware scan
+func bundleDownloadable(status string) bool {
+	return status == "" || status == "UPLOADED"
+}
+
+// DownloadBundle godoc
+// @Summary      Download several artifacts as one archive
+// @Description  Streams a ZIP (zip64) or tar.gz archive built on the fly, with one entry per artifact named after its filename ("name (2).ext" for duplicates).
+// @Description  The artifacts are listed in uuids, or selected by labels (and optionally project); only UPLOADED artifacts are included.
+// @Description  A listed artifact that can't be downloaded fails the request with 409, artifacts selected by labels that can't be downloaded are left out.
+// @Tags         files
+// @Accept       json
+// @Produce      application/zip
+// @Produce      application/gzip
+// @Param        request  body      models.BundleRequest  true  "Artifacts, archive format and name"
+// @Success      200      {file}    file
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/bundles [post]
+func DownloadBundle(c *gin.Context) {
+	var req models.BundleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	uuids, ok := resolveBundle(c, &req)
+	if !ok {
+		return
+	}
+	entries, ok := loadBundleEntries(c, uuids, len(req.UUIDs) > 0)
+	if !ok {
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No downloadable artifacts match the labels"})
+		return
+	}
+
+	streamBundle(c, entries, req.Format, req.Name, "Download in bundle")
+}
+
+// GenBundlePresignedURL godoc
+// @Summary      Generate a Bundle Download Token
+// @Description  Generates a token that downloads several artifacts as one ZIP or tar.gz archive, with the constraints of a download token.
+// @Description  The artifacts are resolved now, as for POST /artifact-service/v1/bundles. Artifacts deleted or otherwise made unavailable later are left out of the archive.
+// @Tags         tokens
+// @Accept       json
+// @Produce      json
+// @Param        request  body      models.GenBundleTokenRequest  true  "Artifacts, archive format and name, token constraints"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /genBundlePresignedURL [post]
+func GenBundlePresignedURL(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req models.GenBundleTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.AllowedCIDR != "" {
+		if _, _, err := net.ParseCIDR(req.AllowedCIDR); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allowed_cidr"})
+			return
+		}
+	}
+
+	uuids, ok := resolveBundle(c, &req.BundleRequest)
+	if !ok {
+		return
+	}
+	entries, ok := loadBundleEntries(c, uuids, len(req.UUIDs) > 0)
+	if !ok {
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No downloadable artifacts match the labels"})
+		return
+	}
+
+	// The token covers the artifacts selected now, not whatever matches the labels later
+	artifacts := make([]string, len(entries))
+	for i, entry := range entries {
+		artifacts[i] = entry.uuid
+	}
+	artifactsColumn, err := json.Marshal(artifacts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	token := uuid.New().String()
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO bundle_tokens (token, artifacts, format, name, valid_from, valid_to, max_downloads, allowed_cidr)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		token, string(artifactsColumn), req.Format, req.Name, req.ValidFrom, req.ValidTo, req.MaxDownloads, req.AllowedCIDR)
+	if err != nil {
+		log.Println("Failed to insert bundle token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	presignedURL := scheme + "://" + c.Request.Host + "/artifacts/bundle/" + token
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"presigned_url": presignedURL,
+		"type":          "bundle",
+		"artifacts":     len(artifacts),
+	})
+}
+
+// DownloadBundleWithToken godoc
+// @Summary      Download a bundle with Presigned URL
+// @Description  Streams the ZIP or tar.gz archive of a bundle token, enforcing its constraints. Artifacts that can no longer be downloaded are left out.
+// @Tags         tokens
+// @Produce      application/zip
+// @Produce      application/gzip
+// @Param        token  path      string  true  "Bundle Token"
+// @Success      200    {file}    file
+// @Failure      403    {object}  map[string]string  "Token constraint violated"
+// @Failure      404    {object}  map[string]string
+// @Failure      410    {object}  map[string]string  "None of the artifacts can be downloaded anymore"
+// @Failure      500    {object}  map[string]string
+// @Router       /artifacts/bundle/{token} [get]
+func DownloadBundleWithToken(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	token := c.Param("token")
+
+	var t models.Token
+	var artifactsColumn, format, name string
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT token, artifacts, format, name, valid_from, valid_to, max_downloads, current_downloads, allowed_cidr
+		FROM bundle_tokens
+		WHERE token = ?`, token).
+		Scan(&t.Token, &artifactsColumn, &format, &name, &t.ValidFrom, &t.ValidTo, &t.MaxDownloads, &t.CurrentDownloads, &t.AllowedCIDR)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			metrics.RecordTokenValidation("bundle", metrics.TokenNotFound)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired token"})
+		} else {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	// Enforce validity window, usage limit and CIDR restriction
+	if !validateToken(ctx, c, &t, "bundle") {
+		return
+	}
+
+	var uuids []string
+	if err := json.Unmarshal([]byte(artifactsColumn), &uuids); err != nil {
+		log.Printf("Invalid artifacts of bundle token %s: %v", token, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Artifacts deleted, quarantined or purged since the token was issued are left out
+	entries, ok := loadBundleEntries(c, uuids, false)
+	if !ok {
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "None of the bundled artifacts can be downloaded anymore"})
+		return
+	}
+
+	_, err = db.DB.ExecContext(ctx, "UPDATE bundle_tokens SET current_downloads = current_downloads + 1 WHERE token = ?", token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update download stats"})
+		return
+	}
+
+	streamBundle(c, entries, format, name, "Download in bundle via token")
+}
+
+// resolveBundle validates req, fills in the default format and name and returns the
+// selected UUIDs; otherwise the error response is written and false returned
+func resolveBundle(c *gin.Context, req *models.BundleRequest) ([]string, bool) {
+	switch req.Format {
+	case "":
+		req.Format = models.BundleZip
+	case models.BundleZip, models.BundleTarGz:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be zip or tar.gz"})
+		return nil, false
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = "artifacts"
+	} else {
+		req.Name = strings.TrimSuffix(sanitizeFilename(req.Name), "."+req.Format)
+	}
+
+	maxItems := config.Get().Download.BundleMaxItems
+	var uuids []string
+	var err error
+	switch {
+	case len(req.UUIDs) > 0 && len(req.Labels) > 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Select artifacts by uuids or labels, not both"})
+		return nil, false
+	case len(req.UUIDs) > 0:
+		uuids, err = uniqueUUIDs(req.UUIDs, maxItems)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	case len(req.Labels) > 0:
+		uuids, err = selectArtifacts(c.Request.Context(), &models.BulkFilter{Project: req.Project, Labels: req.Labels}, maxItems)
+		if errors.As(err, new(errTooManyItems)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("More than download.bundle_max_items (%d) artifacts match the labels", maxItems)})
+			return nil, false
+		} else if err != nil {
+			log.Println("Failed to select artifacts:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return nil, false
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Select artifacts by uuids or labels"})
+		return nil, false
+	}
+	return uuids, true
+}
+
+// loadBundleEntries loads the artifacts of a bundle in the given order. With strict, a
+// missing or undownloadable artifact fails the request; otherwise it is left out. On
+// failure the error response is written and false returned.
+func loadBundleEntries(c *gin.Context, uuids []string, strict bool) ([]bundleEntry, bool) {
+	ctx := c.Request.Context()
+
+	var entries []bundleEntry
+	for _, id := range uuids {
+		entry := bundleEntry{uuid: id}
+		var status sql.NullString
+		err := db.DB.QueryRowContext(ctx, "SELECT filename, size, status, created_at FROM Artifacts WHERE uuid = ?", id).
+			Scan(&entry.filename, &entry.size, &status, &entry.modified)
+		entry.status = status.String
+		switch {
+		case err == sql.ErrNoRows:
+			if strict {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found", "uuid": id})
+				return nil, false
+			}
+			continue
+		case err != nil:
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return nil, false
+		case !bundleDownloadable(entry.status):
+			if strict {
+				c.JSON(http.StatusConflict, gin.H{"error": "Artifact can't be downloaded", "uuid": id, "status": entry.status})
+				return nil, false
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, true
+}
+
+// streamBundle writes entries as a zip or tar.gz archive named name. Once streaming
+// has started an error can only cut the archive short, which clients detect from the
+// missing ZIP central directory or gzip trailer.
+func streamBundle(c *gin.Context, entries []bundleEntry, format, name, details string) {
+	ctx := c.Request.Context()
+
+	contentType := "application/zip"
+	if format == models.BundleTarGz {
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", contentDisposition("attachment", name+"."+format))
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	var archive bundleWriter
+	if format == models.BundleTarGz {
+		archive = newTarGzWriter(c.Writer)
+	} else {
+		archive = newZipWriter(c.Writer)
+	}
+
+	names := map[string]bool{}
+	for _, entry := range entries {
+		err := func() error {
+			stored, err := worker.LoadContent(ctx, entry.uuid)
+			if err != nil {
+				return err
+			}
+			body, err := stored.Open(ctx)
+			if err != nil {
+				return err
+			}
+			defer body.Close()
+
+			w, err := archive.Add(uniqueEntryName(names, entry.filename), entry.size, entry.modified)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, metrics.CountingReader(body, metrics.DownloadBytes))
+			return err
+		}()
+		if err != nil {
+			log.Printf("Failed to write %s into bundle: %v", entry.uuid, err)
+			logger.Record(logger.ActionDownload, entry.uuid, c.ClientIP(), "", "FAILED", details)
+			return
+		}
+		logger.Record(logger.ActionDownload, entry.uuid, c.ClientIP(), "", "SUCCESS", details)
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Println("Failed to finish bundle:", err)
+	}
+}
+
+// uniqueEntryName returns filename, or "name (2).ext" and so on if an earlier entry
+// already has that name. Names are compared case-insensitively, for case-insensitive
+// filesystems.
+func uniqueEntryName(taken map[string]bool, filename string) string {
+	filename = sanitizeFilename(filename)
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	if base == "" {
+		// A dotfile such as ".env" has no extension
+		base, ext = filename, ""
+	}
+
+	name := filename
+	for n := 2; taken[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	taken[strings.ToLower(name)] = true
+	return name
+}
+
+// bundleWriter writes the entries of an archive one after the other
+type bundleWriter interface {
+	// Add starts an entry; its content is written to the returned writer
+	Add(name string, size int64, modified time.Time) (io.Writer, error)
+	Close() error
+}
+
+// zipWriter writes a ZIP archive, switching to zip64 records for large entries and
+// offsets. Entries are deflated at the fastest level to keep up with the download.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func newZipWriter(w io.Writer) *zipWriter {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestSpeed)
+	})
+	return &zipWriter{zw: zw}
+}
+
+func (z *zipWriter) Add(name string, size int64, modified time.Time) (io.Writer, error) {
+	return z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// tarGzWriter writes a gzip compressed tar archive. tar needs the size of an entry
+// up front, so it is taken from the artifact's size, which every upload path sets to
+// the size of the content as downloaded.
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (t *tarGzWriter) Add(name string, size int64, modified time.Time) (io.Writer, error) {
+	err := t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0o644, ModTime: modified})
+	return t.tw, err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
@@ -1,58 +1,206 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
+	"ArtifactService/config"
 	"ArtifactService/db"
-	"ArtifactService/storage"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/middleware"
+	"ArtifactService/models"
+	"ArtifactService/worker"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Reasons a restore is refused
+var (
+	errNotInTrash         = errors.New("artifact is not deleted")
+	errGracePeriodExpired = errors.New("delete grace period has expired")
+)
+
 // DeleteArtifact godoc
 // @Summary      Delete an artifact
-// @Description  Deletes an artifact by its UUID from both database and storage
+// @Description  Moves an artifact to the trash: it gets the DELETED status, can no longer be downloaded and its tokens are revoked.
+// @Description  It can be restored until delete.grace_period has passed, then the purge worker removes it from the database and storage.
+// @Description  With a grace period of 0 the artifact is removed right away; if storage can't be reached the content is removed later by the reconciler (202).
+// @Description  Artifacts under a legal hold or retention period can't be deleted (423).
 // @Tags         files
 // @Produce      json
 // @Param        uuid   path      string  true  "File UUID"
 // @Success      200  {object}  map[string]string
+// @Success      202  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
+// @Failure      423  {object}  map[string]any
 // @Failure      500  {object}  map[string]string
 // @Router       /artifact-service/v1/artifacts/{uuid} [delete]
 func DeleteArtifact(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	uuid := c.Param("uuid")
+	actor := middleware.AdminActor(c)
 
-	// Check if artifact exists in DB
-	var exists bool
-	err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM Artifacts WHERE uuid = ?)", uuid).Scan(&exists)
+	// 1. Move the artifact to the trash, unless it is locked; an artifact already in the
+	// trash counts as not found
+	deletedAt, lock, err := worker.TrashArtifact(ctx, uuid, worker.DeletedBy(actor))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	}
+	if errors.Is(err, worker.ErrLocked) {
+		logger.Record(logger.ActionDelete, uuid, c.ClientIP(), actor, "FAILED", "Refused: artifact is locked")
+		c.JSON(http.StatusLocked, gin.H{"error": "Artifact is locked", "legal_hold": lock.LegalHold, "retain_until": lock.RetainUntil})
+		return
+	}
 	if err != nil {
-		log.Println("Database error checking existence:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		log.Println("Failed to delete artifact:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file record"})
 		return
 	}
 
-	if !exists {
+	// 2. Without a grace period the trash is emptied right away
+	grace := config.Get().Delete.GracePeriod.Std()
+	if grace <= 0 {
+		pending, err := worker.PurgeArtifact(ctx, uuid)
+		if err != nil {
+			// Still in the trash, the purge worker retries
+			log.Printf("Failed to purge %s, left to the purge worker: %v", uuid, err)
+			c.JSON(http.StatusAccepted, gin.H{"message": "Artifact deleted, content removal pending", "uuid": uuid})
+			return
+		}
+		metrics.RecordTransition("PURGED")
+		logger.Record(logger.ActionDelete, uuid, c.ClientIP(), actor, "SUCCESS", "Deleted permanently")
+		if pending {
+			c.JSON(http.StatusAccepted, gin.H{"message": "Artifact deleted, content removal pending", "uuid": uuid})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Artifact deleted successfully", "uuid": uuid})
+		return
+	}
+
+	logger.Record(logger.ActionDelete, uuid, c.ClientIP(), actor, "SUCCESS", "Moved to the trash")
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Artifact moved to the trash",
+		"uuid":       uuid,
+		"deleted_at": deletedAt,
+		"purge_at":   deletedAt.Add(grace),
+	})
+}
+
+// RestoreArtifact godoc
+// @Summary      Restore a deleted artifact
+// @Description  Takes an artifact out of the trash with the status it had before it was deleted. Only possible until delete.grace_period has passed.
+// @Description  Tokens revoked by the delete stay revoked.
+// @Tags         files
+// @Produce      json
+// @Param        uuid   path      string  true  "File UUID"
+// @Success      200  {object}  models.Artifact
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      410  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /artifact-service/v1/artifacts/{uuid}/restore [post]
+func RestoreArtifact(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	uuid := c.Param("uuid")
+
+	var artifact models.Artifact
+	err := withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		artifact, err = scanArtifact(tx.QueryRowContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", uuid))
+		if err != nil {
+			return err
+		}
+		if artifact.Status != "DELETED" {
+			return errNotInTrash
+		}
+		// Past the grace period the purge worker may already be removing it, unless a lock
+		// keeps it in the trash
+		lock := worker.Lock{LegalHold: artifact.LegalHold, RetainUntil: artifact.RetainUntil}
+		if artifact.PurgeAt != nil && time.Now().After(*artifact.PurgeAt) && !lock.Active(time.Now()) {
+			return errGracePeriodExpired
+		}
+
+		var previous sql.NullString
+		if err := tx.QueryRowContext(ctx, "SELECT deleted_status FROM Artifacts WHERE uuid = ?", uuid).Scan(&previous); err != nil {
+			return err
+		}
+		artifact.Status = previous.String
+		if artifact.Status == "" {
+			artifact.Status = "UPLOADED"
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE Artifacts
+			SET status = ?, deleted_status = NULL, deleted_at = NULL, deleted_by = NULL
+			WHERE uuid = ? AND status = 'DELETED'`,
+			artifact.Status, uuid)
+		return err
+	})
+	switch {
+	case err == sql.ErrNoRows:
 		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
 		return
+	case errors.Is(err, errNotInTrash):
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is not deleted", "status": artifact.Status})
+		return
+	case errors.Is(err, errGracePeriodExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Grace period has expired", "purge_at": artifact.PurgeAt})
+		return
+	case err != nil:
+		log.Println("Failed to restore artifact:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	artifact.DeletedAt, artifact.DeletedBy, artifact.PurgeAt = nil, "", nil
+
+	metrics.RecordTransition(artifact.Status)
+	if artifact.Status == "SCANNING" {
+		worker.NotifyScan()
 	}
+	logger.Record(logger.ActionDelete, uuid, c.ClientIP(), "", "SUCCESS", "Restored from the trash")
+	c.JSON(http.StatusOK, artifact)
+}
+
+// ListTrash godoc
+// @Summary      List deleted artifacts
+// @Description  Returns the artifacts in the trash, most recently deleted first, with when they will be purged.
+// @Tags         files
+// @Produce      json
+// @Success      200  {array}   models.Artifact
+// @Failure      500  {object}  map[string]string
+// @Router       /artifact-service/v1/trash [get]
+func ListTrash(c *gin.Context) {
+	ctx := c.Request.Context()
 
-	// Delete from S3/Ceph
-	err = storage.DeleteFile(uuid)
+	rows, err := db.DB.QueryContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE status = 'DELETED' ORDER BY deleted_at DESC")
 	if err != nil {
-		log.Println("Failed to delete file from storage:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file content"})
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	defer rows.Close()
 
-	// Delete from DB
-	_, err = db.DB.Exec("DELETE FROM Artifacts WHERE uuid = ?", uuid)
-	if err != nil {
-		log.Println("Failed to delete file record from database:", err)
-		// Note: The file is already deleted from storage at this point.
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file record"})
+	artifacts := []models.Artifact{}
+	for rows.Next() {
+		artifact, err := scanArtifact(rows)
+		if err != nil {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Artifact deleted successfully", "uuid": uuid})
+	c.JSON(http.StatusOK, artifacts)
 }
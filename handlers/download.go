@@ -2,32 +2,56 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
 	"ArtifactService/models"
 	"ArtifactService/storage"
+	"ArtifactService/worker"
 
 	"github.com/gin-gonic/gin"
 )
 
 // DownloadFile godoc
 // @Summary      Download a file
-// @Description  Downloads a file by its UUID
+// @Description  Downloads a file by its UUID. Supports Range/If-Range for resuming (206, multiple ranges as multipart/byteranges)
+// @Description  and If-None-Match/If-Modified-Since (304) using the storage ETag and Last-Modified.
+// @Description  Artifacts compressed at rest are sent as stored with Content-Encoding if Accept-Encoding allows it and no Range is requested, otherwise decompressed.
 // @Tags         files
 // @Produce      octet-stream
-// @Param        uuid   path      string  true  "File UUID"
+// @Param        uuid               path      string  true   "File UUID"
+// @Param        disposition        query     string  false  "inline to display previewable types (download.inline_types) in the browser" Enums(attachment, inline)
+// @Param        Range              header    string  false  "Byte ranges, e.g. bytes=0-1023"
+// @Param        If-Range           header    string  false  "ETag or date; the range is only honoured if the file is unchanged"
+// @Param        If-None-Match      header    string  false  "ETag the client already has"
+// @Param        If-Modified-Since  header    string  false  "Date of the client's copy"
+// @Param        Accept-Encoding    header    string  false  "gzip and/or zstd to receive compressed artifacts as stored"
 // @Success      200  {file}    file
+// @Success      206  {file}    file
+// @Success      304  "Not modified"
+// @Failure      403  {object}  map[string]string  "Artifact is QUARANTINED"
 // @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string  "Artifact still SCANNING"
+// @Failure      410  {object}  map[string]string  "Artifact is in the trash"
+// @Failure      416  "Range not satisfiable"
 // @Failure      500  {object}  map[string]string
 // @Router       /artifact-service/v1/artifacts/{uuid}/action/downloadFile [get]
 func DownloadFile(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	uuid := c.Param("uuid")
 
 	var metadata models.Artifact
-	row := db.DB.QueryRow("SELECT uuid, filename, content_type, size FROM Artifacts WHERE uuid = ?", uuid)
-	err := row.Scan(&metadata.UUID, &metadata.Filename, &metadata.ContentType, &metadata.Size)
+	var status sql.NullString
+	row := db.DB.QueryRowContext(ctx, "SELECT uuid, filename, content_type, size, status FROM Artifacts WHERE uuid = ?", uuid)
+	err := row.Scan(&metadata.UUID, &metadata.Filename, &metadata.ContentType, &metadata.Size, &status)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -38,20 +62,137 @@ func DownloadFile(c *gin.Context) {
 		}
 		return
 	}
+	if status.String == "DELETED" {
+		c.JSON(http.StatusGone, gin.H{"error": "Artifact is deleted"})
+		return
+	}
+	if downloadBlocked(c, uuid, status.String) {
+		return
+	}
 
+	// How the content is stored, with its data key unwrapped
+	stored, err := worker.LoadContent(ctx, uuid)
+	if err != nil {
+		log.Printf("Failed to load content of %s: %v", uuid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to read file"})
+		return
+	}
+	encoding := stored.Encoding
 
-	// Download file from Ceph
-	fileReader, err := storage.DownloadFile(uuid)
+	// ETag, Last-Modified and the real size come from the stored object
+	info, err := stored.Stat(ctx)
 	if err != nil {
-		log.Println("Failed to download file from Ceph:", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "File content not found"})
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File content not found"})
+		} else {
+			log.Println("Failed to stat file in Ceph:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage error"})
+		}
 		return
 	}
-	defer fileReader.Close()
 
-	// Stream file to response
+	stored.StoredSize = info.Size
+	var content io.ReadSeekCloser
+	etag := artifactETag(c, info)
+	switch {
+	case encoding == "":
+		// Ranges are fetched from Ceph with ranged GetObject calls, only for the bytes (or
+		// encrypted chunks) that are sent
+		content = storage.NewObjectReader(ctx, stored, stored.EncodedSize(info.Size))
+	case sendEncoded(c.Request, encoding):
+		// The client decompresses, send the content as compressed at rest
+		size := stored.EncodedSize(info.Size)
+		content = storage.NewObjectReader(ctx, stored, size)
+		etag = encodedETag(etag, encoding)
+		c.Header("Content-Encoding", encoding)
+		c.Header("Content-Length", strconv.FormatInt(size, 10)) // ServeContent leaves it out with Content-Encoding
+	default:
+		// Decompress on the fly; ranges and sizes refer to the uncompressed content
+		content = storage.NewDecodedReader(ctx, stored, metadata.Size)
+	}
+	defer content.Close()
+
 	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Disposition", "attachment; filename="+metadata.Filename)
+	c.Header("Content-Disposition", contentDisposition(dispositionFor(c.Query("disposition"), metadata.ContentType), metadata.Filename))
 	c.Header("Content-Type", metadata.ContentType)
-	c.DataFromReader(http.StatusOK, metadata.Size, metadata.ContentType, fileReader, nil)
+	c.Header("X-Content-Type-Options", "nosniff")
+	if encoding != "" {
+		c.Header("Vary", "Accept-Encoding")
+	}
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+
+	// ServeContent answers Range/If-Range with 206 or 416 and If-None-Match/If-Modified-Since with 304
+	http.ServeContent(c.Writer, c.Request, metadata.Filename, info.LastModified, metrics.CountingReadSeeker(content, metrics.DownloadBytes))
+}
+
+// downloadBlocked answers for an artifact whose content must not be served: 409 while
+// the malware scan runs, 403 once it is quarantined. It reports whether it answered;
+// HEAD requests get the status only.
+func downloadBlocked(c *gin.Context, uuid, status string) bool {
+	var code int
+	var message string
+	switch status {
+	case "SCANNING":
+		code, message = http.StatusConflict, "Artifact is being scanned, try again later"
+	case "QUARANTINED":
+		logger.Record(logger.ActionDownload, uuid, c.ClientIP(), "", "FAILED", "Blocked: artifact is quarantined")
+		code, message = http.StatusForbidden, "Artifact is quarantined"
+	default:
+		return false
+	}
+	if c.Request.Method == http.MethodHead {
+		c.Status(code)
+	} else {
+		c.JSON(code, gin.H{"error": message})
+	}
+	return true
+}
+
+// sendEncoded reports whether an artifact stored with encoding is sent as stored, with
+// Content-Encoding. Range requests get the decompressed content, since resuming a
+// download needs ranges of the file, not of the compressed stream.
+func sendEncoded(r *http.Request, encoding string) bool {
+	return encoding != "" && r.Header.Get("Range") == "" && acceptsEncoding(r, encoding)
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, encoding) && coding != "*" {
+			continue
+		}
+		// "gzip;q=0" explicitly refuses the coding
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// etagKey is the context key of an ETag that replaces the storage ETag of a download,
+// for APIs whose clients expect their own (the S3 gateway's content digest)
+const etagKey = "artifact_etag"
+
+// artifactETag returns the ETag a download of the stored object answers with
+func artifactETag(c *gin.Context, info *storage.ObjectInfo) string {
+	if etag := c.GetString(etagKey); etag != "" {
+		return etag
+	}
+	return info.ETag
+}
+
+// encodedETag is the ETag of the compressed representation of an object, which must
+// differ from the ETag of the decompressed one
+func encodedETag(etag, encoding string) string {
+	if etag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
 }
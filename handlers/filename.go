@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ArtifactService/config"
+)
+
+// maxFilenameBytes matches the common filesystem limit, so a downloaded file can always be saved
+const maxFilenameBytes = 255
+
+// sanitizeFilename makes a client supplied filename safe to store and send back:
+// directories are dropped, control and formatting characters (incl. bidi overrides)
+// are removed and the name is cut to maxFilenameBytes, keeping the extension.
+func sanitizeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+
+	// Keep only the last path element, for both / and \ separators
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == ".." {
+		return "unnamed"
+	}
+
+	if len(name) > maxFilenameBytes {
+		ext := ""
+		if i := strings.LastIndexByte(name, '.'); i > 0 && len(name)-i <= 16 {
+			ext = name[i:]
+		}
+		base := name[:maxFilenameBytes-len(ext)]
+		// Don't cut a multi-byte character in half
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name
+}
+
+// contentDisposition builds an RFC 6266 header value. filename is an ASCII fallback
+// for old clients; filename* (RFC 5987) carries the exact UTF-8 name when they differ.
+func contentDisposition(disposition, filename string) string {
+	filename = sanitizeFilename(filename)
+
+	var fallback strings.Builder
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	value := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback.String())
+	if fallback.String() != filename {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// encodeRFC5987 percent-encodes everything outside the RFC 5987 attr-char set
+func encodeRFC5987(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(attrChars, c) >= 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// dispositionFor returns "inline" if the client asked for it and the content type
+// is listed in download.inline_types, "attachment" otherwise.
+func dispositionFor(requested, contentType string) string {
+	if requested != "inline" {
+		return "attachment"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "attachment"
+	}
+	if slices.Contains(config.Get().Download.InlineTypes, mediaType) {
+		return "inline"
+	}
+	return "attachment"
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"ArtifactService/db"
+	"ArtifactService/storage"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds each dependency check so a hung backend can't hang the probe
+const readinessTimeout = 3 * time.Second
+
+// DependencyStatus is the result of a single readiness check
+type DependencyStatus struct {
+	Status    string `json:"status"` // ok / fail
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	Details   string `json:"details,omitempty"`
+}
+
+// ReadinessResponse aggregates the dependency checks
+type ReadinessResponse struct {
+	Status       string                      `json:"status"` // ready / not_ready
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Healthz godoc
+// @Summary      Liveness probe
+// @Description  Returns 200 as long as the process is serving HTTP. Does not check dependencies.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Router       /healthz [get]
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz godoc
+// @Summary      Readiness probe
+// @Description  Pings the database, checks the storage bucket with HeadBucket and verifies the status checker ran recently. Returns 503 if any dependency fails.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  ReadinessResponse
+// @Failure      503  {object}  ReadinessResponse
+// @Router       /readyz [get]
+func Readyz(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Fail readiness as soon as shutdown starts so load balancers stop sending new requests
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Status: "shutting_down", Dependencies: map[string]DependencyStatus{}})
+		return
+	}
+
+	response := ReadinessResponse{
+		Status: "ready",
+		Dependencies: map[string]DependencyStatus{
+			"database": checkDependency(ctx, db.DB.PingContext),
+			"storage":  checkDependency(ctx, storage.HeadBucket),
+			"worker":   checkWorkerFreshness(),
+		},
+	}
+
+	httpStatus := http.StatusOK
+	for _, dep := range response.Dependencies {
+		if dep.Status != "ok" {
+			response.Status = "not_ready"
+			httpStatus = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(httpStatus, response)
+}
+
+func checkDependency(ctx context.Context, check func(context.Context) error) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	status := DependencyStatus{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		status.Status = "fail"
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// checkWorkerFreshness fails if the status checker hasn't completed a clean run
+// within three intervals, e.g. because it is stuck or storage keeps erroring.
+func checkWorkerFreshness() DependencyStatus {
+	lastRun, interval := worker.StatusCheckerFreshness()
+	if interval == 0 {
+		return DependencyStatus{Status: "fail", Error: "status checker not started"}
+	}
+
+	maxAge := 3 * interval
+	if lastRun.IsZero() {
+		if time.Since(processStart) < maxAge {
+			// Still within the grace period after startup
+			return DependencyStatus{Status: "ok", Details: "waiting for first run"}
+		}
+		return DependencyStatus{Status: "fail", Error: "status checker has not completed a run"}
+	}
+
+	age := time.Since(lastRun)
+	status := DependencyStatus{Status: "ok", Details: "last successful run " + lastRun.UTC().Format(time.RFC3339)}
+	if age > maxAge {
+		status.Status = "fail"
+		status.Error = "last successful run is older than " + maxAge.String()
+	}
+	return status
+}
+
+var (
+	processStart = time.Now()
+	shuttingDown atomic.Bool
+)
+
+// SetShuttingDown makes the readiness probe fail while in-flight requests drain
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
@@ -12,15 +12,17 @@ import (
 
 // ListArtifacts godoc
 // @Summary      List all artifacts
-// @Description  Retrieves a list of all uploaded artifacts with their metadata
+// @Description  Retrieves a list of all uploaded artifacts with their metadata. Deleted artifacts are listed in the trash instead.
 // @Tags         files
 // @Produce      json
 // @Success      200  {array}   models.Artifact
 // @Failure      500  {object}  map[string]string
 // @Router       /artifact-service/v1/artifacts/ [get]
 func ListArtifacts(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	// Query all artifacts from database
-	rows, err := db.DB.Query("SELECT uuid, filename, content_type, size, created_at FROM Artifacts ORDER BY created_at DESC")
+	rows, err := db.DB.QueryContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE IFNULL(status, '') != 'DELETED' ORDER BY created_at DESC")
 	if err != nil {
 		log.Println("Database query error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve artifacts"})
@@ -31,8 +33,7 @@ func ListArtifacts(c *gin.Context) {
 	// Collect all artifacts
 	var artifacts []models.Artifact
 	for rows.Next() {
-		var artifact models.Artifact
-		err := rows.Scan(&artifact.UUID, &artifact.Filename, &artifact.ContentType, &artifact.Size, &artifact.CreatedAt)
+		artifact, err := scanArtifact(rows)
 		if err != nil {
 			log.Println("Row scan error:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse artifacts"})
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/middleware"
+	"ArtifactService/models"
+	"ArtifactService/storage"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetLegalHold godoc
+// @Summary      Set or release a legal hold
+// @Description  While an artifact is under a legal hold it can't be deleted or overwritten, and a deleted one isn't purged from the trash.
+// @Description  Anyone may set a hold; releasing it needs an admin key (Authorization: Bearer <key>). With storage.object_lock enabled the hold is applied to the object as well.
+// @Tags         locks
+// @Accept       json
+// @Produce      json
+// @Param        uuid     path    string                   true  "File UUID"
+// @Param        request  body    models.LegalHoldRequest  true  "Hold on or off, with the reason for the audit log"
+// @Success      200  {object}  models.Artifact
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Failure      502  {object}  map[string]string
+// @Router       /artifact-service/v1/artifacts/{uuid}/legal-hold [put]
+func SetLegalHold(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	uuid := c.Param("uuid")
+
+	var req models.LegalHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	artifact, ok := loadLockable(c, uuid)
+	if !ok {
+		return
+	}
+
+	// 1. Releasing is for admins only
+	actor := middleware.AdminActor(c)
+	if artifact.LegalHold && !*req.Enabled && actor == "" {
+		logger.Record(logger.ActionLock, uuid, c.ClientIP(), "", "FAILED", "Refused: releasing a legal hold needs an admin key")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Releasing a legal hold requires an admin key"})
+		return
+	}
+
+	// 2. Storage first, so the database never claims a hold storage doesn't have
+	if config.Get().Storage.ObjectLock.Enabled {
+		if err := storage.SetLegalHold(ctx, uuid, *req.Enabled); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to set legal hold of %s in storage: %v", uuid, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to apply the object lock"})
+			return
+		}
+	}
+
+	// 3. Only if the hold is still the one checked; a concurrent change wins and storage
+	// gets its hold back
+	res, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET legal_hold = ? WHERE uuid = ? AND legal_hold = ?", *req.Enabled, uuid, artifact.LegalHold)
+	if err != nil {
+		log.Println("Failed to update legal hold:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		worker.SyncObjectLock(ctx, uuid)
+		c.JSON(http.StatusConflict, gin.H{"error": "Legal hold was changed concurrently, try again"})
+		return
+	}
+	artifact.LegalHold = *req.Enabled
+
+	details := "Legal hold released"
+	if *req.Enabled {
+		details = "Legal hold set"
+	}
+	if req.Reason != "" {
+		details += ": " + req.Reason
+	}
+	logger.Record(logger.ActionLock, uuid, c.ClientIP(), actor, "SUCCESS", details)
+	c.JSON(http.StatusOK, artifact)
+}
+
+// SetRetention godoc
+// @Summary      Set the retention period
+// @Description  Until retain_until the artifact can't be deleted or overwritten, and a deleted one isn't purged from the trash.
+// @Description  Anyone may set or extend it; shortening or clearing it (retain_until null) needs an admin key (Authorization: Bearer <key>).
+// @Description  With storage.object_lock enabled the retention is applied to the object as well; in COMPLIANCE mode it can't be shortened at all.
+// @Tags         locks
+// @Accept       json
+// @Produce      json
+// @Param        uuid     path    string                   true  "File UUID"
+// @Param        request  body    models.RetentionRequest  true  "End of the retention period, with the reason for the audit log"
+// @Success      200  {object}  models.Artifact
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Failure      502  {object}  map[string]string
+// @Router       /artifact-service/v1/artifacts/{uuid}/retention [put]
+func SetRetention(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	uuid := c.Param("uuid")
+
+	var req models.RetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	if req.RetainUntil != nil && !req.RetainUntil.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retain_until must be in the future"})
+		return
+	}
+
+	// Shortening a running retention period is for admins only, and impossible once
+	// storage enforces it in COMPLIANCE mode
+	actor := middleware.AdminActor(c)
+	previous, err := worker.SetRetention(ctx, uuid, req.RetainUntil, actor != "")
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	case errors.Is(err, worker.ErrNoContent):
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact has no content to lock"})
+		return
+	case errors.Is(err, worker.ErrAdminRequired):
+		logger.Record(logger.ActionLock, uuid, c.ClientIP(), "", "FAILED", "Refused: shortening retention needs an admin key")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Shortening the retention period requires an admin key"})
+		return
+	case errors.Is(err, worker.ErrComplianceLocked):
+		logger.Record(logger.ActionLock, uuid, c.ClientIP(), actor, "FAILED", "Refused: COMPLIANCE retention can't be shortened")
+		c.JSON(http.StatusConflict, gin.H{"error": "Retention in COMPLIANCE mode can't be shortened", "retain_until": previous.RetainUntil})
+		return
+	case errors.Is(err, worker.ErrLockChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": "Retention was changed concurrently, try again"})
+		return
+	case errors.Is(err, worker.ErrObjectLock):
+		log.Printf("Failed to set retention of %s in storage: %v", uuid, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to apply the object lock"})
+		return
+	case err != nil:
+		log.Println("Failed to update retention:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	artifact, err := scanArtifact(db.DB.QueryRowContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", uuid))
+	if err != nil {
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	details := "Retention cleared"
+	if artifact.RetainUntil != nil {
+		details = "Retention set until " + artifact.RetainUntil.UTC().Format(time.RFC3339)
+	}
+	if req.Reason != "" {
+		details += ": " + req.Reason
+	}
+	logger.Record(logger.ActionLock, uuid, c.ClientIP(), actor, "SUCCESS", details)
+	c.JSON(http.StatusOK, artifact)
+}
+
+// loadLockable loads an artifact that has content to lock; otherwise the error response
+// is written and false returned
+func loadLockable(c *gin.Context, uuid string) (models.Artifact, bool) {
+	artifact, err := scanArtifact(db.DB.QueryRowContext(c.Request.Context(), "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", uuid))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		} else {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return artifact, false
+	}
+	if !worker.Lockable(artifact.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact has no content to lock", "status": artifact.Status})
+		return artifact, false
+	}
+	return artifact, true
+}
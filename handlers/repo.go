@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/middleware"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PutRepoFile godoc
+// @Summary      Deploy a file to the generic repository
+// @Description  Stores the request body under a repository path, like a Maven or raw repository deploy (curl -T, mvn deploy).
+// @Description  The path is the versioned name project/path: every PUT adds a new artifact as its next version, earlier ones stay in the version history.
+// @Description  While the version the path serves is under a legal hold or retention period it can't be replaced (423).
+// @Tags         repository
+// @Accept       application/octet-stream
+// @Produce      json
+// @Param        project  path    string  true  "Project"
+// @Param        path     path    string  true  "File path, e.g. com/acme/app/1.0/app-1.0.jar"
+// @Param        file     body    string  true  "File content"
+// @Success      201  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      413  {object}  map[string]string
+// @Failure      415  {object}  map[string]string
+// @Failure      423  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /repo/{project}/{path} [put]
+func PutRepoFile(c *gin.Context) {
+	name, ok := repoName(c)
+	if !ok {
+		return
+	}
+	result, failure := streamUpload(c, streamRequest{
+		uuid:        uuid.New().String(),
+		filename:    sanitizeFilename(path.Base(c.Param("path"))),
+		project:     c.Param("project"),
+		name:        name,
+		contentType: c.ContentType(),
+		details:     "Repository upload",
+		lockName:    true,
+	})
+	if failure != nil {
+		c.JSON(failure.status, failure.body())
+		return
+	}
+	c.JSON(http.StatusCreated, result.response())
+}
+
+// GetRepoFile godoc
+// @Summary      Resolve a file from the generic repository
+// @Description  Downloads the latest version of a repository path, like downloadFile (ranges, conditional requests, content encoding).
+// @Description  An older version or a tag can be picked with the version query parameter. The resolved version and artifact are returned in X-Artifact-Version and X-Artifact-UUID.
+// @Description  Only UPLOADED versions are served; deleted versions and ones still being scanned or quarantined answer 404.
+// @Tags         repository
+// @Produce      octet-stream
+// @Param        project  path      string  true   "Project"
+// @Param        path     path      string  true   "File path"
+// @Param        version  query     string  false  "Version number or tag, default latest"
+// @Success      200      {file}    file
+// @Success      206      {file}    file
+// @Success      304      "Not modified"
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /repo/{project}/{path} [get]
+func GetRepoFile(c *gin.Context) {
+	if !resolveRepoFile(c) {
+		return
+	}
+	DownloadFile(c)
+}
+
+// HeadRepoFile godoc
+// @Summary      Check a file in the generic repository
+// @Description  Returns the headers GET would return for the latest version of a repository path (or the one picked with version), without the body.
+// @Tags         repository
+// @Param        project  path   string  true   "Project"
+// @Param        path     path   string  true   "File path"
+// @Param        version  query  string  false  "Version number or tag, default latest"
+// @Success      200  "Headers only"
+// @Failure      404  "Not found"
+// @Failure      500  "Database or storage error"
+// @Router       /repo/{project}/{path} [head]
+func HeadRepoFile(c *gin.Context) {
+	if !resolveRepoFile(c) {
+		return
+	}
+	HeadDownloadFile(c)
+}
+
+// DeleteRepoFile godoc
+// @Summary      Delete a file from the generic repository
+// @Description  Moves every version of a repository path to the trash, so the path is gone; they can be restored like any deleted artifact.
+// @Description  If any version is under a legal hold or retention period nothing is deleted (423).
+// @Tags         repository
+// @Produce      json
+// @Param        project  path      string  true  "Project"
+// @Param        path     path      string  true  "File path"
+// @Success      200      {object}  map[string]any
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      423      {object}  map[string]any
+// @Failure      500      {object}  map[string]string
+// @Router       /repo/{project}/{path} [delete]
+func DeleteRepoFile(c *gin.Context) {
+	name, ok := repoName(c)
+	if !ok {
+		return
+	}
+
+	// 1. The versions still to delete
+	uuids, err := liveVersions(c.Request.Context(), name)
+	if err != nil {
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(uuids) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	// 2. Move them to the trash, all or nothing as far as locks go
+	trashed, locked, err := trashVersions(c, name, uuids, middleware.AdminActor(c))
+	if locked != nil {
+		c.JSON(http.StatusLocked, gin.H{"error": "A version is locked", "uuid": locked.uuid, "legal_hold": locked.lock.LegalHold, "retain_until": locked.lock.RetainUntil})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file record", "trashed": trashed})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted", "name": name, "trashed": trashed})
+}
+
+// liveVersions returns the artifacts of the versions of name that aren't deleted, newest first
+func liveVersions(ctx context.Context, name string) ([]string, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT v.artifact_uuid
+		FROM artifact_versions v
+		JOIN Artifacts a ON a.uuid = v.artifact_uuid
+		WHERE v.name = ? AND IFNULL(a.status, '') != 'DELETED'
+		ORDER BY v.version DESC`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uuids []string
+	for rows.Next() {
+		var artifactUUID string
+		if err := rows.Scan(&artifactUUID); err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, artifactUUID)
+	}
+	return uuids, rows.Err()
+}
+
+// versionLock is the locked version that kept trashVersions from deleting anything
+type versionLock struct {
+	uuid string
+	lock worker.Lock
+}
+
+// trashVersions moves versions of name to the trash, emptied right away without a grace
+// period, and returns the ones it trashed. If any of them is locked nothing is deleted.
+// actor is recorded as deleted_by (see worker.DeletedBy) and as the user_session of the
+// audit entries.
+func trashVersions(c *gin.Context, name string, uuids []string, actor string) ([]string, *versionLock, error) {
+	ctx := c.Request.Context()
+
+	// 1. All or nothing as far as locks go; TrashArtifact checks again for locks set since
+	for _, artifactUUID := range uuids {
+		lock, err := worker.CheckLock(ctx, db.DB, artifactUUID)
+		if errors.Is(err, worker.ErrLocked) {
+			logger.Record(logger.ActionDelete, artifactUUID, c.ClientIP(), actor, "FAILED", "Refused: artifact is locked")
+			return nil, &versionLock{uuid: artifactUUID, lock: lock}, err
+		}
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("Database error:", err)
+			return nil, nil, err
+		}
+	}
+
+	// 2. Move them to the trash
+	purge := config.Get().Delete.GracePeriod <= 0
+	trashed := []string{}
+	for _, artifactUUID := range uuids {
+		_, _, err := worker.TrashArtifact(ctx, artifactUUID, worker.DeletedBy(actor))
+		if err != nil {
+			if errors.Is(err, worker.ErrLocked) || err == sql.ErrNoRows {
+				// Locked or deleted in the meantime
+				continue
+			}
+			log.Printf("Failed to delete %s of %s: %v", artifactUUID, name, err)
+			return trashed, nil, err
+		}
+		details := "Moved to the trash: " + name
+		if purge {
+			if _, err := worker.PurgeArtifact(ctx, artifactUUID); err != nil {
+				log.Printf("Failed to purge %s, left to the purge worker: %v", artifactUUID, err)
+			} else {
+				metrics.RecordTransition("PURGED")
+				details = "Deleted permanently: " + name
+			}
+		}
+		logger.Record(logger.ActionDelete, artifactUUID, c.ClientIP(), actor, "SUCCESS", details)
+		trashed = append(trashed, artifactUUID)
+	}
+	return trashed, nil, nil
+}
+
+// repoName returns the versioned name of the repository path of the request,
+// answering 400 if it is invalid
+func repoName(c *gin.Context) (string, bool) {
+	name, err := worker.RepoName(c.Param("project"), c.Param("path"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return name, true
+}
+
+// resolveRepoFile points the request at the artifact of the requested version of a
+// repository path, so the artifact download handlers can serve it
+func resolveRepoFile(c *gin.Context) bool {
+	name, ok := repoName(c)
+	if !ok {
+		return false
+	}
+	version, artifactUUID, ok := resolveVersion(c, name, c.DefaultQuery("version", worker.LatestRef))
+	if !ok {
+		return false
+	}
+
+	// A numbered or tagged version may be deleted or not downloadable yet; like the S3
+	// gateway, only UPLOADED versions are served
+	var status sql.NullString
+	err := db.DB.QueryRowContext(c.Request.Context(), "SELECT status FROM Artifacts WHERE uuid = ?", artifactUUID).Scan(&status)
+	if err == sql.ErrNoRows || (err == nil && status.String != "" && status.String != "UPLOADED") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return false
+	}
+	if err != nil {
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+
+	c.Header("X-Artifact-Version", strconv.FormatInt(version, 10))
+	c.Header("X-Artifact-UUID", artifactUUID)
+	c.Params = append(c.Params, gin.Param{Key: "uuid", Value: artifactUUID})
+	return true
+}
+
+// nameLocked describes an upload refused because the version its name serves is locked
+func nameLocked(c *gin.Context, req streamRequest) *uploadFailure {
+	logger.Record(logger.ActionUpload, "", c.ClientIP(), req.actor, "FAILED", "Refused: latest version of "+req.name+" is locked")
+	return &uploadFailure{status: http.StatusLocked, message: "Latest version is locked", err: worker.ErrLocked, details: gin.H{"name": req.name}}
+}
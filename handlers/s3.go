@@ -0,0 +1,559 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/middleware"
+	"ArtifactService/models"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// s3TimeFormat is how S3 XML documents write times
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// maxS3Keys is the most keys a listing returns, also the default
+const maxS3Keys = 1000
+
+// s3Unsupported are the subresources the gateway doesn't implement; without this check
+// e.g. GET ?acl would download the object
+var s3Unsupported = []string{"acl", "tagging", "retention", "legal-hold", "torrent", "attributes", "restore", "select", "versioning", "policy", "lifecycle", "cors", "delete", "versions"}
+
+// s3Object is the object a gateway request addresses
+type s3Object struct {
+	bucket, key string
+	name        string // versioned name "bucket/key"
+}
+
+// S3Gateway serves the S3-compatible API on s3_gateway.port, behind middleware.S3Auth.
+// Requests are path-style, /<bucket>/<key>: buckets are projects and keys are the paths
+// of versioned names, shared with the generic repository (/repo/<project>/<path>).
+// Every PutObject adds a version; GET serves the latest.
+func S3Gateway(c *gin.Context) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(c.Param("path"), "/"), "/")
+	method := c.Request.Method
+	query := c.Request.URL.Query()
+
+	for _, sub := range s3Unsupported {
+		if query.Has(sub) {
+			middleware.WriteS3Error(c, http.StatusNotImplemented, "NotImplemented", "The "+sub+" subresource is not supported")
+			return
+		}
+	}
+
+	// 1. Service level
+	if bucket == "" {
+		if method != http.MethodGet {
+			middleware.WriteS3Error(c, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource")
+			return
+		}
+		s3ListBuckets(c)
+		return
+	}
+	if err := worker.ValidProject(bucket); err != nil {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidBucketName", err.Error())
+		return
+	}
+	if !middleware.GetSigV4Auth(c).CanAccess(bucket) {
+		middleware.WriteS3Error(c, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+
+	// 2. Bucket level
+	if key == "" {
+		switch {
+		case method == http.MethodGet && query.Has("location"):
+			s3BucketLocation(c)
+		case method == http.MethodGet && query.Has("uploads"):
+			middleware.WriteS3Error(c, http.StatusNotImplemented, "NotImplemented", "Listing multipart uploads is not supported")
+		case method == http.MethodGet:
+			s3ListObjects(c, bucket)
+		case method == http.MethodHead:
+			// Every valid project exists as a bucket
+			c.Status(http.StatusOK)
+		case method == http.MethodPut:
+			c.Header("Location", "/"+bucket)
+			c.Status(http.StatusOK)
+		case method == http.MethodDelete:
+			s3DeleteBucket(c, bucket)
+		default:
+			middleware.WriteS3Error(c, http.StatusNotImplemented, "NotImplemented", "Not supported on buckets")
+		}
+		return
+	}
+
+	// 3. Object level
+	name, err := worker.RepoName(bucket, key)
+	if err != nil {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	obj := s3Object{bucket: bucket, key: key, name: name}
+	switch {
+	case method == http.MethodPost && query.Has("uploads"):
+		s3CreateMultipartUpload(c, obj)
+	case method == http.MethodPost && query.Has("uploadId"):
+		s3CompleteMultipartUpload(c, obj)
+	case method == http.MethodPut && query.Has("uploadId"):
+		s3UploadPart(c, obj)
+	case method == http.MethodDelete && query.Has("uploadId"):
+		s3AbortMultipartUpload(c, obj)
+	case method == http.MethodGet && query.Has("uploadId"):
+		s3ListParts(c, obj)
+	case method == http.MethodPut && c.GetHeader("X-Amz-Copy-Source") != "":
+		middleware.WriteS3Error(c, http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported")
+	case method == http.MethodPut:
+		s3PutObject(c, obj)
+	case method == http.MethodGet:
+		if s3ResolveObject(c, obj) {
+			DownloadFile(c)
+		}
+	case method == http.MethodHead:
+		if s3ResolveObject(c, obj) {
+			HeadDownloadFile(c)
+		}
+	case method == http.MethodDelete:
+		s3DeleteObject(c, obj)
+	default:
+		middleware.WriteS3Error(c, http.StatusNotImplemented, "NotImplemented", "Not supported on objects")
+	}
+}
+
+// s3ListBuckets lists the projects with versioned names as buckets, those the access
+// key may use
+func s3ListBuckets(c *gin.Context) {
+	auth := middleware.GetSigV4Auth(c)
+	result := models.ListAllMyBucketsResult{
+		Xmlns:   models.S3Namespace,
+		Owner:   models.S3Owner{ID: auth.AccessKey, DisplayName: auth.Actor},
+		Buckets: []models.S3Bucket{},
+	}
+
+	// Names are sorted, so a project's names are together; its first name dates it
+	rows, err := db.DB.QueryContext(c.Request.Context(), "SELECT name, created_at FROM artifact_names ORDER BY name")
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket models.S3Bucket
+		if err := rows.Scan(&bucket.Name, &bucket.CreationDate); err != nil {
+			log.Println("Database error:", err)
+			middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+			return
+		}
+		bucket.Name, _, _ = strings.Cut(bucket.Name, "/")
+		if !auth.CanAccess(bucket.Name) {
+			continue
+		}
+		bucket.CreationDate = bucket.CreationDate.UTC().Truncate(time.Millisecond)
+		last := len(result.Buckets) - 1
+		switch {
+		case last < 0 || result.Buckets[last].Name != bucket.Name:
+			result.Buckets = append(result.Buckets, bucket)
+		case bucket.CreationDate.Before(result.Buckets[last].CreationDate):
+			result.Buckets[last].CreationDate = bucket.CreationDate
+		}
+	}
+	c.XML(http.StatusOK, result)
+}
+
+// s3BucketLocation answers GetBucketLocation with s3_gateway.region; us-east-1 is
+// written as empty, as S3 does
+func s3BucketLocation(c *gin.Context) {
+	region := config.Get().S3Gateway.Region
+	if region == "us-east-1" {
+		region = ""
+	}
+	c.XML(http.StatusOK, models.LocationConstraint{Xmlns: models.S3Namespace, Region: region})
+}
+
+// s3DeleteBucket succeeds for a project without live versions; there is nothing to remove
+func s3DeleteBucket(c *gin.Context, bucket string) {
+	var used bool
+	err := db.DB.QueryRowContext(c.Request.Context(), `
+		SELECT EXISTS(
+			SELECT 1 FROM artifact_versions v
+			JOIN Artifacts a ON a.uuid = v.artifact_uuid
+			WHERE substr(v.name, 1, length(?)) = ? AND IFNULL(a.status, '') != 'DELETED')`,
+		bucket+"/", bucket+"/").Scan(&used)
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+	if used {
+		middleware.WriteS3Error(c, http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// s3ListObjects answers ListObjects and ListObjectsV2 (list-type=2) with the latest
+// downloadable version of every name in the bucket, sorted by key
+func s3ListObjects(c *gin.Context, bucket string) {
+	// 1. Parameters
+	v2 := c.Query("list-type") == "2"
+	prefix, delimiter := c.Query("prefix"), c.Query("delimiter")
+	maxKeys := maxS3Keys
+	if raw := c.Query("max-keys"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer")
+			return
+		}
+		maxKeys = min(n, maxS3Keys)
+	}
+	encodingType := c.Query("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "encoding-type must be url")
+		return
+	}
+
+	result := models.ListBucketResult{
+		Xmlns:        models.S3Namespace,
+		Name:         bucket,
+		Prefix:       prefix,
+		Delimiter:    delimiter,
+		MaxKeys:      maxKeys,
+		EncodingType: encodingType,
+	}
+	var after string // listing starts after this key
+	if v2 {
+		result.StartAfter = c.Query("start-after")
+		after = result.StartAfter
+		if token := c.Query("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+				return
+			}
+			result.ContinuationToken = token
+			after = string(decoded)
+		}
+	} else {
+		marker := c.Query("marker")
+		result.Marker = &marker
+		after = marker
+	}
+
+	// 2. The latest downloadable version of each name after the start key
+	rows, err := db.DB.QueryContext(c.Request.Context(), `
+		SELECT v.name, v.created_at, a.size, IFNULL(a.digest, '')
+		FROM artifact_versions v
+		JOIN Artifacts a ON a.uuid = v.artifact_uuid
+		WHERE v.name > ? AND substr(v.name, 1, length(?)) = ?
+		  AND v.version = (
+			SELECT MAX(v2.version)
+			FROM artifact_versions v2
+			JOIN Artifacts a2 ON a2.uuid = v2.artifact_uuid
+			WHERE v2.name = v.name AND IFNULL(a2.status, '') IN ('', 'UPLOADED'))
+		ORDER BY v.name`,
+		bucket+"/"+after, bucket+"/"+prefix, bucket+"/"+prefix)
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+	defer rows.Close()
+
+	// 3. Keys below the delimiter after the prefix are grouped into common prefixes
+	count := 0
+	var last string // last key or common prefix listed
+	for rows.Next() {
+		var object models.S3Object
+		var name, digest string
+		var modified sql.NullTime
+		if err := rows.Scan(&name, &modified, &object.Size, &digest); err != nil {
+			log.Println("Database error:", err)
+			middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+			return
+		}
+		key := strings.TrimPrefix(name, bucket+"/")
+		var commonPrefix string
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = key[:len(prefix)+i+len(delimiter)]
+				// Listed already, on this page or the one before
+				if commonPrefix == last || commonPrefix <= after {
+					continue
+				}
+			}
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		count++
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, models.S3CommonPrefix{Prefix: s3EncodeKey(commonPrefix, encodingType)})
+			last = commonPrefix
+			continue
+		}
+		object.Key = s3EncodeKey(key, encodingType)
+		object.LastModified = modified.Time.UTC().Format(s3TimeFormat)
+		object.ETag = s3ETag(digest)
+		object.StorageClass = "STANDARD"
+		result.Contents = append(result.Contents, object)
+		last = key
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+
+	if result.IsTruncated && last != "" {
+		if v2 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			result.NextMarker = s3EncodeKey(last, encodingType)
+		}
+	}
+	if v2 {
+		result.KeyCount = &count
+		result.StartAfter = s3EncodeKey(result.StartAfter, encodingType)
+	}
+	result.Prefix = s3EncodeKey(prefix, encodingType)
+	result.Delimiter = s3EncodeKey(delimiter, encodingType)
+	c.XML(http.StatusOK, result)
+}
+
+// s3EncodeKey URL-encodes a key for encoding-type=url
+func s3EncodeKey(key, encodingType string) string {
+	if encodingType != "url" {
+		return key
+	}
+	return url.QueryEscape(key)
+}
+
+// s3ETag is the ETag of an object on the gateway, its quoted hex SHA-256. Uploads
+// through the service always have a digest; without one the storage ETag is used.
+func s3ETag(digest string) string {
+	hexDigest, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok {
+		return ""
+	}
+	return `"` + hexDigest + `"`
+}
+
+// s3Body prepares the request body of an upload for streamUpload: aws-chunked bodies
+// are decoded and verified, with x-amz-decoded-content-length as their length. It
+// returns the hex SHA-256 the content must have, if signed, and false after answering.
+func s3Body(c *gin.Context) (string, bool) {
+	auth := middleware.GetSigV4Auth(c)
+	switch auth.PayloadHash {
+	case middleware.StreamingPayload, middleware.StreamingPayloadTrailer, middleware.StreamingUnsignedTrailer:
+		size, err := strconv.ParseInt(c.GetHeader("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil || size < 0 {
+			middleware.WriteS3Error(c, http.StatusLengthRequired, "MissingContentLength", "x-amz-decoded-content-length is required for aws-chunked uploads")
+			return "", false
+		}
+		c.Request.Body = io.NopCloser(auth.ChunkedBody(c.Request.Body))
+		c.Request.ContentLength = size
+		return "", true
+	case middleware.UnsignedPayload:
+		return "", true
+	}
+	if _, err := hex.DecodeString(auth.PayloadHash); err != nil || len(auth.PayloadHash) != 64 {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "x-amz-content-sha256 must be a SHA-256 or a supported payload mode")
+		return "", false
+	}
+	return strings.ToLower(auth.PayloadHash), true
+}
+
+// s3Labels returns the x-amz-meta-* headers of the request as artifact labels
+func s3Labels(c *gin.Context) (sql.NullString, error) {
+	labels := map[string]string{}
+	for header, values := range c.Request.Header {
+		if key, ok := strings.CutPrefix(strings.ToLower(header), "x-amz-meta-"); ok && key != "" {
+			labels[key] = strings.Join(values, ",")
+		}
+	}
+	return encodeLabels(labels)
+}
+
+// s3PutObject stores the request body as the next version of the object's name
+func s3PutObject(c *gin.Context, obj s3Object) {
+	labels, err := s3Labels(c)
+	if err != nil {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "Invalid metadata")
+		return
+	}
+	sha, ok := s3Body(c)
+	if !ok {
+		return
+	}
+	req := streamRequest{
+		uuid:        uuid.New().String(),
+		filename:    sanitizeFilename(path.Base(obj.key)),
+		project:     obj.bucket,
+		name:        obj.name,
+		contentType: c.ContentType(),
+		labels:      labels,
+		actor:       middleware.GetSigV4Auth(c).Actor,
+		details:     "S3 PutObject",
+		lockName:    true,
+	}
+	if sha != "" {
+		req.digest = "sha256:" + sha
+	}
+
+	result, failure := streamUpload(c, req)
+	if failure != nil {
+		s3UploadFailed(c, failure)
+		return
+	}
+	c.Header("ETag", s3ETag(result.artifact.Digest))
+	c.Header("x-amz-version-id", strconv.FormatInt(result.version, 10))
+	c.Status(http.StatusOK)
+}
+
+// s3UploadFailed answers a failed streaming upload with the matching S3 error
+func s3UploadFailed(c *gin.Context, failure *uploadFailure) {
+	switch {
+	case errors.Is(failure.err, errUploadTooLarge):
+		middleware.WriteS3Error(c, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size")
+	case errors.Is(failure.err, errDigestMismatch):
+		middleware.WriteS3Error(c, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed")
+	case errors.Is(failure.err, middleware.ErrChunkSignature):
+		middleware.WriteS3Error(c, http.StatusForbidden, "SignatureDoesNotMatch", "The chunk signature does not match")
+	case errors.Is(failure.err, middleware.ErrChunkFormat):
+		middleware.WriteS3Error(c, http.StatusBadRequest, "IncompleteBody", failure.err.Error())
+	case errors.Is(failure.err, worker.ErrLocked):
+		middleware.WriteS3Error(c, http.StatusForbidden, "AccessDenied", "The latest version of the object is locked")
+	case failure.status >= http.StatusInternalServerError:
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", failure.message)
+	default:
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidRequest", failure.message)
+	}
+}
+
+// s3ResolveObject points the request at the artifact of the latest version of the
+// object, or the one picked with versionId, and sets the S3 headers of the download
+func s3ResolveObject(c *gin.Context, obj s3Object) bool {
+	ctx := c.Request.Context()
+
+	ref := worker.LatestRef
+	if versionID := c.Query("versionId"); versionID != "" {
+		if _, err := strconv.ParseInt(versionID, 10, 64); err != nil {
+			middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "Invalid version id specified")
+			return false
+		}
+		ref = versionID
+	}
+	version, artifactUUID, err := worker.ResolveVersion(ctx, obj.name, ref)
+	if errors.Is(err, worker.ErrVersionNotFound) {
+		s3NotFound(c, ref)
+		return false
+	}
+	if err != nil {
+		log.Println("Failed to resolve version:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return false
+	}
+
+	// A numbered version may be deleted or not downloadable yet
+	artifact, err := scanArtifact(db.DB.QueryRowContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", artifactUUID))
+	if err == sql.ErrNoRows || (err == nil && artifact.Status != "" && artifact.Status != "UPLOADED") {
+		s3NotFound(c, ref)
+		return false
+	}
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return false
+	}
+
+	c.Set(etagKey, s3ETag(artifact.Digest))
+	c.Header("x-amz-version-id", strconv.FormatInt(version, 10))
+	for key, value := range artifact.Labels {
+		c.Header("x-amz-meta-"+key, value)
+	}
+	c.Params = append(c.Params, gin.Param{Key: "uuid", Value: artifactUUID})
+	return true
+}
+
+// s3NotFound answers a key without a downloadable version, or an unknown version of it
+func s3NotFound(c *gin.Context, ref string) {
+	if ref == worker.LatestRef {
+		middleware.WriteS3Error(c, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+	middleware.WriteS3Error(c, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist")
+}
+
+// s3DeleteObject moves every version of the object to the trash, or the one picked
+// with versionId. Deleting a missing object succeeds, as in S3.
+func s3DeleteObject(c *gin.Context, obj s3Object) {
+	ctx := c.Request.Context()
+
+	uuids, err := liveVersions(ctx, obj.name)
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+	if versionID := c.Query("versionId"); versionID != "" {
+		if _, err := strconv.ParseInt(versionID, 10, 64); err != nil {
+			middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "Invalid version id specified")
+			return
+		}
+		if uuids, err = s3LiveVersion(ctx, obj.name, versionID, uuids); err != nil {
+			log.Println("Failed to resolve version:", err)
+			middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+			return
+		}
+		c.Header("x-amz-version-id", versionID)
+	}
+	if len(uuids) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	_, locked, err := trashVersions(c, obj.name, uuids, middleware.GetSigV4Auth(c).Actor)
+	if locked != nil {
+		middleware.WriteS3Error(c, http.StatusForbidden, "AccessDenied", "A version of the object is locked")
+		return
+	}
+	if err != nil {
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Failed to delete object")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// s3LiveVersion narrows the live versions of name to versionID, if it is one of them
+func s3LiveVersion(ctx context.Context, name, versionID string, live []string) ([]string, error) {
+	_, artifactUUID, err := worker.ResolveVersion(ctx, name, versionID)
+	if errors.Is(err, worker.ErrVersionNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, liveUUID := range live {
+		if liveUUID == artifactUUID {
+			return []string{artifactUUID}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/middleware"
+	"ArtifactService/models"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// errMultipartBusy is returned when a multipart upload is no longer open for parts
+var errMultipartBusy = errors.New("multipart upload is being completed")
+
+// maxPartNumber is the highest part number of a multipart upload, as in S3
+const maxPartNumber = 10000
+
+// maxCompleteBody bounds the CompleteMultipartUpload document
+const maxCompleteBody = 1 << 20
+
+// States of a multipart upload: open uploads take parts; completing ones are being
+// joined by a CompleteMultipartUpload and aborting ones removed, and refuse all else
+const (
+	multipartOpen       = "open"
+	multipartCompleting = "completing"
+	multipartAborting   = "aborting"
+)
+
+// multipartUpload is an unfinished multipart upload of the S3 gateway; its parts are
+// files in worker.MultipartDir until it is completed or aborted
+type multipartUpload struct {
+	id          string
+	contentType string
+	labels      sql.NullString
+	state       string
+}
+
+// multipartPart is a stored part of a multipart upload
+type multipartPart struct {
+	number    int
+	size      int64
+	etag      string // quoted hex MD5, as S3 returns for parts
+	createdAt time.Time
+}
+
+// s3CreateMultipartUpload starts a multipart upload, remembering the content type and
+// metadata the object gets once complete
+func s3CreateMultipartUpload(c *gin.Context, obj s3Object) {
+	ctx := c.Request.Context()
+
+	labels, err := s3Labels(c)
+	if err != nil {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "Invalid metadata")
+		return
+	}
+	uploadID := uuid.New().String()
+	if err := os.MkdirAll(filepath.Join(worker.MultipartDir(), uploadID), 0o700); err != nil {
+		log.Println("Failed to create multipart directory:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Unable to start the upload")
+		return
+	}
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO s3_multipart_uploads (upload_id, bucket, object_key, content_type, labels, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		uploadID, obj.bucket, obj.key, c.ContentType(), labels, middleware.GetSigV4Auth(c).Actor, time.Now().UTC())
+	if err != nil {
+		log.Println("Database error:", err)
+		os.RemoveAll(filepath.Join(worker.MultipartDir(), uploadID))
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+
+	c.XML(http.StatusOK, models.InitiateMultipartUploadResult{Xmlns: models.S3Namespace, Bucket: obj.bucket, Key: obj.key, UploadID: uploadID})
+}
+
+// loadMultipartUpload returns the upload of the request's uploadId, answering 404 unless
+// it is an upload of obj and 403 unless the access key that started it makes the request
+func loadMultipartUpload(c *gin.Context, obj s3Object) (*multipartUpload, bool) {
+	upload := multipartUpload{id: c.Query("uploadId")}
+	var bucket, key string
+	var createdBy sql.NullString
+	err := db.DB.QueryRowContext(c.Request.Context(), "SELECT bucket, object_key, IFNULL(content_type, ''), labels, created_by, state FROM s3_multipart_uploads WHERE upload_id = ?", upload.id).
+		Scan(&bucket, &key, &upload.contentType, &upload.labels, &createdBy, &upload.state)
+	if err == sql.ErrNoRows || (err == nil && (bucket != obj.bucket || key != obj.key)) {
+		middleware.WriteS3Error(c, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist")
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return nil, false
+	}
+	if createdBy.String != middleware.GetSigV4Auth(c).Actor {
+		middleware.WriteS3Error(c, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return nil, false
+	}
+	return &upload, true
+}
+
+// loadOpenMultipartUpload is loadMultipartUpload for requests that change the upload,
+// answering 409 while it is being completed
+func loadOpenMultipartUpload(c *gin.Context, obj s3Object) (*multipartUpload, bool) {
+	upload, ok := loadMultipartUpload(c, obj)
+	if ok && upload.state != multipartOpen {
+		multipartBusy(c)
+		return nil, false
+	}
+	return upload, ok
+}
+
+// claimMultipartUpload moves an open upload to state, answering 409 if it isn't open
+// any more
+func claimMultipartUpload(c *gin.Context, upload *multipartUpload, state string) bool {
+	res, err := db.DB.ExecContext(c.Request.Context(), "UPDATE s3_multipart_uploads SET state = ? WHERE upload_id = ? AND state = ?", state, upload.id, multipartOpen)
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		multipartBusy(c)
+		return false
+	}
+	return true
+}
+
+// reopenMultipartUpload makes a claimed upload open again after the claim failed
+func reopenMultipartUpload(ctx context.Context, uploadID string) {
+	if _, err := db.DB.ExecContext(context.WithoutCancel(ctx), "UPDATE s3_multipart_uploads SET state = ? WHERE upload_id = ?", multipartOpen, uploadID); err != nil {
+		log.Printf("Failed to reopen multipart upload %s: %v", uploadID, err)
+	}
+}
+
+// multipartBusy answers a request that conflicts with a complete or abort in progress
+func multipartBusy(c *gin.Context) {
+	middleware.WriteS3Error(c, http.StatusConflict, "OperationAborted", "A conflicting conditional operation is currently in progress against this resource. Please try again.")
+}
+
+// loadParts returns the stored parts of an upload by part number
+func loadParts(ctx context.Context, uploadID string) ([]multipartPart, error) {
+	rows, err := db.DB.QueryContext(ctx, "SELECT part_number, size, etag, created_at FROM s3_multipart_parts WHERE upload_id = ? ORDER BY part_number", uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var parts []multipartPart
+	for rows.Next() {
+		var part multipartPart
+		if err := rows.Scan(&part.number, &part.size, &part.etag, &part.createdAt); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, rows.Err()
+}
+
+// s3UploadPart stores one part of a multipart upload, replacing an earlier upload of the
+// same part number. All parts together must stay within upload.max_size.
+func s3UploadPart(c *gin.Context, obj s3Object) {
+	ctx := c.Request.Context()
+
+	// 1. Validate the request before reading any of the body
+	partNumber, err := strconv.Atoi(c.Query("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+		return
+	}
+	upload, ok := loadOpenMultipartUpload(c, obj)
+	if !ok {
+		return
+	}
+	sha, ok := s3Body(c)
+	if !ok {
+		return
+	}
+
+	// 2. What is left of upload.max_size after the other parts
+	var limit int64
+	if maxSize := int64(config.Get().Upload.MaxSize); maxSize > 0 {
+		var others int64
+		err := db.DB.QueryRowContext(ctx, "SELECT IFNULL(SUM(size), 0) FROM s3_multipart_parts WHERE upload_id = ? AND part_number != ?", upload.id, partNumber).Scan(&others)
+		if err != nil {
+			log.Println("Database error:", err)
+			middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+			return
+		}
+		limit = maxSize - others
+		if limit <= 0 || c.Request.ContentLength > limit {
+			middleware.WriteS3Error(c, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size")
+			return
+		}
+	}
+
+	// 3. Write the part next to its final name, so a failed upload leaves the previous
+	// part of this number in place
+	dir := filepath.Join(worker.MultipartDir(), upload.id)
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Aborted or completed meanwhile
+			middleware.WriteS3Error(c, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist")
+			return
+		}
+		log.Println("Failed to create part file:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Unable to save part")
+		return
+	}
+	defer os.Remove(tmp.Name())
+	body := &limitedBody{r: c.Request.Body, max: limit}
+	md5Hash, shaHash := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, md5Hash, shaHash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case errors.Is(body.err, errUploadTooLarge):
+		c.Header("Connection", "close")
+		middleware.WriteS3Error(c, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size")
+		return
+	case errors.Is(body.err, middleware.ErrChunkSignature):
+		middleware.WriteS3Error(c, http.StatusForbidden, "SignatureDoesNotMatch", "The chunk signature does not match")
+		return
+	case body.err != nil:
+		log.Printf("Part %d of multipart upload %s aborted by the client after %d bytes: %v", partNumber, upload.id, body.n, body.err)
+		middleware.WriteS3Error(c, http.StatusBadRequest, "IncompleteBody", "The request body terminated unexpectedly")
+		return
+	case err != nil:
+		log.Println("Failed to write part file:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Unable to save part")
+		return
+	case c.Request.ContentLength >= 0 && size != c.Request.ContentLength:
+		middleware.WriteS3Error(c, http.StatusBadRequest, "IncompleteBody", "The request body terminated unexpectedly")
+		return
+	case sha != "" && hex.EncodeToString(shaHash.Sum(nil)) != sha:
+		middleware.WriteS3Error(c, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed")
+		return
+	}
+
+	// 4. Record it and put it in place, unless a complete started meanwhile. The insert
+	// comes first, so a complete claiming the upload waits until the part is in place.
+	etag := `"` + hex.EncodeToString(md5Hash.Sum(nil)) + `"`
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO s3_multipart_parts (upload_id, part_number, size, etag, created_at)
+			SELECT ?, ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM s3_multipart_uploads WHERE upload_id = ? AND state = ?)
+			ON CONFLICT(upload_id, part_number) DO UPDATE SET size = excluded.size, etag = excluded.etag, created_at = excluded.created_at`,
+			upload.id, partNumber, size, etag, time.Now().UTC(), upload.id, multipartOpen)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errMultipartBusy
+		}
+		return os.Rename(tmp.Name(), worker.PartPath(upload.id, partNumber))
+	})
+	if errors.Is(err, errMultipartBusy) {
+		multipartBusy(c)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to store part:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Unable to save part")
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.Status(http.StatusOK)
+}
+
+// s3CompleteMultipartUpload joins the listed parts into the object: they are streamed
+// through the same path as PutObject, so the result is a new version like any upload.
+// The upload is marked completing first, so a concurrent complete or part can't change
+// it. The parts are removed once it is stored; after a failure the upload can be retried.
+func s3CompleteMultipartUpload(c *gin.Context, obj s3Object) {
+	ctx := c.Request.Context()
+
+	upload, ok := loadOpenMultipartUpload(c, obj)
+	if !ok {
+		return
+	}
+
+	// 1. The parts to join, which must have been uploaded as listed
+	sha, ok := s3Body(c)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCompleteBody))
+	if err != nil {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "IncompleteBody", "The request body terminated unexpectedly")
+		return
+	}
+	if sum := sha256.Sum256(data); sha != "" && hex.EncodeToString(sum[:]) != sha {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed")
+		return
+	}
+	var req models.CompleteMultipartUpload
+	if err := xml.Unmarshal(data, &req); err != nil || len(req.Parts) == 0 {
+		middleware.WriteS3Error(c, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+		return
+	}
+
+	// 2. Claim the upload; until it is removed or reopened after a failure, its parts
+	// stay as they are
+	if !claimMultipartUpload(c, upload, multipartCompleting) {
+		return
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			reopenMultipartUpload(ctx, upload.id)
+		}
+	}()
+
+	stored, err := loadParts(ctx, upload.id)
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+	byNumber := map[int]multipartPart{}
+	for _, part := range stored {
+		byNumber[part.number] = part
+	}
+	var total int64
+	for i, listed := range req.Parts {
+		if i > 0 && listed.PartNumber <= req.Parts[i-1].PartNumber {
+			middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order")
+			return
+		}
+		part, ok := byNumber[listed.PartNumber]
+		if !ok || strings.Trim(listed.ETag, `"`) != strings.Trim(part.etag, `"`) {
+			middleware.WriteS3Error(c, http.StatusBadRequest, "InvalidPart", "Part "+strconv.Itoa(listed.PartNumber)+" was not uploaded or its ETag does not match")
+			return
+		}
+		total += part.size
+	}
+
+	// 3. Stream them into storage as one upload
+	readers := make([]io.Reader, 0, len(req.Parts))
+	for _, listed := range req.Parts {
+		f, err := os.Open(worker.PartPath(upload.id, listed.PartNumber))
+		if err != nil {
+			log.Println("Failed to open part file:", err)
+			middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Unable to read part")
+			return
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	c.Request.Body = io.NopCloser(io.MultiReader(readers...))
+	c.Request.ContentLength = total
+	result, failure := streamUpload(c, streamRequest{
+		uuid:        uuid.New().String(),
+		filename:    sanitizeFilename(path.Base(obj.key)),
+		project:     obj.bucket,
+		name:        obj.name,
+		contentType: upload.contentType,
+		labels:      upload.labels,
+		actor:       middleware.GetSigV4Auth(c).Actor,
+		details:     "S3 multipart upload of " + strconv.Itoa(len(req.Parts)) + " parts",
+		lockName:    true,
+	})
+	if failure != nil {
+		s3UploadFailed(c, failure)
+		return
+	}
+	completed = true
+
+	// 4. The parts aren't needed anymore; the cleaner removes them if this fails
+	if err := worker.RemoveMultipartUpload(context.WithoutCancel(ctx), upload.id); err != nil {
+		log.Printf("Failed to remove completed multipart upload %s: %v", upload.id, err)
+	}
+
+	c.Header("x-amz-version-id", strconv.FormatInt(result.version, 10))
+	c.XML(http.StatusOK, models.CompleteMultipartUploadResult{
+		Xmlns:    models.S3Namespace,
+		Location: "/" + obj.bucket + "/" + obj.key,
+		Bucket:   obj.bucket,
+		Key:      obj.key,
+		ETag:     s3ETag(result.artifact.Digest),
+	})
+}
+
+// s3AbortMultipartUpload discards a multipart upload and its parts, unless it is being
+// completed
+func s3AbortMultipartUpload(c *gin.Context, obj s3Object) {
+	upload, ok := loadOpenMultipartUpload(c, obj)
+	if !ok || !claimMultipartUpload(c, upload, multipartAborting) {
+		return
+	}
+	if err := worker.RemoveMultipartUpload(c.Request.Context(), upload.id); err != nil {
+		log.Printf("Failed to remove multipart upload %s: %v", upload.id, err)
+		reopenMultipartUpload(c.Request.Context(), upload.id)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Unable to abort the upload")
+		return
+	}
+	logger.Record(logger.ActionUpload, "", c.ClientIP(), middleware.GetSigV4Auth(c).Actor, "FAILED", "Multipart upload aborted: "+obj.name)
+	c.Status(http.StatusNoContent)
+}
+
+// s3ListParts lists the parts uploaded so far
+func s3ListParts(c *gin.Context, obj s3Object) {
+	upload, ok := loadMultipartUpload(c, obj)
+	if !ok {
+		return
+	}
+	parts, err := loadParts(c.Request.Context(), upload.id)
+	if err != nil {
+		log.Println("Database error:", err)
+		middleware.WriteS3Error(c, http.StatusInternalServerError, "InternalError", "Database error")
+		return
+	}
+
+	result := models.ListPartsResult{Xmlns: models.S3Namespace, Bucket: obj.bucket, Key: obj.key, UploadID: upload.id}
+	for _, part := range parts {
+		result.Parts = append(result.Parts, models.S3Part{
+			PartNumber:   part.number,
+			LastModified: part.createdAt.UTC().Format(s3TimeFormat),
+			ETag:         part.etag,
+			Size:         part.size,
+		})
+	}
+	c.XML(http.StatusOK, result)
+}
@@ -1,12 +1,10 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
 
+	"ArtifactService/config"
 	"ArtifactService/db"
 
 	"github.com/gin-gonic/gin"
@@ -14,7 +12,8 @@ import (
 
 type StorageUsage struct {
 	TotalSpace     int64   `json:"total_space"`
-	UsedSpace      int64   `json:"used_space"`
+	UsedSpace      int64   `json:"used_space"`    // bytes in storage, after compression
+	LogicalSpace   int64   `json:"logical_space"` // total size of the artifacts before compression
 	RemainingSpace int64   `json:"remaining_space"`
 	UsagePercent   float64 `json:"usage_percent"`
 	FileCount      int64   `json:"file_count"`
@@ -23,47 +22,24 @@ type StorageUsage struct {
 // GetStorageUsage godoc
 // @Summary      Get storage usage statistics
 // @Description  Retrieves current storage usage including total space, used space, remaining space, and file count.
+// @Description  used_space counts stored bytes, so compressed artifacts count with their compressed size; logical_space is the uncompressed total.
 // @Tags         storage
 // @Produce      json
 // @Success      200  {object}  StorageUsage
 // @Failure      500  {object}  map[string]string
 // @Router       /artifact-service/v1/storage/usage [get]
 func GetStorageUsage(c *gin.Context) {
-	// 1. Get Quota (Total Space) from Env
-	quotaStr := os.Getenv("STORAGE_QUOTA")
-	var totalSpace int64
-	if quotaStr == "" {
-		// Default to 10GB if not set, for demonstration purposes. 
-		// Or strictly 0 if we want to show "unlimited". 
-		// Let's default to 10GB (10 * 1024 * 1024 * 1024) to make it look realistic for the API request.
-		totalSpace = 10 * 1024 * 1024 * 1024 
-	} else {
-		var err error
-		totalSpace, err = strconv.ParseInt(quotaStr, 10, 64)
-		if err != nil {
-			log.Printf("Invalid STORAGE_QUOTA value: %s, defaulting to 10GB", quotaStr)
-			totalSpace = 10 * 1024 * 1024 * 1024
-		}
-	}
+	ctx := c.Request.Context()
 
-	// 2. Query Database for Used Space and File Count
-	var usedSpace int64
-	var fileCount int64
+	// 1. Get Quota (Total Space) from configuration (storage.quota / STORAGE_QUOTA, default 10GiB)
+	totalSpace := int64(config.Get().Storage.Quota)
 
-	// calculating used space and file count
-	// COALESCE(SUM(size), 0) handles the case where the table is empty (returns 0 instead of NULL)
-	row := db.DB.QueryRow("SELECT COUNT(*), COALESCE(SUM(size), 0) FROM Artifacts")
-	err := row.Scan(&fileCount, &usedSpace)
+	// 2. Query Database for Used Space and File Count
+	fileCount, usedSpace, logicalSpace, err := db.GetUsageDetails(ctx)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// Should be handled by COALESCE/COUNT logic, but just in case
-			fileCount = 0
-			usedSpace = 0
-		} else {
-			log.Println("Database query error:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve storage usage"})
-			return
-		}
+		log.Println("Database query error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve storage usage"})
+		return
 	}
 
 	// 3. Calculate Derived Metrics
@@ -83,6 +59,7 @@ func GetStorageUsage(c *gin.Context) {
 	response := StorageUsage{
 		TotalSpace:     totalSpace,
 		UsedSpace:      usedSpace,
+		LogicalSpace:   logicalSpace,
 		RemainingSpace: remainingSpace,
 		UsagePercent:   usagePercent,
 		FileCount:      fileCount,
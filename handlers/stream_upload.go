@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"ArtifactService/config"
+	"ArtifactService/contenttype"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/models"
+	"ArtifactService/storage"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// errUploadTooLarge is returned by limitedBody once more than upload.max_size bytes were read
+var errUploadTooLarge = errors.New("upload exceeds upload.max_size")
+
+// errArtifactExists is returned when a streaming upload's UUID is already taken
+var errArtifactExists = errors.New("artifact exists")
+
+// limitedBody reads a request body up to max bytes (0 for no limit) and remembers why
+// reading stopped, as the storage uploader doesn't return read errors unwrapped
+type limitedBody struct {
+	r   io.Reader
+	max int64
+	n   int64
+	err error // errUploadTooLarge or the client's read error, nil after a clean EOF
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.max > 0 && b.n > b.max {
+		b.err = errUploadTooLarge
+		return n, b.err
+	}
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// StreamUpload godoc
+// @Summary      Upload a file as the raw request body
+// @Description  Streams the request body straight to storage, without multipart buffering. The body may be sent with Content-Length or chunked.
+// @Description  The client picks the artifact UUID, which must not exist yet. The filename comes from the filename query parameter or a Content-Disposition header.
+// @Description  Uploads larger than upload.max_size are refused before reading (Content-Length) or aborted when the limit is crossed (chunked).
+// @Tags         files
+// @Accept       application/octet-stream
+// @Produce      json
+// @Param        uuid      path    string  true   "Artifact UUID chosen by the client"
+// @Param        filename  query   string  false  "Filename, defaults to the Content-Disposition filename or the UUID"
+// @Param        project   query   string  false  "Project, selects the content type policy (content_types.projects)"
+// @Param        labels    query   string  false  "Labels as a JSON object, e.g. {\"build\":\"1234\"}"
+// @Param        name      query   string  false  "Name within the project; the upload becomes the next version of project/name once complete"
+// @Param        file      body    string  true   "File content"
+// @Success      201  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      413  {object}  map[string]string
+// @Failure      415  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /artifact-service/v1/artifacts/{uuid}/content [put]
+func StreamUpload(c *gin.Context) {
+	// 1. Validate the request before reading any of the body
+	id, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "uuid must be a UUID"})
+		return
+	}
+	artifactUUID := id.String()
+
+	var labels map[string]string
+	if raw := c.Query("labels"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "labels must be a JSON object of strings"})
+			return
+		}
+	}
+	labelsColumn, err := encodeLabels(labels)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid labels"})
+		return
+	}
+
+	filename := c.Query("filename")
+	if filename == "" {
+		if _, params, err := mime.ParseMediaType(c.GetHeader("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+	}
+	if filename == "" {
+		filename = artifactUUID
+	}
+	filename = sanitizeFilename(filename)
+
+	project := c.Query("project")
+	var artifactName string
+	if name := c.Query("name"); name != "" {
+		if artifactName, err = worker.VersionName(project, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	result, failure := streamUpload(c, streamRequest{
+		uuid:        artifactUUID,
+		filename:    filename,
+		project:     project,
+		name:        artifactName,
+		contentType: c.ContentType(),
+		labels:      labelsColumn,
+		details:     "Streaming upload",
+	})
+	if failure != nil {
+		c.JSON(failure.status, failure.body())
+		return
+	}
+	c.JSON(http.StatusCreated, result.response())
+}
+
+// streamRequest is a validated streaming upload
+type streamRequest struct {
+	uuid, filename, project string
+	name                    string // versioned name the upload is added to, if any
+	contentType             string // declared content type, application/octet-stream if empty
+	labels                  sql.NullString
+	digest                  string // expected "sha256:<hex>" digest of the content, if known
+	actor                   string // user_session of the audit entries
+	details                 string // audit details of the upload
+	// lockName refuses the upload while the latest version of name is locked, as it
+	// replaces what the name serves
+	lockName bool
+}
+
+// streamResult is a completed streaming upload
+type streamResult struct {
+	artifact models.Artifact
+	name     string
+	version  int64 // version of name, 0 without a name
+}
+
+// response is the JSON answer to a completed streaming upload
+func (r *streamResult) response() gin.H {
+	response := gin.H{
+		"message":               "File uploaded successfully",
+		"uuid":                  r.artifact.UUID,
+		"status":                r.artifact.Status,
+		"size":                  r.artifact.Size,
+		"digest":                r.artifact.Digest,
+		"detected_content_type": r.artifact.DetectedContentType,
+		"stored_size":           r.artifact.StoredSize,
+	}
+	if r.version != 0 {
+		response["name"] = r.name
+		response["version"] = r.version
+	}
+	return response
+}
+
+// uploadFailure is why a streaming upload failed, with the status to answer
+type uploadFailure struct {
+	status  int
+	message string
+	err     error // errUploadTooLarge, errDigestMismatch, worker.ErrLocked, ... if known
+	details gin.H // further fields of the JSON answer
+}
+
+// body is the JSON answer to a failed streaming upload
+func (f *uploadFailure) body() gin.H {
+	body := gin.H{"error": f.message}
+	for k, v := range f.details {
+		body[k] = v
+	}
+	return body
+}
+
+// errDigestMismatch is returned when the content doesn't match the digest the client sent
+var errDigestMismatch = errors.New("content does not match the digest")
+
+// errContentType is returned when the content type policy rejects an upload
+var errContentType = errors.New("content type not allowed")
+
+// streamUpload stores a validated streaming upload from the request body. The caller
+// answers the request from the result or failure.
+func streamUpload(c *gin.Context, req streamRequest) (*streamResult, *uploadFailure) {
+	ctx := c.Request.Context()
+	artifactUUID, filename, project, artifactName := req.uuid, req.filename, req.project, req.name
+
+	maxSize := int64(config.Get().Upload.MaxSize)
+	size := c.Request.ContentLength // -1 when chunked
+	if maxSize > 0 && size > maxSize {
+		return nil, &uploadFailure{status: http.StatusRequestEntityTooLarge, message: "File exceeds the upload size limit", err: errUploadTooLarge, details: gin.H{"max_size": maxSize}}
+	}
+
+	declared := req.contentType
+	if declared == "" {
+		declared = "application/octet-stream"
+	}
+
+	// 2. Reserve the UUID so concurrent uploads can't write the same object, and record
+	// the upload so a crash can't leave an orphan object or a stale reservation. A UUID
+	// whose delete is still being finished can't be reused yet.
+	var intentID int64
+	err := withTx(ctx, func(tx *sql.Tx) error {
+		var busy bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM intents WHERE artifact_uuid = ?)", artifactUUID).Scan(&busy); err != nil {
+			return err
+		}
+		if busy {
+			return errArtifactExists
+		}
+		if req.lockName {
+			if _, err := worker.CheckNameLock(ctx, tx, artifactName); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO Artifacts (uuid, filename, content_type, size, status, labels, project)
+			VALUES (?, ?, ?, ?, 'UPLOADING', ?, ?)
+			ON CONFLICT(uuid) DO NOTHING`,
+			artifactUUID, filename, declared, max(size, 0), req.labels, project)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errArtifactExists
+		}
+		intentID, err = db.BeginIntent(ctx, tx, db.IntentUpload, artifactUUID)
+		return err
+	})
+	if errors.Is(err, errArtifactExists) {
+		return nil, &uploadFailure{status: http.StatusConflict, message: "Artifact already exists", err: err}
+	}
+	if errors.Is(err, worker.ErrLocked) {
+		return nil, nameLocked(c, req)
+	}
+	if err != nil {
+		log.Println("Failed to reserve artifact:", err)
+		return nil, &uploadFailure{status: http.StatusInternalServerError, message: "Database error", err: err}
+	}
+	// Release the reservation unless the upload gets through, also when the client is gone
+	completed := false
+	defer func() {
+		if !completed {
+			releaseReservation(context.WithoutCancel(ctx), intentID, artifactUUID)
+		}
+	}()
+
+	// 3. Detect the real type from the first bytes and enforce the content type policy
+	body := &limitedBody{r: c.Request.Body, max: maxSize}
+	head := make([]byte, contenttype.SniffLen)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, streamUploadFailed(c, req, body, err)
+	}
+	head = head[:n]
+	detected := contenttype.Detect(head)
+	if err := contenttype.CheckUpload(project, nil, declared, detected); err != nil {
+		logger.Record(logger.ActionUpload, "", c.ClientIP(), req.actor, "FAILED", "Rejected: "+err.Error())
+		return nil, &uploadFailure{status: http.StatusUnsupportedMediaType, message: err.Error(), err: errContentType,
+			details: gin.H{"declared_content_type": declared, "detected_content_type": detected}}
+	}
+
+	// 4. Pipe the rest of the body into storage; a client disconnect cancels ctx,
+	// which aborts the storage upload
+	stored, err := storeUpload(ctx, artifactUUID, filename, declared, detected, head, body, size)
+	if err != nil {
+		return nil, streamUploadFailed(c, req, body, err)
+	}
+	if req.digest != "" && stored.digest != req.digest {
+		logger.Record(logger.ActionUpload, artifactUUID, c.ClientIP(), req.actor, "FAILED", "Content does not match the digest sent")
+		return nil, &uploadFailure{status: http.StatusBadRequest, message: "Content does not match the digest sent", err: errDigestMismatch}
+	}
+
+	// 5. Record the metadata, the upload now counts like any other
+	metadata := models.Artifact{
+		UUID:                artifactUUID,
+		Filename:            filename,
+		ContentType:         declared,
+		DetectedContentType: detected,
+		Project:             project,
+		Size:                stored.size,
+		Status:              worker.StoredStatus(),
+		Digest:              stored.digest,
+		ContentEncoding:     stored.content.Encoding,
+		StoredSize:          stored.content.StoredSize,
+		Encryption:          stored.encryption.String,
+		KeyID:               stored.keyID.String,
+	}
+	var version int64
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE Artifacts
+			SET size = ?, status = ?, detected_content_type = ?, digest = ?, content_encoding = ?, stored_size = ?, encryption = ?, key_id = ?, wrapped_key = ?
+			WHERE uuid = ? AND status = 'UPLOADING'`,
+			metadata.Size, metadata.Status, metadata.DetectedContentType, metadata.Digest,
+			sql.NullString{String: metadata.ContentEncoding, Valid: metadata.ContentEncoding != ""}, metadata.StoredSize,
+			stored.encryption, stored.keyID, stored.wrappedKey, artifactUUID)
+		if err != nil {
+			return err
+		}
+		// The reconciler rolls back uploads running longer than worker.intent_timeout
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errors.New("reservation was rolled back")
+		}
+		// Numbered when complete, so versions are in the order uploads finished
+		if artifactName != "" {
+			if req.lockName {
+				if _, err := worker.CheckNameLock(ctx, tx, artifactName); err != nil {
+					return err
+				}
+			}
+			if version, err = worker.AddVersion(ctx, tx, artifactName, artifactUUID); err != nil {
+				return err
+			}
+		}
+		return db.EndIntent(ctx, tx, intentID)
+	})
+	if errors.Is(err, worker.ErrLocked) {
+		return nil, nameLocked(c, req)
+	}
+	if err != nil {
+		log.Println("Failed to update metadata:", err)
+		return nil, &uploadFailure{status: http.StatusInternalServerError, message: "Database error", err: err}
+	}
+	completed = true
+	if metadata.Status == "SCANNING" {
+		worker.NotifyScan()
+	}
+	if artifactName != "" {
+		applyVersionRetention(ctx, artifactName)
+	}
+
+	logger.Record(logger.ActionUpload, artifactUUID, c.ClientIP(), req.actor, "SUCCESS", req.details)
+	return &streamResult{artifact: metadata, name: artifactName, version: version}, nil
+}
+
+// releaseReservation removes what a failed streaming upload left in storage, then its
+// reservation and intent. If storage can't be reached both stay for the reconciler.
+func releaseReservation(ctx context.Context, intentID int64, artifactUUID string) {
+	if err := storage.DeleteFile(ctx, artifactUUID); err != nil {
+		log.Printf("Failed to remove failed upload %s, left to the reconciler: %v", artifactUUID, err)
+		return
+	}
+	err := withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM Artifacts WHERE uuid = ? AND status = 'UPLOADING'", artifactUUID); err != nil {
+			return err
+		}
+		return db.EndIntent(ctx, tx, intentID)
+	})
+	if err != nil {
+		log.Printf("Failed to release reservation of %s: %v", artifactUUID, err)
+	}
+}
+
+// streamUploadFailed describes a streaming upload that failed while reading the body or
+// writing to storage, telling a size limit or client disconnect from a storage error
+func streamUploadFailed(c *gin.Context, req streamRequest, body *limitedBody, err error) *uploadFailure {
+	switch {
+	case errors.Is(body.err, errUploadTooLarge):
+		log.Printf("Streaming upload of %s aborted after %d bytes: %v", req.uuid, body.n, body.err)
+		logger.Record(logger.ActionUpload, req.uuid, c.ClientIP(), req.actor, "FAILED", "Upload exceeds upload.max_size")
+		// Don't wait for the rest of a body we won't read
+		c.Header("Connection", "close")
+		return &uploadFailure{status: http.StatusRequestEntityTooLarge, message: "File exceeds the upload size limit", err: errUploadTooLarge, details: gin.H{"max_size": body.max}}
+	case body.err != nil || c.Request.Context().Err() != nil:
+		log.Printf("Streaming upload of %s aborted by the client after %d bytes: %v", req.uuid, body.n, err)
+		logger.Record(logger.ActionUpload, req.uuid, c.ClientIP(), req.actor, "FAILED", "Upload aborted by the client")
+		return &uploadFailure{status: http.StatusBadRequest, message: "Upload aborted", err: body.err}
+	default:
+		log.Println("Failed to upload file to Ceph:", err)
+		return &uploadFailure{status: http.StatusInternalServerError, message: "Unable to save file", err: err}
+	}
+}
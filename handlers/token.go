@@ -1,19 +1,31 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"ArtifactService/config"
+	"ArtifactService/contenttype"
 	"ArtifactService/db"
+	"ArtifactService/encryption"
 	"ArtifactService/logger"
+	"ArtifactService/metrics"
 	"ArtifactService/models"
 	"ArtifactService/storage"
+	"ArtifactService/tracing"
+	"ArtifactService/worker"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GenDownloadPresignedURL godoc
@@ -25,9 +37,12 @@ import (
 // @Param        request body models.GenTokenRequest true "Token constraints with artifact UUID"
 // @Success      200  {object}  map[string]string
 // @Failure      400  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /genDownloadPresignedURL [post]
 func GenDownloadPresignedURL(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	var req models.GenTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -35,18 +50,26 @@ func GenDownloadPresignedURL(c *gin.Context) {
 	}
 
 	// Verify artifact exists
-	var exists bool
-	err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM Artifacts WHERE uuid = ?)", req.ArtifactUUID).Scan(&exists)
-	if err != nil || !exists {
+	var status sql.NullString
+	err := db.DB.QueryRowContext(ctx, "SELECT status FROM Artifacts WHERE uuid = ?", req.ArtifactUUID).Scan(&status)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Artifact not found"})
 		return
 	}
+	if status.String == "DELETED" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Artifact not found"})
+		return
+	}
+	if status.String == "QUARANTINED" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is quarantined"})
+		return
+	}
 
 	// Generate Token
 	token := uuid.New().String()
 
 	// Insert into DB
-	_, err = db.DB.Exec(`
+	_, err = db.DB.ExecContext(ctx, `
 		INSERT INTO tokens (token, artifact_uuid, valid_from, valid_to, max_downloads, allowed_cidr)
 		VALUES (?, ?, ?, ?, ?, ?)`,
 		token, req.ArtifactUUID, req.ValidFrom, req.ValidTo, req.MaxDownloads, req.AllowedCIDR)
@@ -82,26 +105,27 @@ func GenDownloadPresignedURL(c *gin.Context) {
 // @Failure      500  {object}  map[string]string
 // @Router       /genUploadPresignedURL [post]
 func GenUploadPresignedURL(c *gin.Context) {
-	var req struct {
-		ValidFrom    *time.Time `json:"valid_from"`
-		ValidTo      *time.Time `json:"valid_to"`
-		MaxUploads   *int       `json:"max_uploads"`
-		AllowedCIDR  string     `json:"allowed_cidr"`
-	}
-	
+	ctx := c.Request.Context()
+
+	var req models.GenUploadTokenRequest
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := contenttype.ValidatePatterns(req.AllowedTypes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "allowed_types: " + err.Error()})
+		return
+	}
 
 	// Generate Token
 	token := uuid.New().String()
 
 	// Insert into DB with NULL artifact_uuid (will be set during upload)
-	_, err := db.DB.Exec(`
-		INSERT INTO tokens (token, artifact_uuid, valid_from, valid_to, max_downloads, allowed_cidr)
-		VALUES (?, NULL, ?, ?, ?, ?)`,
-		token, req.ValidFrom, req.ValidTo, req.MaxUploads, req.AllowedCIDR)
+	_, err := db.DB.ExecContext(ctx, `
+		INSERT INTO tokens (token, artifact_uuid, valid_from, valid_to, max_downloads, allowed_cidr, allowed_types, project)
+		VALUES (?, NULL, ?, ?, ?, ?, ?, ?)`,
+		token, req.ValidFrom, req.ValidTo, req.MaxUploads, req.AllowedCIDR, strings.Join(req.AllowedTypes, ","), req.Project)
 	
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
@@ -125,15 +149,22 @@ func GenUploadPresignedURL(c *gin.Context) {
 // DownloadFileWithToken godoc
 // @Summary      Download file with Presigned URL
 // @Description  Download a file using a token, enforcing constraints. Returns a 302 redirect to S3 presigned URL for direct download.
+// @Description  Encrypted artifacts, and artifacts compressed at rest with an encoding Accept-Encoding doesn't allow, are streamed by the service instead (200).
 // @Tags         tokens
 // @Produce      octet-stream
 // @Param        token path string true "Access Token"
+// @Param        disposition query string false "inline to display previewable types (download.inline_types) in the browser" Enums(attachment, inline)
+// @Param        Accept-Encoding header string false "gzip and/or zstd to be redirected to compressed artifacts as stored"
+// @Success      200  {file}    file    "Content of an encrypted or compressed artifact, streamed by the service"
 // @Success      302  {string}  string  "Redirect to S3 presigned URL"
-// @Failure      403  {object}  map[string]string
+// @Failure      403  {object}  map[string]string  "Token constraint violated or artifact QUARANTINED"
 // @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string  "Artifact still SCANNING"
 // @Failure      500  {object}  map[string]string
 // @Router       /artifacts/{token} [get]
 func DownloadFileWithToken(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	token := c.Param("token")
 
 	var t models.Token
@@ -142,16 +173,19 @@ func DownloadFileWithToken(c *gin.Context) {
 
 	// Query token and artifact details
 	// We need to fetch basic info + current state
-	row := db.DB.QueryRow(`
-		SELECT t.token, t.artifact_uuid, t.valid_from, t.valid_to, t.max_downloads, t.current_downloads, t.allowed_cidr,
-		       a.filename, a.content_type
+	row := db.DB.QueryRowContext(ctx, `
+		SELECT t.token, t.artifact_uuid, t.valid_from, t.valid_to, t.max_downloads, t.current_downloads, t.allowed_cidr, t.revoked_at,
+		       a.filename, a.content_type, a.status, a.size
 		FROM tokens t
 		JOIN Artifacts a ON t.artifact_uuid = a.uuid
 		WHERE t.token = ?`, token)
 	
-	err := row.Scan(&t.Token, &t.ArtifactUUID, &t.ValidFrom, &t.ValidTo, &t.MaxDownloads, &t.CurrentDownloads, &t.AllowedCIDR, &filename, &contentType)
+	var status sql.NullString
+	var size int64
+	err := row.Scan(&t.Token, &t.ArtifactUUID, &t.ValidFrom, &t.ValidTo, &t.MaxDownloads, &t.CurrentDownloads, &t.AllowedCIDR, &t.RevokedAt, &filename, &contentType, &status, &size)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			metrics.RecordTokenValidation("download", metrics.TokenNotFound)
 			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired token"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -159,50 +193,85 @@ func DownloadFileWithToken(c *gin.Context) {
 		return
 	}
 
-	now := time.Now()
-
-	// 1. Time Validation
-	if t.ValidFrom != nil && now.Before(*t.ValidFrom) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Token not yet valid"})
+	// Never hand out content that hasn't passed the malware scan. Checked before the
+	// token so a blocked attempt doesn't use up a download.
+	switch status.String {
+	case "SCANNING":
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is being scanned, try again later"})
 		return
-	}
-	if t.ValidTo != nil && now.After(*t.ValidTo) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Token expired"})
+	case "QUARANTINED":
+		logger.Record(logger.ActionDownload, t.ArtifactUUID, c.ClientIP(), "", "FAILED", "Blocked: artifact is quarantined")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Artifact is quarantined"})
 		return
 	}
 
-	// 2. Count Validation
-	if t.MaxDownloads != nil && t.CurrentDownloads >= *t.MaxDownloads {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Download limit reached"})
+	// Enforce validity window, usage limit and CIDR restriction
+	if !validateToken(ctx, c, &t, "download") {
 		return
 	}
 
-	// 3. IP Validation
-	if t.AllowedCIDR != "" {
-		clientIP := c.ClientIP()
-		_, ipNet, err := net.ParseCIDR(t.AllowedCIDR)
+	// Storage can serve the content itself unless it is encrypted or compressed with an
+	// encoding the client can't decode; those are streamed from here instead
+	stored, err := worker.LoadContent(ctx, t.ArtifactUUID)
+	if err != nil {
+		log.Printf("Failed to load content of %s: %v", t.ArtifactUUID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	sendEncoded := stored.Encoding != "" && acceptsEncoding(c.Request, stored.Encoding)
+	stream := stored.Encrypted() || (stored.Encoding != "" && !sendEncoded)
+	if stream && stored.StoredSize == 0 {
+		// Presigned uploads don't record their stored size
+		info, err := stored.Stat(ctx)
 		if err != nil {
-			// If CIDR in DB is invalid, we probably should block or log error. Blocking for safety.
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid CIDR configuration"})
-			return
-		}
-		ip := net.ParseIP(clientIP)
-		if ip == nil || !ipNet.Contains(ip) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "IP not allowed"})
+			log.Printf("Failed to stat %s: %v", t.ArtifactUUID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
 			return
 		}
+		stored.StoredSize = info.Size
 	}
 
 	// Increment download count
-	_, err = db.DB.Exec("UPDATE tokens SET current_downloads = current_downloads + 1 WHERE token = ?", token)
+	_, err = db.DB.ExecContext(ctx, "UPDATE tokens SET current_downloads = current_downloads + 1 WHERE token = ?", token)
 	if err != nil {
 		// Just log error, don't fail download? Or fail? Better fail to enforce limits strictly.
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update download stats"})
 		return
 	}
 
+	disposition := contentDisposition(dispositionFor(c.Query("disposition"), contentType), filename)
+
+	if stream {
+		headers := map[string]string{"Content-Disposition": disposition}
+		var body io.ReadCloser
+		length := stored.EncodedSize(stored.StoredSize)
+		if sendEncoded {
+			body, err = stored.OpenEncoded(ctx, 0)
+			headers["Content-Encoding"] = stored.Encoding
+		} else {
+			body, err = stored.Open(ctx)
+			if stored.Encoding != "" {
+				length = size
+			}
+		}
+		if err != nil {
+			log.Println("Failed to download file from Ceph:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+		defer body.Close()
+
+		if stored.Encoding != "" {
+			c.Header("Vary", "Accept-Encoding")
+		}
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.DataFromReader(http.StatusOK, length, contentType, metrics.CountingReader(body, metrics.DownloadBytes), headers)
+		logger.Record(logger.ActionDownload, t.ArtifactUUID, c.ClientIP(), "", "SUCCESS", "Download via token (streamed)")
+		return
+	}
+
 	// Generate presigned URL for direct S3 download (expires in 15 minutes)
-	presignedURL, err := storage.GeneratePresignedURL(t.ArtifactUUID, 15)
+	presignedURL, err := storage.GeneratePresignedURL(ctx, t.ArtifactUUID, 15, disposition)
 	if err != nil {
 		log.Println("Failed to generate presigned URL:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate download URL"})
@@ -220,6 +289,7 @@ func DownloadFileWithToken(c *gin.Context) {
 // UploadFileWithToken godoc
 // @Summary      Upload file with Token
 // @Description  Generate a presigned upload URL using a token, enforcing constraints. Client uploads directly to S3.
+// @Description  The declared content_type must pass the token's allowed_types and the project policy; the uploaded bytes are checked again on completion.
 // @Tags         tokens
 // @Accept       json
 // @Produce      json
@@ -228,37 +298,50 @@ func DownloadFileWithToken(c *gin.Context) {
 // @Success      200  {object}  map[string]string
 // @Failure      403  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
+// @Failure      413  {object}  map[string]string
+// @Failure      415  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /artifacts/upload/{token} [post]
 func UploadFileWithToken(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	token := c.Param("token")
 
-	var uploadReq struct {
-		Filename    string `json:"filename" binding:"required"`
-		ContentType string `json:"content_type" binding:"required"`
-		Size        int64  `json:"size" binding:"required"`
-	}
+	var uploadReq models.UploadRequest
 
 	if err := c.ShouldBindJSON(&uploadReq); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if maxSize := int64(config.Get().Upload.MaxSize); maxSize > 0 && uploadReq.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds the upload size limit", "max_size": maxSize})
+		return
+	}
+	uploadReq.Filename = sanitizeFilename(uploadReq.Filename)
+	labelsColumn, err := encodeLabels(uploadReq.Labels)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid labels"})
+		return
+	}
 
 	var t models.Token
-	var dbArtifactUUID sql.NullString
+	var dbArtifactUUID, allowedTypes, project sql.NullString
 
 	// Query token details
-	row := db.DB.QueryRow(`
-		SELECT token, artifact_uuid, valid_from, valid_to, max_downloads, current_downloads, allowed_cidr
+	row := db.DB.QueryRowContext(ctx, `
+		SELECT token, artifact_uuid, valid_from, valid_to, max_downloads, current_downloads, allowed_cidr, allowed_types, project, revoked_at
 		FROM tokens
 		WHERE token = ?`, token)
 	
-	err := row.Scan(&t.Token, &dbArtifactUUID, &t.ValidFrom, &t.ValidTo, &t.MaxDownloads, &t.CurrentDownloads, &t.AllowedCIDR)
+	err = row.Scan(&t.Token, &dbArtifactUUID, &t.ValidFrom, &t.ValidTo, &t.MaxDownloads, &t.CurrentDownloads, &t.AllowedCIDR, &allowedTypes, &project, &t.RevokedAt)
 	if dbArtifactUUID.Valid {
 		t.ArtifactUUID = dbArtifactUUID.String
 	}
+	t.AllowedTypes = contenttype.SplitList(allowedTypes.String)
+	t.Project = project.String
 	if err != nil {
 		if err == sql.ErrNoRows {
+			metrics.RecordTokenValidation("upload", metrics.TokenNotFound)
 			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired token"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -266,44 +349,39 @@ func UploadFileWithToken(c *gin.Context) {
 		return
 	}
 
-	now := time.Now()
-
-	// 1. Time Validation
-	if t.ValidFrom != nil && now.Before(*t.ValidFrom) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Token not yet valid"})
-		return
-	}
-	if t.ValidTo != nil && now.After(*t.ValidTo) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Token expired"})
+	// Enforce validity window, usage limit and CIDR restriction
+	if !validateToken(ctx, c, &t, "upload") {
 		return
 	}
 
-	// 2. Count Validation (reusing max_downloads for upload limit)
-	if t.MaxDownloads != nil && t.CurrentDownloads >= *t.MaxDownloads {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Upload limit reached"})
+	// Reject disallowed types early, the content itself is checked when the upload completes
+	if err := contenttype.CheckUpload(t.Project, t.AllowedTypes, uploadReq.ContentType, ""); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 3. IP Validation
-	if t.AllowedCIDR != "" {
-		clientIP := c.ClientIP()
-		_, ipNet, err := net.ParseCIDR(t.AllowedCIDR)
+	// Generate UUID for the new artifact
+	artifactUUID := uuid.New().String()
+
+	// With encryption.presigned_sse_c storage encrypts the upload with a per-artifact key,
+	// stored wrapped like envelope data keys
+	var customerKey []byte
+	var encryptionMode, keyID, wrappedKey sql.NullString
+	if config.Get().Encryption.PresignedSSEC && encryption.Enabled() {
+		key, id, wrapped, err := encryption.NewWrappedKey(ctx)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid CIDR configuration"})
-			return
-		}
-		ip := net.ParseIP(clientIP)
-		if ip == nil || !ipNet.Contains(ip) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "IP not allowed"})
+			log.Println("Failed to create SSE-C key:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate upload URL"})
 			return
 		}
+		customerKey = key
+		encryptionMode = sql.NullString{String: encryption.ModeSSEC, Valid: true}
+		keyID = sql.NullString{String: id, Valid: true}
+		wrappedKey = sql.NullString{String: wrapped, Valid: true}
 	}
 
-	// Generate UUID for the new artifact
-	artifactUUID := uuid.New().String()
-
 	// Generate presigned upload URL (expires in 15 minutes)
-	presignedURL, err := storage.GeneratePresignedUploadURL(artifactUUID, uploadReq.Filename, uploadReq.ContentType, 15)
+	presignedURL, err := storage.GeneratePresignedUploadURL(ctx, artifactUUID, uploadReq.Filename, uploadReq.ContentType, 15, customerKey)
 	if err != nil {
 		log.Println("Failed to generate presigned upload URL:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate upload URL"})
@@ -311,10 +389,10 @@ func UploadFileWithToken(c *gin.Context) {
 	}
 
 	// Save artifact metadata to database
-	_, err = db.DB.Exec(`
-		INSERT INTO Artifacts (uuid, filename, content_type, size, status)
-		VALUES (?, ?, ?, ?, 'PENDING')`,
-		artifactUUID, uploadReq.Filename, uploadReq.ContentType, uploadReq.Size)
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO Artifacts (uuid, filename, content_type, size, status, labels, project, upload_token, encryption, key_id, wrapped_key)
+		VALUES (?, ?, ?, ?, 'PENDING', ?, ?, ?, ?, ?, ?)`,
+		artifactUUID, uploadReq.Filename, uploadReq.ContentType, uploadReq.Size, labelsColumn, t.Project, token, encryptionMode, keyID, wrappedKey)
 	if err != nil {
 		log.Println("Failed to insert artifact metadata:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -322,16 +400,79 @@ func UploadFileWithToken(c *gin.Context) {
 	}
 
 	// Increment upload count
-	_, err = db.DB.Exec("UPDATE tokens SET current_downloads = current_downloads + 1 WHERE token = ?", token)
+	_, err = db.DB.ExecContext(ctx, "UPDATE tokens SET current_downloads = current_downloads + 1 WHERE token = ?", token)
 	if err != nil {
 		log.Println("Failed to update upload stats:", err)
 		// Continue anyway, the upload URL is already generated
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"presigned_url": presignedURL,
 		"uuid":          artifactUUID,
 		"expires_in":    "15 minutes",
-	})
+	}
+	if customerKey != nil {
+		// The upload is refused unless it carries the SSE-C headers
+		response["headers"] = storage.SSECustomerHeaders(customerKey)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// validateToken enforces the time window, usage limit and CIDR constraints of a token.
+// tokenType is "download" or "upload". On failure the error response is written and false returned.
+func validateToken(ctx context.Context, c *gin.Context, t *models.Token, tokenType string) bool {
+	_, span := tracing.Start(ctx, "ArtifactService/handlers", "token.validate",
+		trace.WithAttributes(attribute.String("token.type", tokenType)))
+
+	status, message, outcome := checkTokenConstraints(t, c.ClientIP(), tokenType)
+	metrics.RecordTokenValidation(tokenType, outcome)
+	span.SetAttributes(attribute.String("token.outcome", outcome))
+
+	if outcome != metrics.TokenValid {
+		tracing.End(span, errors.New(message))
+		c.JSON(status, gin.H{"error": message})
+		return false
+	}
+	span.End()
+	return true
 }
 
+func checkTokenConstraints(t *models.Token, clientIP, tokenType string) (int, string, string) {
+	now := time.Now()
+
+	// 0. Revocation (deleting the artifact revokes its tokens)
+	if t.RevokedAt != nil {
+		return http.StatusForbidden, "Token revoked", metrics.TokenRevoked
+	}
+
+	// 1. Time Validation
+	if t.ValidFrom != nil && now.Before(*t.ValidFrom) {
+		return http.StatusForbidden, "Token not yet valid", metrics.TokenNotYetValid
+	}
+	if t.ValidTo != nil && now.After(*t.ValidTo) {
+		return http.StatusForbidden, "Token expired", metrics.TokenExpired
+	}
+
+	// 2. Count Validation (upload tokens reuse max_downloads as the upload limit)
+	if t.MaxDownloads != nil && t.CurrentDownloads >= *t.MaxDownloads {
+		if tokenType == "upload" {
+			return http.StatusForbidden, "Upload limit reached", metrics.TokenLimitReached
+		}
+		return http.StatusForbidden, "Download limit reached", metrics.TokenLimitReached
+	}
+
+	// 3. IP Validation
+	if t.AllowedCIDR != "" {
+		_, ipNet, err := net.ParseCIDR(t.AllowedCIDR)
+		if err != nil {
+			// If CIDR in DB is invalid, we probably should block or log error. Blocking for safety.
+			return http.StatusInternalServerError, "Invalid CIDR configuration", metrics.TokenInvalidCIDR
+		}
+		ip := net.ParseIP(clientIP)
+		if ip == nil || !ipNet.Contains(ip) {
+			return http.StatusForbidden, "IP not allowed", metrics.TokenIPDenied
+		}
+	}
+
+	return http.StatusOK, "", metrics.TokenValid
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+var activeTransfers atomic.Int64
+
+// TrackTransfer counts requests that stream artifact content (uploads and downloads)
+// so shutdown can report how many transfers it is draining.
+func TrackTransfer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		activeTransfers.Add(1)
+		defer activeTransfers.Add(-1)
+		c.Next()
+	}
+}
+
+// ActiveTransfers returns the number of uploads/downloads currently in progress
+func ActiveTransfers() int64 {
+	return activeTransfers.Load()
+}
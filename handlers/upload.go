@@ -1,13 +1,27 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 
+	"ArtifactService/config"
+	"ArtifactService/contenttype"
 	"ArtifactService/db"
+	"ArtifactService/encryption"
 	"ArtifactService/logger"
+	"ArtifactService/metrics"
 	"ArtifactService/models"
 	"ArtifactService/storage"
+	"ArtifactService/worker"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -15,26 +29,63 @@ import (
 
 // UploadFile godoc
 // @Summary      Upload a file
-// @Description  Uploads a file and saves metadata to the database
+// @Description  Uploads a file and saves metadata to the database. The multipart body is spooled before it is stored; large files are better sent to PUT /artifact-service/v1/artifacts/{uuid}/content.
 // @Tags         files
 // @Accept       multipart/form-data
 // @Produce      json
 // @Param        file formData file true "File to upload"
+// @Param        project formData string false "Project, selects the content type policy (content_types.projects)"
+// @Param        labels formData string false "Labels as a JSON object, e.g. {\"build\":\"1234\"}"
+// @Param        name formData string false "Name within the project; the upload becomes the next version of project/name"
 // @Success      200  {object}  map[string]string
 // @Failure      400  {object}  map[string]string
+// @Failure      413  {object}  map[string]string
+// @Failure      415  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /artifact-service/v1/artifacts/ [post]
 func UploadFile(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	// Single file
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file is received"})
 		return
 	}
+	if maxSize := int64(config.Get().Upload.MaxSize); maxSize > 0 && file.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds the upload size limit", "max_size": maxSize})
+		return
+	}
+
+	// Optional labels, a JSON object of strings
+	var labels map[string]string
+	if raw := c.PostForm("labels"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "labels must be a JSON object of strings"})
+			return
+		}
+	}
+	labelsColumn, err := encodeLabels(labels)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid labels"})
+		return
+	}
+
+	// Optional name, the upload becomes its next version
+	var artifactName string
+	if name := c.PostForm("name"); name != "" {
+		if artifactName, err = worker.VersionName(c.PostForm("project"), name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Stored and later sent back in Content-Disposition, so strip anything unsafe now
+	filename := sanitizeFilename(file.Filename)
 
 	// Generate UUID
 	uuid := uuid.New().String()
-	
+
 	// Open uploaded file
 	fileReader, err := file.Open()
 	if err != nil {
@@ -44,28 +95,93 @@ func UploadFile(c *gin.Context) {
 	}
 	defer fileReader.Close()
 
-	// Upload file to Ceph
-	if err := storage.UploadFile(uuid, file.Filename, fileReader, file.Header.Get("Content-Type"), file.Size); err != nil {
+	// Detect the real type from the first bytes and enforce the content type policy
+	project := c.PostForm("project")
+	declared := file.Header.Get("Content-Type")
+	head := make([]byte, contenttype.SniffLen)
+	n, err := io.ReadFull(fileReader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		log.Println("Failed to read uploaded file:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to process file"})
+		return
+	}
+	head = head[:n]
+	detected := contenttype.Detect(head)
+	if err := contenttype.CheckUpload(project, nil, declared, detected); err != nil {
+		logger.Record(logger.ActionUpload, "", c.ClientIP(), "", "FAILED", "Rejected: "+err.Error())
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "declared_content_type": declared, "detected_content_type": detected})
+		return
+	}
+
+	// Record the upload before touching storage, so a crash can't leave an orphan object
+	intentID, err := db.BeginIntent(ctx, db.DB, db.IntentUpload, uuid)
+	if err != nil {
+		log.Println("Failed to record upload intent:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Upload file to Ceph, hashing the content on the way
+	stored, err := storeUpload(ctx, uuid, filename, declared, detected, head, fileReader, file.Size)
+	if err != nil {
+		abandonUpload(ctx, intentID, uuid)
 		log.Println("Failed to upload file to Ceph:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to save file"})
+		if errors.Is(err, errDataKey) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to encrypt file"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to save file"})
+		}
 		return
 	}
 
 	// Save metadata to DB
 	metadata := models.Artifact{
 		UUID:        uuid,
-		Filename:    file.Filename,
-		ContentType: file.Header.Get("Content-Type"),
-		Size:        file.Size,
+		Filename:    filename,
+		ContentType:         declared,
+		DetectedContentType: detected,
+		Project:             project,
+		Size:                file.Size,
+		Digest:              stored.digest,
+		ContentEncoding:     stored.content.Encoding,
+		StoredSize:          stored.content.StoredSize,
+		Encryption:          stored.encryption.String,
+		KeyID:               stored.keyID.String,
 	}
 
-	_, err = db.DB.Exec("INSERT INTO Artifacts (uuid, filename, content_type, size) VALUES (?, ?, ?, ?)",
-		metadata.UUID, metadata.Filename, metadata.ContentType, metadata.Size)
+	// Downloads via tokens are held back until the malware scan is done
+	metadata.Status = worker.StoredStatus()
+
+	// The row, its version and the end of the intent commit together
+	var version int64
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO Artifacts (uuid, filename, content_type, detected_content_type, project, size, status, digest, labels, content_encoding, stored_size, encryption, key_id, wrapped_key)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			metadata.UUID, metadata.Filename, metadata.ContentType, metadata.DetectedContentType, metadata.Project, metadata.Size, metadata.Status, metadata.Digest, labelsColumn,
+			sql.NullString{String: metadata.ContentEncoding, Valid: metadata.ContentEncoding != ""}, metadata.StoredSize, stored.encryption, stored.keyID, stored.wrappedKey)
+		if err != nil {
+			return err
+		}
+		if artifactName != "" {
+			if version, err = worker.AddVersion(ctx, tx, artifactName, metadata.UUID); err != nil {
+				return err
+			}
+		}
+		return db.EndIntent(ctx, tx, intentID)
+	})
 	if err != nil {
 		log.Println("Failed to insert metadata:", err)
+		abandonUpload(ctx, intentID, uuid)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if metadata.Status == "SCANNING" {
+		worker.NotifyScan()
+	}
+	if artifactName != "" {
+		applyVersionRetention(ctx, artifactName)
+	}
 
 	// Create download link
 	// Assuming the server is running on the Host header address
@@ -75,15 +191,104 @@ func UploadFile(c *gin.Context) {
 	}
 	downloadURL := scheme + "://" + c.Request.Host + "/artifacts/innerop/" + uuid
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":      "File uploaded successfully",
 		"uuid":         uuid,
-		"download_url": downloadURL,
-	})
+		"status":                metadata.Status,
+		"digest":                metadata.Digest,
+		"detected_content_type": metadata.DetectedContentType,
+		"stored_size":           metadata.StoredSize,
+		"download_url":          downloadURL,
+	}
+	if artifactName != "" {
+		response["name"] = artifactName
+		response["version"] = version
+	}
+	c.JSON(http.StatusOK, response)
 
 	logger.Record(logger.ActionUpload, uuid, c.ClientIP(), "", "SUCCESS", "Standard upload")
 }
 
+// errDataKey is returned by storeUpload when no data key could be created for the upload
+var errDataKey = errors.New("failed to create data key")
+
+// storedUpload describes content written by storeUpload
+type storedUpload struct {
+	content                       *storage.Content
+	size                          int64 // bytes read from the client
+	digest                        string
+	encryption, keyID, wrappedKey sql.NullString
+}
+
+// storeUpload writes an upload to storage, compressed and encrypted as configured,
+// hashing it on the way. head holds the bytes already read from body for sniffing; size
+// is the announced size, -1 if unknown. The digest is of the uncompressed content,
+// whatever storage.compression does with it.
+func storeUpload(ctx context.Context, uuid, filename, contentType, detected string, head []byte, body io.Reader, size int64) (*storedUpload, error) {
+	hasher := sha256.New()
+	counted := &countingReader{r: io.MultiReader(bytes.NewReader(head), body)}
+	result := &storedUpload{content: &storage.Content{UUID: uuid, Encoding: storage.CompressionFor(detected, size)}}
+
+	// Envelope encryption: a new data key per artifact, stored wrapped by the master key
+	if encryption.Enabled() {
+		dataKey, id, wrapped, err := encryption.NewWrappedKey(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errDataKey, err)
+		}
+		result.content.DataKey = dataKey
+		result.encryption = sql.NullString{String: encryption.ModeEnvelope, Valid: true}
+		result.keyID = sql.NullString{String: id, Valid: true}
+		result.wrappedKey = sql.NullString{String: wrapped, Valid: true}
+	}
+
+	reader := io.TeeReader(metrics.CountingReader(counted, metrics.UploadBytes), hasher)
+	if _, err := storage.UploadFile(ctx, result.content, filename, reader, contentType, size); err != nil {
+		return nil, err
+	}
+	result.size = counted.n
+	result.digest = "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	return result, nil
+}
+
+// abandonUpload removes whatever a failed upload left in storage and ends its intent.
+// If storage can't be reached the intent stays for the reconciler.
+func abandonUpload(ctx context.Context, intentID int64, uuid string) {
+	// Clean up even if the client is gone
+	ctx = context.WithoutCancel(ctx)
+	if err := storage.DeleteFile(ctx, uuid); err != nil {
+		log.Printf("Failed to remove failed upload %s, left to the reconciler: %v", uuid, err)
+		return
+	}
+	if err := db.EndIntent(ctx, db.DB, intentID); err != nil {
+		log.Printf("Failed to end upload intent of %s: %v", uuid, err)
+	}
+}
+
+// withTx runs fn in a transaction, committed if fn succeeds
+func withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // CompleteUpload godoc
 // @Summary      Mark upload as complete
 // @Description  Allows client to notify server that upload to S3 is complete. Server verifies file existence and updates status.
@@ -93,30 +298,51 @@ func UploadFile(c *gin.Context) {
 // @Param        uuid path string true "Artifact UUID"
 // @Success      200  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
+// @Failure      415  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /artifact-service/v1/artifacts/{uuid}/complete [post]
 func CompleteUpload(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	uuid := c.Param("uuid")
 
 	// Check current status
 	var status string
-	err := db.DB.QueryRow("SELECT status FROM Artifacts WHERE uuid = ?", uuid).Scan(&status)
+	err := db.DB.QueryRowContext(ctx, "SELECT status FROM Artifacts WHERE uuid = ?", uuid).Scan(&status)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
 		return
 	}
 
+	if status == "DELETED" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	}
+
 	// Idempotency: If already uploaded, return success immediately
-	if status == "UPLOADED" {
+	if status == "UPLOADED" || status == "SCANNING" || status == "QUARANTINED" {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Upload already completed",
-			"status":  "UPLOADED",
+			"status":  status,
+		})
+		return
+	}
+	if status == "REJECTED" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":  "Upload was rejected by the content type policy",
+			"status": status,
 		})
 		return
 	}
 
-	// Verify file existence in S3/Ceph
-	exists, err := storage.CheckFileExists(uuid)
+	// Verify file existence in S3/Ceph; SSE-C objects can only be checked with their key
+	content, err := worker.LoadContent(ctx, uuid)
+	if err != nil {
+		log.Printf("Failed to load %s: %v", uuid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify storage"})
+		return
+	}
+	exists, err := content.Exists(ctx)
 	if err != nil {
 		log.Printf("Failed to check file existence for %s: %v", uuid, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify storage"})
@@ -131,8 +357,22 @@ func CompleteUpload(c *gin.Context) {
 		return
 	}
 
-	// Update status to UPLOADED
-	_, err = db.DB.Exec("UPDATE Artifacts SET status = 'UPLOADED' WHERE uuid = ?", uuid)
+	// Sniff the uploaded bytes, the declared type was only checked when the URL was issued
+	if err := worker.VerifyUpload(ctx, uuid); err != nil {
+		if errors.Is(err, worker.ErrContentRejected) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{
+				"error":  err.Error(),
+				"status": "REJECTED",
+			})
+			return
+		}
+		log.Printf("Failed to verify content of %s: %v", uuid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify content"})
+		return
+	}
+
+	// Update status to UPLOADED, or SCANNING until the malware scan is done
+	status, err = worker.MarkStored(ctx, uuid)
 	if err != nil {
 		log.Printf("Failed to update status for %s: %v", uuid, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -141,7 +381,7 @@ func CompleteUpload(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Upload verification successful",
-		"status":  "UPLOADED",
+		"status":  status,
 	})
 	
 	logger.Record(logger.ActionUpload, uuid, c.ClientIP(), "", "SUCCESS", "Presigned upload completed")
@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/models"
+	"ArtifactService/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListVersions godoc
+// @Summary      List the versions of a name
+// @Description  Returns the version history of the named artifact "project/name", newest first, with its tags and the version "latest" resolves to.
+// @Description  Versions whose artifact is in the trash are listed with status DELETED; purged ones without an artifact.
+// @Tags         versions
+// @Produce      json
+// @Param        project  path      string  true  "Project"
+// @Param        name     path      string  true  "Name within the project"
+// @Success      200      {object}  models.VersionListing
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/names/{project}/{name}/versions [get]
+func ListVersions(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	name, ok := versionName(c)
+	if !ok {
+		return
+	}
+
+	listing := models.VersionListing{Name: name, Tags: map[string]int64{}, Versions: []models.ArtifactVersion{}}
+	var keepVersions sql.NullInt64
+	err := db.DB.QueryRowContext(ctx, "SELECT keep_versions FROM artifact_names WHERE name = ?", name).Scan(&keepVersions)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Name not found"})
+		return
+	}
+	if err != nil {
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if keepVersions.Valid {
+		keep := int(keepVersions.Int64)
+		listing.KeepVersions = &keep
+	}
+
+	if err := loadVersions(ctx, &listing, 0); err != nil {
+		log.Println("Failed to list versions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	latest, _, err := worker.ResolveVersion(ctx, name, worker.LatestRef)
+	switch {
+	case err == nil:
+		listing.Latest = &latest
+	case !errors.Is(err, worker.ErrVersionNotFound):
+		log.Println("Failed to resolve latest version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, listing)
+}
+
+// AddVersion godoc
+// @Summary      Add an artifact as the next version of a name
+// @Description  Records an existing artifact of the same project as the next version of "project/name", creating the name on first use.
+// @Description  Version numbers count up from 1 and are never reused; an artifact can be a version of one name only. Version retention is applied afterwards.
+// @Description  Uploads can do the same in one step with the name form field or query parameter.
+// @Tags         versions
+// @Accept       json
+// @Produce      json
+// @Param        project  path      string                    true  "Project"
+// @Param        name     path      string                    true  "Name within the project"
+// @Param        request  body      models.AddVersionRequest  true  "Artifact to add"
+// @Success      201      {object}  models.ArtifactVersion
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string  "Artifact is deleted, of another project or already a version"
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/names/{project}/{name}/versions [post]
+func AddVersion(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	name, ok := versionName(c)
+	if !ok {
+		return
+	}
+	var req models.AddVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 1. Only live artifacts of the name's project can become a version
+	artifact, err := scanArtifact(db.DB.QueryRowContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", req.ArtifactUUID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		} else {
+			log.Println("Database error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if artifact.Status == "DELETED" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is deleted"})
+		return
+	}
+	if artifact.Project != c.Param("project") {
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact belongs to another project", "project": artifact.Project})
+		return
+	}
+
+	// 2. Number the version
+	var version int64
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		version, err = worker.AddVersion(ctx, tx, name, artifact.UUID)
+		return err
+	})
+	if errors.Is(err, worker.ErrAlreadyVersioned) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Artifact is already a version"})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to add version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	logger.Record(logger.ActionVersion, artifact.UUID, c.ClientIP(), "", "SUCCESS", fmt.Sprintf("Added as version %d of %s", version, name))
+
+	// 3. Older versions may now be over the limit
+	applyVersionRetention(ctx, name)
+
+	c.JSON(http.StatusCreated, models.ArtifactVersion{Name: name, Version: version, CreatedAt: time.Now().UTC(), Artifact: &artifact})
+}
+
+// GetVersion godoc
+// @Summary      Get a version of a name
+// @Description  Resolves a version number, "latest" (the newest uploaded and scanned version) or a tag to the version and its artifact.
+// @Tags         versions
+// @Produce      json
+// @Param        project  path      string  true  "Project"
+// @Param        name     path      string  true  "Name within the project"
+// @Param        version  path      string  true  "Version number, latest or a tag"
+// @Success      200      {object}  models.ArtifactVersion
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/names/{project}/{name}/versions/{version} [get]
+func GetVersion(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	name, ok := versionName(c)
+	if !ok {
+		return
+	}
+	version, _, ok := resolveVersion(c, name, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	listing := models.VersionListing{Name: name, Tags: map[string]int64{}}
+	if err := loadVersions(ctx, &listing, version); err != nil || len(listing.Versions) == 0 {
+		log.Println("Failed to load version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, listing.Versions[0])
+}
+
+// DownloadVersion godoc
+// @Summary      Download a version of a name
+// @Description  Downloads the artifact of a version number, "latest" or a tag, like downloadFile (ranges, conditional requests, content encoding).
+// @Description  The resolved version and artifact are returned in X-Artifact-Version and X-Artifact-UUID.
+// @Tags         versions
+// @Produce      octet-stream
+// @Param        project  path      string  true  "Project"
+// @Param        name     path      string  true  "Name within the project"
+// @Param        version  path      string  true  "Version number, latest or a tag"
+// @Success      200      {file}    file
+// @Success      206      {file}    file
+// @Success      304      "Not modified"
+// @Failure      400      {object}  map[string]string
+// @Failure      403      {object}  map[string]string  "Artifact is QUARANTINED"
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string  "Artifact still SCANNING"
+// @Failure      410      {object}  map[string]string  "Artifact is in the trash"
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/names/{project}/{name}/versions/{version}/download [get]
+func DownloadVersion(c *gin.Context) {
+	name, ok := versionName(c)
+	if !ok {
+		return
+	}
+	version, artifactUUID, ok := resolveVersion(c, name, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	c.Header("X-Artifact-Version", strconv.FormatInt(version, 10))
+	c.Header("X-Artifact-UUID", artifactUUID)
+	c.Params = append(c.Params, gin.Param{Key: "uuid", Value: artifactUUID})
+	DownloadFile(c)
+}
+
+// SetVersionTag godoc
+// @Summary      Point a tag at a version
+// @Description  Creates or moves a tag, e.g. "stable" or "release-1.2", so the version can be fetched by it. Tagged versions are exempt from version retention.
+// @Tags         versions
+// @Accept       json
+// @Produce      json
+// @Param        project  path      string             true  "Project"
+// @Param        name     path      string             true  "Name within the project"
+// @Param        tag      path      string             true  "Tag, starting with a letter; latest is reserved"
+// @Param        request  body      models.TagRequest  true  "Version to tag"
+// @Success      200      {object}  models.ArtifactVersion
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string  "Version is deleted"
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/names/{project}/{name}/tags/{tag} [put]
+func SetVersionTag(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	name, ok := versionName(c)
+	if !ok {
+		return
+	}
+	tag := c.Param("tag")
+	if err := worker.ValidTag(tag); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req models.TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A tag has to point at something that can be downloaded
+	var status sql.NullString
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT a.status
+		FROM artifact_versions v
+		LEFT JOIN Artifacts a ON a.uuid = v.artifact_uuid
+		WHERE v.name = ? AND v.version = ?`, name, req.Version).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	if err != nil {
+		log.Println("Database error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if status.String == "DELETED" || !status.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Version is deleted"})
+		return
+	}
+
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO artifact_tags (name, tag, version, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(name, tag) DO UPDATE SET version = excluded.version, updated_at = excluded.updated_at`,
+		name, tag, req.Version, time.Now().UTC())
+	if err != nil {
+		log.Println("Failed to set tag:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	logger.Record(logger.ActionVersion, "", c.ClientIP(), "", "SUCCESS", fmt.Sprintf("Tag %s of %s set to version %d", tag, name, req.Version))
+
+	listing := models.VersionListing{Name: name, Tags: map[string]int64{}}
+	if err := loadVersions(ctx, &listing, req.Version); err != nil || len(listing.Versions) == 0 {
+		log.Println("Failed to load version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, listing.Versions[0])
+}
+
+// DeleteVersionTag godoc
+// @Summary      Remove a tag
+// @Description  Removes a tag; the version it pointed at is subject to version retention again.
+// @Tags         versions
+// @Produce      json
+// @Param        project  path      string  true  "Project"
+// @Param        name     path      string  true  "Name within the project"
+// @Param        tag      path      string  true  "Tag"
+// @Success      200      {object}  map[string]string
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/names/{project}/{name}/tags/{tag} [delete]
+func DeleteVersionTag(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	name, ok := versionName(c)
+	if !ok {
+		return
+	}
+	tag := c.Param("tag")
+
+	res, err := db.DB.ExecContext(ctx, "DELETE FROM artifact_tags WHERE name = ? AND tag = ?", name, tag)
+	if err != nil {
+		log.Println("Failed to delete tag:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+	logger.Record(logger.ActionVersion, "", c.ClientIP(), "", "SUCCESS", fmt.Sprintf("Tag %s of %s removed", tag, name))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag removed"})
+}
+
+// SetVersionRetention godoc
+// @Summary      Set how many versions a name keeps
+// @Description  Overrides versions.keep for one name: the number of newest downloadable versions kept, 0 to keep all, null for the default.
+// @Description  Applied right away; older untagged versions are moved to the trash like a delete, locked ones stay. Returns the UUIDs of the trashed artifacts.
+// @Tags         versions
+// @Accept       json
+// @Produce      json
+// @Param        project  path      string                          true  "Project"
+// @Param        name     path      string                          true  "Name within the project"
+// @Param        request  body      models.VersionRetentionRequest  true  "Versions to keep"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /artifact-service/v1/names/{project}/{name}/retention [put]
+func SetVersionRetention(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	name, ok := versionName(c)
+	if !ok {
+		return
+	}
+	var req models.VersionRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.KeepVersions != nil && *req.KeepVersions < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keep_versions must not be negative"})
+		return
+	}
+
+	res, err := db.DB.ExecContext(ctx, "UPDATE artifact_names SET keep_versions = ? WHERE name = ?", req.KeepVersions, name)
+	if err != nil {
+		log.Println("Failed to set version retention:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Name not found"})
+		return
+	}
+
+	trashed, err := worker.ApplyVersionRetention(ctx, name)
+	if err != nil {
+		log.Printf("Failed to apply version retention of %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply version retention", "trashed": trashed})
+		return
+	}
+	if trashed == nil {
+		trashed = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "keep_versions": req.KeepVersions, "trashed": trashed})
+}
+
+// applyVersionRetention applies the version retention of name after a version was
+// added; a failure doesn't fail the request, the next version retries
+func applyVersionRetention(ctx context.Context, name string) {
+	if _, err := worker.ApplyVersionRetention(context.WithoutCancel(ctx), name); err != nil {
+		log.Printf("Failed to apply version retention of %s: %v", name, err)
+	}
+}
+
+// versionName returns the name "project/name" of the request path, answering 400 if
+// either part is invalid
+func versionName(c *gin.Context) (string, bool) {
+	name, err := worker.VersionName(c.Param("project"), c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return name, true
+}
+
+// resolveVersion resolves ref of name, answering 404 if it doesn't point at a version
+func resolveVersion(c *gin.Context, name, ref string) (int64, string, bool) {
+	version, artifactUUID, err := worker.ResolveVersion(c.Request.Context(), name, ref)
+	if errors.Is(err, worker.ErrVersionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return 0, "", false
+	}
+	if err != nil {
+		log.Println("Failed to resolve version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, "", false
+	}
+	return version, artifactUUID, true
+}
+
+// loadVersions fills the tags and versions of listing, newest first, with their
+// artifacts. A version other than 0 loads only that version.
+func loadVersions(ctx context.Context, listing *models.VersionListing, version int64) error {
+	versionTags := map[int64][]string{}
+	rows, err := db.DB.QueryContext(ctx, "SELECT tag, version FROM artifact_tags WHERE name = ? ORDER BY tag", listing.Name)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var tag string
+		var version int64
+		if err := rows.Scan(&tag, &version); err != nil {
+			rows.Close()
+			return err
+		}
+		listing.Tags[tag] = version
+		versionTags[version] = append(versionTags[version], tag)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query := "SELECT version, artifact_uuid, created_at FROM artifact_versions WHERE name = ?"
+	args := []any{listing.Name}
+	if version != 0 {
+		query += " AND version = ?"
+		args = append(args, version)
+	}
+	rows, err = db.DB.QueryContext(ctx, query+" ORDER BY version DESC", args...)
+	if err != nil {
+		return err
+	}
+	var uuids []string
+	for rows.Next() {
+		var v models.ArtifactVersion
+		var artifactUUID string
+		if err := rows.Scan(&v.Version, &artifactUUID, &v.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		v.Name = listing.Name
+		v.Tags = versionTags[v.Version]
+		listing.Versions = append(listing.Versions, v)
+		uuids = append(uuids, artifactUUID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Purged artifacts are gone from Artifacts, their versions stay without one
+	for i, artifactUUID := range uuids {
+		artifact, err := scanArtifact(db.DB.QueryRowContext(ctx, "SELECT "+artifactColumns+" FROM Artifacts WHERE uuid = ?", artifactUUID))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		listing.Versions[i].Artifact = &artifact
+	}
+	return nil
+}
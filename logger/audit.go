@@ -3,8 +3,10 @@ package logger
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -16,6 +18,10 @@ const (
 	ActionDownload LogType = "DOWNLOAD"
 	ActionDelete   LogType = "DELETE"
 	ActionError    LogType = "ERROR"
+	ActionScan     LogType = "SCAN"
+	ActionLock     LogType = "LOCK"
+	ActionBulk     LogType = "BULK"
+	ActionVersion  LogType = "VERSION"
 )
 
 // AuditLog represents the structure of an audit log entry
@@ -75,31 +81,77 @@ func (l *ExternalLogger) Log(entry AuditLog) error {
 // Global Logger instance
 var Instance LoggerInterface
 
+// mu guards Instance so it can be swapped on config reload while requests are logging
+var mu sync.RWMutex
+
 // Switch for log mode
 const (
 	ModeInternal = "INTERNAL"
 	ModeExternal = "EXTERNAL"
+	ModeSyslog   = "SYSLOG" // RFC 5424 over udp/tcp/unix, target taken from externalURL
+	ModeCEF      = "CEF"    // ArcSight CEF carried over syslog, target taken from externalURL
 )
 
-// InitLogger initializes the global logger based on mode
+// InitLogger initializes the global logger based on mode. Calling it again replaces
+// the logger atomically and closes the previous one once in-flight writes finished.
 func InitLogger(mode string, externalURL string) {
+	next := newLogger(mode, externalURL)
+
+	mu.Lock()
+	previous := Instance
+	Instance = next
+	mu.Unlock()
+
+	if closer, ok := previous.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Failed to close previous audit logger: %v", err)
+		}
+	}
+}
+
+func newLogger(mode string, externalURL string) LoggerInterface {
 	switch mode {
 	case ModeExternal:
-		Instance = NewExternalLogger(externalURL)
 		log.Println("Logger initialized in EXTERNAL mode")
+		return NewExternalLogger(externalURL)
+	case ModeSyslog, ModeCEF:
+		format := SyslogFormatRFC5424
+		if mode == ModeCEF {
+			format = SyslogFormatCEF
+		}
+		syslogLogger, err := NewSyslogLogger(externalURL, format)
+		if err != nil {
+			log.Printf("Failed to initialize %s logger, falling back to INTERNAL: %v", mode, err)
+			return NewInternalLogger()
+		}
+		log.Printf("Logger initialized in %s mode (target: %s)", mode, externalURL)
+		return syslogLogger
 	default:
-		Instance = NewInternalLogger()
 		log.Println("Logger initialized in INTERNAL mode")
+		return NewInternalLogger()
 	}
 }
 
+// Close flushes and releases the global logger if it holds resources (e.g. a syslog connection)
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if closer, ok := Instance.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // Helper function to record a log easily
 func Record(action LogType, uuid, ip, session, status, details string) {
-	if Instance == nil {
+	mu.RLock()
+	initialized := Instance != nil
+	mu.RUnlock()
+	if !initialized {
 		// Fallback if not initialized
 		InitLogger(ModeInternal, "")
 	}
-	
+
 	entry := AuditLog{
 		Timestamp:    time.Now(),
 		Action:       action,
@@ -109,7 +161,10 @@ func Record(action LogType, uuid, ip, session, status, details string) {
 		Status:       status,
 		Details:      details,
 	}
-	
+
+	// Hold the read lock while writing so a reload can't close the logger underneath us
+	mu.RLock()
+	defer mu.RUnlock()
 	if err := Instance.Log(entry); err != nil {
 		log.Printf("Failed to write audit log: %v", err)
 	}
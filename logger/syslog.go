@@ -0,0 +1,330 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message formats supported by SyslogLogger
+const (
+	SyslogFormatRFC5424 = "RFC5424"
+	SyslogFormatCEF     = "CEF"
+)
+
+const (
+	// facilityLogAudit is the RFC 5424 "log audit" facility (13)
+	facilityLogAudit = 13
+
+	// sdID is the structured data element carrying the audit fields.
+	// 32473 is the private enterprise number reserved for documentation use.
+	sdID = "audit@32473"
+
+	cefVendor  = "ArtifactService"
+	cefProduct = "ArtifactService"
+	cefVersion = "1.0"
+)
+
+// SyslogLogger ships audit entries to a syslog receiver (SIEM collector, rsyslog, syslog-ng)
+// over UDP, TCP or a unix socket, formatted either as RFC 5424 or as ArcSight CEF.
+type SyslogLogger struct {
+	network  string
+	address  string
+	format   string
+	hostname string
+	appName  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogLogger creates a logger for the given target URL, e.g.
+// udp://siem.example.com:514, tcp://siem.example.com:601 or unix:///dev/log.
+// The connection is established lazily on the first entry.
+func NewSyslogLogger(target, format string) (*SyslogLogger, error) {
+	network, address, err := parseSyslogTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case SyslogFormatRFC5424, SyslogFormatCEF:
+	default:
+		return nil, fmt.Errorf("unsupported syslog format %q", format)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &SyslogLogger{
+		network:  network,
+		address:  address,
+		format:   format,
+		hostname: hostname,
+		appName:  "artifact-service",
+	}, nil
+}
+
+func parseSyslogTarget(target string) (string, string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog target %q: %w", target, err)
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("invalid syslog target %q: missing host", target)
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "514")
+		}
+		return u.Scheme, host, nil
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid syslog target %q: missing socket path", target)
+		}
+		return u.Scheme, u.Path, nil
+	default:
+		return "", "", fmt.Errorf("invalid syslog target %q: scheme must be udp, tcp, unix or unixgram", target)
+	}
+}
+
+func (l *SyslogLogger) Log(entry AuditLog) error {
+	var msg string
+	if l.format == SyslogFormatCEF {
+		msg = formatRFC5424Header(entry, l.hostname, l.appName) + " - " + FormatCEF(entry, l.hostname)
+	} else {
+		msg = FormatRFC5424(entry, l.hostname, l.appName)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Retry once with a fresh connection so a restarted collector doesn't lose entries
+	err := l.write(msg)
+	if err != nil {
+		l.closeConn()
+		err = l.write(msg)
+	}
+	return err
+}
+
+func (l *SyslogLogger) write(msg string) error {
+	if l.conn == nil {
+		conn, err := l.dial()
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog %s://%s: %w", l.network, l.address, err)
+		}
+		l.conn = conn
+	}
+
+	var data string
+	switch l.network {
+	case "tcp":
+		// TCP uses octet-counting framing (RFC 6587 3.4.1)
+		data = strconv.Itoa(len(msg)) + " " + msg
+	case "unix":
+		// Local daemons read stream sockets line by line, so line breaks inside the
+		// message would split it
+		data = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\n"
+	default:
+		data = msg
+	}
+
+	l.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := l.conn.Write([]byte(data))
+	return err
+}
+
+func (l *SyslogLogger) dial() (net.Conn, error) {
+	if l.network == "unix" {
+		// Local daemons usually listen on a datagram socket, fall back to stream
+		if conn, err := net.DialTimeout("unixgram", l.address, 5*time.Second); err == nil {
+			l.network = "unixgram"
+			return conn, nil
+		}
+	}
+	return net.DialTimeout(l.network, l.address, 5*time.Second)
+}
+
+func (l *SyslogLogger) closeConn() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// Close releases the underlying connection
+func (l *SyslogLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closeConn()
+	return nil
+}
+
+// FormatRFC5424 renders an audit entry as an RFC 5424 syslog message. The audit
+// fields are carried as structured data so collectors can index them without parsing MSG.
+func FormatRFC5424(entry AuditLog, hostname, appName string) string {
+	var sd strings.Builder
+	sd.WriteString("[" + sdID)
+	writeSDParam(&sd, "action", string(entry.Action))
+	writeSDParam(&sd, "artifactUUID", entry.ArtifactUUID)
+	writeSDParam(&sd, "clientIP", entry.ClientIP)
+	writeSDParam(&sd, "userSession", entry.UserSession)
+	writeSDParam(&sd, "status", entry.Status)
+	sd.WriteString("]")
+
+	msg := formatRFC5424Header(entry, hostname, appName) + " " + sd.String()
+	if entry.Details != "" {
+		msg += " " + entry.Details
+	}
+	return msg
+}
+
+// formatRFC5424Header renders "<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID"
+func formatRFC5424Header(entry AuditLog, hostname, appName string) string {
+	pri := facilityLogAudit*8 + syslogSeverity(entry)
+	ts := entry.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s",
+		pri,
+		ts.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(hostname, 255),
+		headerField(appName, 48),
+		os.Getpid(),
+		headerField(string(entry.Action), 32),
+	)
+}
+
+// syslogSeverity maps an entry to an RFC 5424 severity: error (3), warning (4) or notice (5)
+func syslogSeverity(entry AuditLog) int {
+	switch {
+	case entry.Action == ActionError:
+		return 3
+	case entry.Status != "" && entry.Status != "SUCCESS":
+		return 4
+	default:
+		return 5
+	}
+}
+
+// headerField enforces the PRINTUSASCII charset and length limits of RFC 5424 header fields
+func headerField(value string, maxLen int) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+	}
+	out := b.String()
+	if out == "" {
+		return "-"
+	}
+	if len(out) > maxLen {
+		out = out[:maxLen]
+	}
+	return out
+}
+
+func writeSDParam(sd *strings.Builder, name, value string) {
+	if value == "" {
+		return
+	}
+	// PARAM-VALUE must escape '"', '\' and ']'
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+	sd.WriteString(" " + name + `="` + escaped + `"`)
+}
+
+// FormatCEF renders an audit entry as an ArcSight Common Event Format record
+func FormatCEF(entry AuditLog, hostname string) string {
+	severity := 3
+	switch {
+	case entry.Action == ActionError:
+		severity = 8
+	case entry.Status != "" && entry.Status != "SUCCESS":
+		severity = 6
+	}
+
+	ts := entry.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	header := strings.Join([]string{
+		"CEF:0",
+		cefHeaderEscape(cefVendor),
+		cefHeaderEscape(cefProduct),
+		cefHeaderEscape(cefVersion),
+		cefHeaderEscape(string(entry.Action)),
+		cefHeaderEscape(cefEventName(entry.Action)),
+		strconv.Itoa(severity),
+	}, "|")
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(ts.UnixMilli(), 10),
+		"act=" + cefExtEscape(string(entry.Action)),
+	}
+	if hostname != "" && hostname != "-" {
+		ext = append(ext, "dvchost="+cefExtEscape(hostname))
+	}
+	if ip := net.ParseIP(entry.ClientIP); ip != nil {
+		ext = append(ext, "src="+ip.String())
+	}
+	if entry.UserSession != "" {
+		ext = append(ext, "suser="+cefExtEscape(entry.UserSession))
+	}
+	if entry.ArtifactUUID != "" {
+		ext = append(ext, "cs1Label=artifactUUID", "cs1="+cefExtEscape(entry.ArtifactUUID))
+	}
+	if entry.Status != "" {
+		ext = append(ext, "outcome="+cefExtEscape(entry.Status))
+	}
+	if entry.Details != "" {
+		ext = append(ext, "msg="+cefExtEscape(entry.Details))
+	}
+
+	return header + "|" + strings.Join(ext, " ")
+}
+
+func cefEventName(action LogType) string {
+	switch action {
+	case ActionUpload:
+		return "Artifact uploaded"
+	case ActionDownload:
+		return "Artifact downloaded"
+	case ActionDelete:
+		return "Artifact deleted"
+	case ActionLock:
+		return "Artifact lock changed"
+	case ActionBulk:
+		return "Bulk job created"
+	case ActionVersion:
+		return "Artifact version changed"
+	case ActionError:
+		return "Artifact service error"
+	default:
+		return "Artifact " + strings.ToLower(string(action))
+	}
+}
+
+// cefHeaderEscape escapes '\' and '|' in header fields
+func cefHeaderEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace(value)
+}
+
+// cefExtEscape escapes '\', '=' and line breaks in extension values
+func cefExtEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
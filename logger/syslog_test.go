@@ -0,0 +1,215 @@
+package logger
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenUDP starts a local syslog receiver and returns it with its udp:// target
+func listenUDP(t *testing.T) (net.PacketConn, string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, "udp://" + conn.LocalAddr().String()
+}
+
+// receive returns the next datagram of the receiver
+func receive(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(buf[:n])
+}
+
+// send logs entry through a SyslogLogger of format and returns the datagram received
+func send(t *testing.T, format string, entry AuditLog) string {
+	t.Helper()
+	conn, target := listenUDP(t)
+	l, err := NewSyslogLogger(target, format)
+	if err != nil {
+		t.Fatalf("NewSyslogLogger: %v", err)
+	}
+	defer l.Close()
+	if err := l.Log(entry); err != nil {
+		t.Fatalf("Log: %v", err)
+	}
+	return receive(t, conn)
+}
+
+var testTime = time.Date(2026, 3, 4, 5, 6, 7, 890000000, time.UTC)
+
+func TestSyslogRFC5424OverUDP(t *testing.T) {
+	msg := send(t, SyslogFormatRFC5424, AuditLog{
+		Timestamp:    testTime,
+		Action:       ActionUpload,
+		ArtifactUUID: "3f2c",
+		ClientIP:     "10.0.0.7",
+		UserSession:  `admin:"x"]\y`,
+		Status:       "SUCCESS",
+		Details:      "Streaming upload",
+	})
+
+	hostname, _ := os.Hostname()
+	// <PRI>: facility log audit (13) * 8 + notice (5)
+	header := "<109>1 2026-03-04T05:06:07.890000Z " + headerField(hostname, 255) + " artifact-service " + strconv.Itoa(os.Getpid()) + " UPLOAD "
+	if !strings.HasPrefix(msg, header) {
+		t.Fatalf("header mismatch\n got: %q\nwant prefix: %q", msg, header)
+	}
+	sd := `[audit@32473 action="UPLOAD" artifactUUID="3f2c" clientIP="10.0.0.7" userSession="admin:\"x\"\]\\y" status="SUCCESS"]`
+	if rest := strings.TrimPrefix(msg, header); rest != sd+" Streaming upload" {
+		t.Errorf("structured data mismatch\n got: %q\nwant: %q", rest, sd+" Streaming upload")
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry AuditLog
+		pri   string
+	}{
+		{"success is notice", AuditLog{Action: ActionDownload, Status: "SUCCESS"}, "<109>"},
+		{"failure is warning", AuditLog{Action: ActionDelete, Status: "FAILED"}, "<108>"},
+		{"error is error", AuditLog{Action: ActionError, Status: "FAILED"}, "<107>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.entry.Timestamp = testTime
+			if msg := FormatRFC5424(tt.entry, "host", "app"); !strings.HasPrefix(msg, tt.pri+"1 ") {
+				t.Errorf("got %q, want PRI %s", msg, tt.pri)
+			}
+		})
+	}
+}
+
+func TestSyslogCEFOverUDP(t *testing.T) {
+	msg := send(t, SyslogFormatCEF, AuditLog{
+		Timestamp:    testTime,
+		Action:       ActionDelete,
+		ArtifactUUID: "3f2c",
+		ClientIP:     "10.0.0.7",
+		UserSession:  "admin:1bd8fd47",
+		Status:       "FAILED",
+		Details:      `Refused: a=b|c\d`,
+	})
+
+	// The CEF record follows the RFC 5424 header and an empty structured data field
+	_, record, ok := strings.Cut(msg, " - CEF:")
+	if !strings.HasPrefix(msg, "<108>1 ") || !ok {
+		t.Fatalf("not a CEF record in a syslog header: %q", msg)
+	}
+	record = "CEF:" + record
+
+	header := "CEF:0|ArtifactService|ArtifactService|1.0|DELETE|Artifact deleted|6|"
+	if !strings.HasPrefix(record, header) {
+		t.Fatalf("CEF header mismatch\n got: %q\nwant prefix: %q", record, header)
+	}
+	ext := strings.TrimPrefix(record, header)
+	for _, field := range []string{
+		"rt=" + strconv.FormatInt(testTime.UnixMilli(), 10),
+		"act=DELETE",
+		"src=10.0.0.7",
+		"suser=admin:1bd8fd47",
+		"cs1Label=artifactUUID cs1=3f2c",
+		"outcome=FAILED",
+		// '=' and '\' are escaped in extension values, '|' is not
+		`msg=Refused: a\=b|c\\d`,
+	} {
+		if !strings.Contains(ext, field) {
+			t.Errorf("extension %q lacks %q", ext, field)
+		}
+	}
+}
+
+func TestCEFEscaping(t *testing.T) {
+	tests := []struct {
+		name, in, header, ext string
+	}{
+		{"pipe", `a|b`, `a\|b`, `a|b`},
+		{"equals", `a=b`, `a=b`, `a\=b`},
+		{"backslash", `a\b`, `a\\b`, `a\\b`},
+		{"newline", "a\nb", "a b", `a\nb`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cefHeaderEscape(tt.in); got != tt.header {
+				t.Errorf("cefHeaderEscape(%q) = %q, want %q", tt.in, got, tt.header)
+			}
+			if got := cefExtEscape(tt.in); got != tt.ext {
+				t.Errorf("cefExtEscape(%q) = %q, want %q", tt.in, got, tt.ext)
+			}
+		})
+	}
+
+	// A pipe in the signature ID must not shift the header fields
+	record := FormatCEF(AuditLog{Timestamp: testTime, Action: LogType("UP|LOAD")}, "-")
+	fields := strings.SplitN(strings.ReplaceAll(record, `\|`, ""), "|", 8)
+	if len(fields) != 8 || fields[4] != "UPLOAD" || !strings.HasPrefix(fields[7], "rt=") {
+		t.Errorf("header fields shifted in %q", record)
+	}
+}
+
+func TestSyslogStreamFraming(t *testing.T) {
+	entry := AuditLog{Timestamp: testTime, Action: ActionUpload, Status: "SUCCESS", Details: "line one\nline two"}
+	msg := FormatRFC5424(entry, "-", "artifact-service")
+	tests := []struct {
+		network string
+		want    string
+	}{
+		// TCP counts octets, so the line break is kept
+		{"tcp", strconv.Itoa(len(msg)) + " " + msg},
+		// Unix stream sockets are read line by line
+		{"unix", strings.ReplaceAll(msg, "\n", " ") + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			address := "127.0.0.1:0"
+			if tt.network == "unix" {
+				address = t.TempDir() + "/log.sock"
+			}
+			ln, err := net.Listen(tt.network, address)
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			defer ln.Close()
+
+			l, err := NewSyslogLogger(tt.network+"://"+ln.Addr().String(), SyslogFormatRFC5424)
+			if err != nil {
+				t.Fatalf("NewSyslogLogger: %v", err)
+			}
+			l.hostname = "-"
+			// Two entries, to see where the first one ends
+			for range 2 {
+				if err := l.Log(entry); err != nil {
+					t.Fatalf("Log: %v", err)
+				}
+			}
+			l.Close()
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatalf("accept: %v", err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.want+tt.want {
+				t.Errorf("got %q, want %q twice", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,20 +1,34 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"syscall"
 	"time"
 
+	"ArtifactService/config"
 	"ArtifactService/db"
 	_ "ArtifactService/docs"
+	"ArtifactService/encryption"
 	"ArtifactService/handlers"
 	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/middleware"
+	"ArtifactService/scanner"
 	"ArtifactService/storage"
+	"ArtifactService/tracing"
 	"ArtifactService/worker"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
@@ -24,70 +38,340 @@ func main() {
 	// @host            localhost:8080
 	// @BasePath        /
 
+	// `server config print [flags]` shows the effective configuration and exits
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		cfg, err := config.Load(os.Args[3:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := config.Print(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// `server keys generate [id]` prints a new master key line for encryption.key_file,
+	// `server keys rotate [flags]` rewraps data keys with the current master key
+	if len(os.Args) > 2 && os.Args[1] == "keys" {
+		runKeys(os.Args[2], os.Args[3:])
+		return
+	}
+
+	// `server reconcile [-fix] [flags]` compares the bucket with the database once
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		runReconcile(os.Args[2:])
+		return
+	}
+
+	// Load configuration: flags > environment > config file > defaults
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Set(cfg)
+
+	// Cancelled on SIGINT/SIGTERM, which starts the graceful shutdown below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Initialize Tracing first so DB and storage calls are instrumented
+	// otlp reads the collector from OTEL_EXPORTER_OTLP_ENDPOINT
+	shutdownTracing, err := tracing.InitTracing(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		log.Fatal("Failed to initialize tracing: ", err)
+	}
+
 	// Initialize Database
-	db.InitDB()
-	
+	db.InitDB(cfg.Database.Path)
+
 	// Initialize Audit Logger
-	// Default to INTERNAL, can be switched via env var LOG_MODE
-	logMode := os.Getenv("LOG_MODE")
-	logURL := os.Getenv("LOG_SERVICE_URL")
-	if logMode == "" {
-		logMode = logger.ModeInternal
-	}
-	logger.InitLogger(logMode, logURL)
+	logger.InitLogger(cfg.Logging.Mode, cfg.Logging.ServiceURL)
 
 	// Initialize Storage (Ceph/S3)
-	if err := storage.InitStorage(); err != nil {
+	if err := storage.InitStorage(cfg.Storage); err != nil {
 		log.Fatal("Failed to initialize storage: ", err)
 	}
 
-	// Start Background Workers
-	// Check for pending uploads every 60 seconds
-	worker.StartStatusChecker(60 * time.Second)
-
-	r := gin.Default()
+	// Initialize envelope encryption (encryption.provider none stores artifacts as uploaded)
+	if err := encryption.Init(cfg.Encryption); err != nil {
+		log.Fatal("Failed to initialize encryption: ", err)
+	}
 
-	// CORS middleware
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+	// Initialize the malware scanner (scanner.mode none skips the SCANNING stage)
+	scanner.Init(cfg.Scanner)
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	// Start Background Workers
+	// Check for pending uploads periodically, stopped when ctx is cancelled
+	worker.StartStatusChecker(ctx, cfg.Worker.StatusCheckInterval.Std())
+	// Scan SCANNING artifacts as they arrive, stopped when ctx is cancelled
+	worker.StartScanWorker(ctx)
+	// Finish interrupted uploads/deletes and look for orphans, stopped when ctx is cancelled
+	worker.StartReconciler(ctx)
+	worker.StartPurgeWorker(ctx)
+	// Run bulk jobs one at a time, resuming interrupted ones, stopped when ctx is cancelled
+	worker.StartBulkWorker(ctx)
 
-		c.Next()
+	// Expose artifact count and used bytes as gauges on /metrics
+	metrics.RegisterUsage(func() (int64, int64, error) {
+		return db.GetUsage(context.Background())
 	})
 
+	r := gin.Default()
+	// Extract W3C trace context from incoming requests and start a server span per route
+	r.Use(otelgin.Middleware(tracing.ServiceName))
+	r.Use(metrics.Middleware())
+
+	// CORS policy from the cors.* settings, swapped on config reload
+	middleware.SetCORSPolicy(cfg.CORS)
+	r.Use(middleware.CORS())
+
 	// Routes
-	r.POST("/artifact-service/v1/artifacts/", handlers.UploadFile)
+	// transfer marks routes that stream artifact content so shutdown can report what it drains
+	transfer := handlers.TrackTransfer()
+	r.POST("/artifact-service/v1/artifacts/", transfer, handlers.UploadFile)
 	r.POST("/artifact-service/v1/artifacts/:uuid/complete", handlers.CompleteUpload)
+	r.PUT("/artifact-service/v1/artifacts/:uuid/content", transfer, handlers.StreamUpload)
 	r.GET("/artifact-service/v1/artifacts/", handlers.ListArtifacts)
-	r.GET("/artifact-service/v1/artifacts/:uuid/action/downloadFile", handlers.DownloadFile)
+	r.GET("/artifact-service/v1/artifacts/:uuid", handlers.GetArtifact)
+	r.GET("/artifact-service/v1/artifacts/:uuid/action/downloadFile", transfer, handlers.DownloadFile)
+	r.HEAD("/artifact-service/v1/artifacts/:uuid/action/downloadFile", handlers.HeadDownloadFile)
+	r.GET("/artifact-service/v1/artifacts/:uuid/entries", handlers.ListArchiveEntries)
+	r.GET("/artifact-service/v1/artifacts/:uuid/action/extract", transfer, handlers.ExtractArchiveEntry)
 	r.DELETE("/artifact-service/v1/artifacts/:uuid", handlers.DeleteArtifact)
+	r.POST("/artifact-service/v1/artifacts/:uuid/restore", handlers.RestoreArtifact)
+	r.GET("/artifact-service/v1/trash", handlers.ListTrash)
+	r.PUT("/artifact-service/v1/artifacts/:uuid/legal-hold", handlers.SetLegalHold)
+	r.PUT("/artifact-service/v1/artifacts/:uuid/retention", handlers.SetRetention)
+	r.POST("/artifact-service/v1/bundles", transfer, handlers.DownloadBundle)
+	r.GET("/artifact-service/v1/names/:project/:name/versions", handlers.ListVersions)
+	r.POST("/artifact-service/v1/names/:project/:name/versions", handlers.AddVersion)
+	r.GET("/artifact-service/v1/names/:project/:name/versions/:version", handlers.GetVersion)
+	r.GET("/artifact-service/v1/names/:project/:name/versions/:version/download", transfer, handlers.DownloadVersion)
+	r.PUT("/artifact-service/v1/names/:project/:name/tags/:tag", handlers.SetVersionTag)
+	r.DELETE("/artifact-service/v1/names/:project/:name/tags/:tag", handlers.DeleteVersionTag)
+	r.PUT("/artifact-service/v1/names/:project/:name/retention", handlers.SetVersionRetention)
+	r.POST("/artifact-service/v1/bulk", handlers.CreateBulkJob)
+	r.GET("/artifact-service/v1/bulk/:id", handlers.GetBulkJob)
+	r.GET("/artifact-service/v1/bulk/:id/results", handlers.GetBulkResults)
 	r.GET("/artifact-service/v1/storage/usage", handlers.GetStorageUsage)
 	
 	// Token generation routes
 	r.POST("/genDownloadPresignedURL", handlers.GenDownloadPresignedURL)
 	r.POST("/genUploadPresignedURL", handlers.GenUploadPresignedURL)
+	r.POST("/genBundlePresignedURL", handlers.GenBundlePresignedURL)
 	
 	// Token-based file access routes
 	r.GET("/artifacts/:token", handlers.DownloadFileWithToken)
 	r.POST("/artifacts/upload/:token", handlers.UploadFileWithToken)
+	r.GET("/artifacts/bundle/:token", transfer, handlers.DownloadBundleWithToken)
+
+	// Generic repository layout for build tools
+	r.PUT("/repo/:project/*path", transfer, handlers.PutRepoFile)
+	r.GET("/repo/:project/*path", transfer, handlers.GetRepoFile)
+	r.HEAD("/repo/:project/*path", handlers.HeadRepoFile)
+	r.DELETE("/repo/:project/*path", handlers.DeleteRepoFile)
 	
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Health probes
+	r.GET("/healthz", handlers.Healthz)
+	r.GET("/readyz", handlers.Readyz)
+
+	// Reload the config file on SIGHUP or when it changes
+	config.Watch(ctx, os.Args[1:], applyConfig)
 
 	// Run server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	port := strconv.Itoa(cfg.Server.Port)
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
 	}
-	
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server: ", err)
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server: ", err)
+		}
+	}()
+
+	// S3-compatible API on its own port, so S3 clients see it at the root
+	var s3Srv *http.Server
+	if cfg.S3Gateway.Enabled {
+		s3 := gin.Default()
+		s3.Use(otelgin.Middleware(tracing.ServiceName))
+		s3.Use(metrics.Middleware())
+		s3.Use(middleware.S3Auth())
+		s3.Any("/*path", transfer, handlers.S3Gateway)
+
+		// Remove multipart uploads left unfinished, stopped when ctx is cancelled
+		worker.StartMultipartCleaner(ctx)
+
+		s3Port := strconv.Itoa(cfg.S3Gateway.Port)
+		s3Srv = &http.Server{
+			Addr:    ":" + s3Port,
+			Handler: s3,
+		}
+		go func() {
+			log.Printf("S3 gateway starting on port %s", s3Port)
+			if err := s3Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Failed to start S3 gateway: ", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	stop() // A second signal terminates immediately
+
+	// 1. Stop accepting new requests and drain in-flight ones (uploads/downloads included)
+	// server.shutdown_timeout bounds how long in-flight transfers may drain after SIGTERM
+	shutdownTimeout := config.Get().Server.ShutdownTimeout.Std()
+	log.Printf("Shutting down: draining %d active transfers (timeout %v)", handlers.ActiveTransfers(), shutdownTimeout)
+	handlers.SetShuttingDown()
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelDrain()
+	// The S3 gateway drains alongside, within the same timeout
+	s3Drained := make(chan struct{})
+	go func() {
+		defer close(s3Drained)
+		if s3Srv != nil && s3Srv.Shutdown(drainCtx) != nil {
+			s3Srv.Close()
+		}
+	}()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("Drain timed out with %d active transfers, closing connections: %v", handlers.ActiveTransfers(), err)
+		srv.Close()
+	}
+	<-s3Drained
+
+	// 2. Workers observed the cancelled ctx, wait for any in-progress run to return
+	worker.Wait()
+
+	// 3. Flush audit logger and pending spans
+	if err := logger.Close(); err != nil {
+		log.Printf("Failed to close audit logger: %v", err)
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+
+	// 4. Close the database last, nothing else uses it now
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+
+	log.Println("Server stopped")
+}
+
+// applyConfig swaps the components whose settings changed on a config reload.
+// Quota and pending expiry are read from config.Get on every use and need nothing here.
+func applyConfig(old, cfg *config.Config) {
+	if old.Logging != cfg.Logging {
+		logger.InitLogger(cfg.Logging.Mode, cfg.Logging.ServiceURL)
+	}
+	if !reflect.DeepEqual(old.CORS, cfg.CORS) {
+		middleware.SetCORSPolicy(cfg.CORS)
+		log.Printf("CORS policy updated: origins=%v", cfg.CORS.AllowedOrigins)
+	}
+	if old.Scanner != cfg.Scanner {
+		scanner.Init(cfg.Scanner)
+		worker.NotifyScan()
+	}
+	if old.Worker.StatusCheckInterval != cfg.Worker.StatusCheckInterval {
+		worker.SetStatusCheckInterval(cfg.Worker.StatusCheckInterval.Std())
+	}
+}
+
+// runKeys implements the keys subcommands
+func runKeys(command string, args []string) {
+	switch command {
+	case "generate":
+		var id string
+		if len(args) > 0 {
+			id = args[0]
+		}
+		line, err := encryption.GenerateKeyLine(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(line)
+	case "rotate":
+		cfg, err := config.Load(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Set(cfg)
+		db.InitDB(cfg.Database.Path)
+		if err := encryption.Init(cfg.Encryption); err != nil {
+			log.Fatal("Failed to initialize encryption: ", err)
+		}
+		rotated, failed, err := worker.RotateKeys(context.Background())
+		if err != nil {
+			log.Fatal("Key rotation failed: ", err)
+		}
+		fmt.Printf("Rewrapped %d data keys with master key %s, %d failed\n", rotated, encryption.Get().CurrentKeyID(), failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	default:
+		log.Fatalf("unknown keys command %q, expected generate or rotate", command)
+	}
+}
+
+// runReconcile implements the reconcile subcommand: interrupted operations are finished,
+// then orphans are reported, and fixed with -fix
+func runReconcile(args []string) {
+	fix := false
+	var rest []string
+	for _, arg := range args {
+		if arg == "-fix" || arg == "--fix" {
+			fix = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	cfg, err := config.Load(rest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Set(cfg)
+	db.InitDB(cfg.Database.Path)
+	if err := storage.InitStorage(cfg.Storage); err != nil {
+		log.Fatal("Failed to initialize storage: ", err)
+	}
+	if err := encryption.Init(cfg.Encryption); err != nil {
+		log.Fatal("Failed to initialize encryption: ", err)
+	}
+
+	ctx := context.Background()
+	// Younger intents may belong to requests of a running server
+	recovered, err := worker.RecoverIntents(ctx, time.Now().Add(-cfg.Worker.IntentTimeout.Std()))
+	if err != nil {
+		log.Fatal("Failed to recover intents: ", err)
+	}
+	report, err := worker.Reconcile(ctx, fix)
+	if err != nil {
+		log.Fatal("Reconcile failed: ", err)
+	}
+
+	fmt.Printf("Objects: %d, artifacts: %d, interrupted operations finished: %d\n", report.Objects, report.Artifacts, recovered)
+	for _, key := range report.OrphanObjects {
+		fmt.Println("orphan object:", key)
+	}
+	for _, uuid := range report.MissingContent {
+		fmt.Println("artifact without content:", uuid)
+	}
+	for _, uuid := range report.Reappeared {
+		fmt.Println("MISSING artifact with content:", uuid)
+	}
+	if fix {
+		fmt.Println("Fixed: orphan objects deleted, artifacts without content marked MISSING, reappeared artifacts restored")
+	} else if len(report.OrphanObjects)+len(report.MissingContent)+len(report.Reappeared) > 0 {
+		fmt.Println("Run with -fix to fix these")
+		os.Exit(1)
 	}
 }
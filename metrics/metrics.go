@@ -0,0 +1,253 @@
+package metrics
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "artifact_service"
+
+// Token validation outcomes
+const (
+	TokenValid        = "valid"
+	TokenNotFound     = "not_found"
+	TokenNotYetValid  = "not_yet_valid"
+	TokenExpired      = "expired"
+	TokenLimitReached = "limit_reached"
+	TokenIPDenied     = "ip_denied"
+	TokenInvalidCIDR  = "invalid_cidr"
+	TokenRevoked      = "revoked"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// UploadBytes counts artifact bytes received through the service
+	UploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Artifact bytes uploaded through the service.",
+	})
+
+	// DownloadBytes counts artifact bytes streamed to clients by the service.
+	// Token downloads redirect to the storage backend and are not included.
+	DownloadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Artifact bytes downloaded through the service.",
+	})
+
+	tokenValidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_validations_total",
+		Help:      "Token validations by token type and outcome.",
+	}, []string{"type", "outcome"})
+
+	storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Storage backend call latency by operation.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation"})
+
+	storageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_errors_total",
+		Help:      "Failed storage backend calls by operation.",
+	}, []string{"operation"})
+
+	workerRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "status_checker_runs_total",
+		Help:      "Status checker runs by result.",
+	}, []string{"result"})
+
+	scans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scans_total",
+		Help:      "Malware scans by result (clean, infected, error).",
+	}, []string{"result"})
+
+	scanDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scan_duration_seconds",
+		Help:      "Time to scan one artifact.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	})
+
+	statusTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "artifact_status_transitions_total",
+		Help:      "Artifacts moved to a new status by uploads, workers, deletes and version retention, by the status moved to.",
+	}, []string{"to"})
+
+	reconcileOrphans = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconcile_orphans",
+		Help:      "Inconsistencies found by the last reconciler run: objects without an artifact (object) and artifacts without content (artifact).",
+	}, []string{"kind"})
+
+	intentsRecovered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "intents_recovered_total",
+		Help:      "Interrupted uploads and deletes finished by the reconciler, by operation.",
+	}, []string{"operation"})
+
+	bulkItemsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bulk_items_total",
+		Help:      "Artifacts processed by bulk jobs, by operation and result.",
+	}, []string{"operation", "result"})
+)
+
+// Middleware records request count and latency per matched route
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Use the route template, not the raw path, to keep label cardinality bounded
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RecordTokenValidation counts a token check; tokenType is "download" or "upload"
+func RecordTokenValidation(tokenType, outcome string) {
+	tokenValidations.WithLabelValues(tokenType, outcome).Inc()
+}
+
+// ObserveStorage records latency and failure of a storage backend call started at start
+func ObserveStorage(operation string, start time.Time, err error) {
+	storageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		storageErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// RecordStatusCheck counts a status checker run
+func RecordStatusCheck(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	workerRuns.WithLabelValues(result).Inc()
+}
+
+// RecordScan counts a malware scan started at start; result is clean, infected or error
+func RecordScan(result string, start time.Time) {
+	scans.WithLabelValues(result).Inc()
+	scanDuration.Observe(time.Since(start).Seconds())
+}
+
+// RecordTransition counts an artifact moved to status, by whichever path moved it
+func RecordTransition(status string) {
+	statusTransitions.WithLabelValues(status).Inc()
+}
+
+// RecordReconcile sets the inconsistencies found by a reconciler run
+func RecordReconcile(orphanObjects, missingContent int) {
+	reconcileOrphans.WithLabelValues("object").Set(float64(orphanObjects))
+	reconcileOrphans.WithLabelValues("artifact").Set(float64(missingContent))
+}
+
+// RecordIntentRecovered counts an interrupted upload or delete finished by the reconciler
+func RecordIntentRecovered(operation string) {
+	intentsRecovered.WithLabelValues(operation).Inc()
+}
+
+// RecordBulkItem counts an artifact processed by a bulk job; result is succeeded,
+// failed or skipped
+func RecordBulkItem(operation, result string) {
+	bulkItemsTotal.WithLabelValues(operation, result).Inc()
+}
+
+// RegisterUsage exposes artifact count and used bytes as gauges. query is evaluated on
+// every scrape so the values always match what GetStorageUsage reports.
+func RegisterUsage(query func() (fileCount int64, usedSpace int64, err error)) {
+	prometheus.MustRegister(&usageCollector{query: query})
+}
+
+var (
+	artifactCountDesc = prometheus.NewDesc(namespace+"_artifacts", "Number of artifacts in the database.", nil, nil)
+	usedBytesDesc     = prometheus.NewDesc(namespace+"_storage_used_bytes", "Bytes used by artifacts.", nil, nil)
+)
+
+type usageCollector struct {
+	query func() (int64, int64, error)
+}
+
+func (u *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- artifactCountDesc
+	ch <- usedBytesDesc
+}
+
+func (u *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	fileCount, usedSpace, err := u.query()
+	if err != nil {
+		log.Println("Metrics: Failed to query storage usage:", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(artifactCountDesc, prometheus.GaugeValue, float64(fileCount))
+	ch <- prometheus.MustNewConstMetric(usedBytesDesc, prometheus.GaugeValue, float64(usedSpace))
+}
+
+// CountingReader adds every byte read from r to counter
+func CountingReader(r io.Reader, counter prometheus.Counter) io.Reader {
+	return &countingReader{r: r, counter: counter}
+}
+
+type countingReader struct {
+	r       io.Reader
+	counter prometheus.Counter
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.counter.Add(float64(n))
+	}
+	return n, err
+}
+
+// CountingReadSeeker is CountingReader for content served with http.ServeContent
+func CountingReadSeeker(r io.ReadSeeker, counter prometheus.Counter) io.ReadSeeker {
+	return &countingReadSeeker{countingReader: countingReader{r: r, counter: counter}, seeker: r}
+}
+
+type countingReadSeeker struct {
+	countingReader
+	seeker io.Seeker
+}
+
+func (cr *countingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return cr.seeker.Seek(offset, whence)
+}
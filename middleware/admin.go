@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"ArtifactService/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFingerprint returns the first 8 hex digits of the SHA-256 of an admin key, which
+// tells keys apart in audit entries
+func KeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// AdminActor returns who is making an admin request: "admin:" followed by a short
+// fingerprint of the admin.api_keys entry sent as "Authorization: Bearer <key>", so
+// audit entries tell keys apart without revealing them. It is "" for other requests.
+func AdminActor(c *gin.Context) string {
+	key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || key == "" {
+		return ""
+	}
+	for _, adminKey := range config.Get().Admin.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
+			return "admin:" + KeyFingerprint(adminKey)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"ArtifactService/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSPolicy is a compiled cors configuration
+type CORSPolicy struct {
+	anyOrigin        bool
+	origins          map[string]bool
+	wildcardSuffixes []string // "https://*.example.com" is stored as scheme "https://" + suffix ".example.com"
+	wildcardSchemes  []string
+	methods          string
+	allowedMethods   map[string]bool
+	headers          string
+	anyHeader        bool
+	exposed          string
+	credentials      bool
+	maxAge           string
+}
+
+// NewCORSPolicy compiles cfg into a policy
+func NewCORSPolicy(cfg config.CORSConfig) *CORSPolicy {
+	p := &CORSPolicy{
+		origins:        map[string]bool{},
+		allowedMethods: map[string]bool{},
+		credentials:    cfg.AllowCredentials,
+		exposed:        strings.Join(cfg.ExposedHeaders, ", "),
+		headers:        strings.Join(cfg.AllowedHeaders, ", "),
+		anyHeader:      slices.Contains(cfg.AllowedHeaders, "*"),
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.TrimRight(strings.ToLower(origin), "/")
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*")
+			p.wildcardSchemes = append(p.wildcardSchemes, scheme+"://")
+			p.wildcardSuffixes = append(p.wildcardSuffixes, host)
+		default:
+			p.origins[origin] = true
+		}
+	}
+
+	methods := make([]string, 0, len(cfg.AllowedMethods))
+	for _, m := range cfg.AllowedMethods {
+		m = strings.ToUpper(m)
+		p.allowedMethods[m] = true
+		methods = append(methods, m)
+	}
+	p.methods = strings.Join(methods, ", ")
+
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Std().Seconds()))
+	}
+	return p
+}
+
+// AllowsOrigin reports whether a request from origin may read responses
+func (p *CORSPolicy) AllowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for i, suffix := range p.wildcardSuffixes {
+		scheme := p.wildcardSchemes[i]
+		if strings.HasPrefix(origin, scheme) {
+			host := strings.TrimPrefix(origin, scheme)
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var corsPolicy atomic.Pointer[CORSPolicy]
+
+// SetCORSPolicy compiles cfg and makes it the policy applied by CORS. It can be
+// called at any time, requests already past the middleware keep the previous policy.
+func SetCORSPolicy(cfg config.CORSConfig) {
+	corsPolicy.Store(NewCORSPolicy(cfg))
+}
+
+// CORS applies the current policy to every request. Matching origins are echoed back
+// (only a policy of "*" without credentials answers with a literal "*"),
+// preflight requests are answered directly and never reach the handlers.
+func CORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := corsPolicy.Load()
+		if policy == nil {
+			policy = NewCORSPolicy(config.Get().CORS)
+			corsPolicy.CompareAndSwap(nil, policy)
+		}
+		origin := c.Request.Header.Get("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+
+		if !policy.anyOrigin || policy.credentials {
+			// The response depends on the Origin header, caches must key on it
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		if !policy.AllowsOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			// Serve the request without CORS headers, the browser will block the response
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		if policy.anyOrigin && !policy.credentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if policy.exposed != "" {
+				h.Set("Access-Control-Expose-Headers", policy.exposed)
+			}
+			c.Next()
+			return
+		}
+
+		requestedMethod := strings.ToUpper(c.Request.Header.Get("Access-Control-Request-Method"))
+		if !policy.allowedMethods[requestedMethod] {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", policy.methods)
+		if policy.anyHeader {
+			if requested := c.Request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				h.Set("Access-Control-Allow-Headers", requested)
+			}
+		} else if policy.headers != "" {
+			h.Set("Access-Control-Allow-Headers", policy.headers)
+		}
+		if policy.maxAge != "" {
+			h.Set("Access-Control-Max-Age", policy.maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
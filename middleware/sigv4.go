@@ -0,0 +1,394 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SigV4 constants
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	sigV4Service   = "s3"
+	sigV4Terminal  = "aws4_request"
+	amzDateFormat  = "20060102T150405Z"
+	// maxClockSkew is how far the signing time of a request may be off
+	maxClockSkew = 15 * time.Minute
+	// maxPresignExpiry is the longest validity of a presigned URL, as in S3
+	maxPresignExpiry = 7 * 24 * time.Hour
+	// maxChunkSize bounds the memory used to verify one aws-chunked chunk
+	maxChunkSize = 16 << 20
+)
+
+// Payload hashes with a meaning of their own
+const (
+	UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// emptySHA256 is the hex SHA-256 of no bytes
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sigV4Key is the context key of the verified request's *SigV4Auth
+const sigV4Key = "sigv4_auth"
+
+// Errors of aws-chunked request bodies
+var (
+	ErrChunkSignature = errors.New("chunk signature does not match")
+	ErrChunkFormat    = errors.New("malformed aws-chunked body")
+)
+
+// SigV4Auth is a request verified by S3Auth
+type SigV4Auth struct {
+	Actor       string // "s3:<access key ID>", for audit entries
+	AccessKey   string
+	Buckets     []string // buckets the access key may use, all if empty
+	PayloadHash string   // x-amz-content-sha256: hex digest, UNSIGNED-PAYLOAD or a streaming mode
+	signingKey  []byte
+	date        string // X-Amz-Date
+	scope       string
+	signature   string // seed signature of aws-chunked bodies
+}
+
+// sigV4Error is why a request isn't authenticated, as an S3 error code
+type sigV4Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *sigV4Error) Error() string { return e.message }
+
+// S3Auth verifies the AWS Signature Version 4 of S3 gateway requests, sent in the
+// Authorization header or as a presigned URL, against s3_gateway.credentials.
+func S3Auth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("x-amz-request-id", newRequestID())
+		auth, err := verifySigV4(c.Request, config.Get().S3Gateway.Region, time.Now())
+		if err != nil {
+			var sigErr *sigV4Error
+			if !errors.As(err, &sigErr) {
+				sigErr = &sigV4Error{http.StatusForbidden, "AccessDenied", err.Error()}
+			}
+			WriteS3Error(c, sigErr.status, sigErr.code, sigErr.message)
+			c.Abort()
+			return
+		}
+		c.Set(sigV4Key, auth)
+		c.Next()
+	}
+}
+
+// GetSigV4Auth returns the authentication of a request that passed S3Auth
+func GetSigV4Auth(c *gin.Context) *SigV4Auth {
+	auth, _ := c.Get(sigV4Key)
+	sigAuth, _ := auth.(*SigV4Auth)
+	return sigAuth
+}
+
+// CanAccess reports whether the access key may use bucket
+func (a *SigV4Auth) CanAccess(bucket string) bool {
+	return len(a.Buckets) == 0 || slices.Contains(a.Buckets, bucket)
+}
+
+// WriteS3Error answers with an S3 error document; HEAD responses have no body
+func WriteS3Error(c *gin.Context, status int, code, message string) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(status)
+		return
+	}
+	c.XML(status, models.S3Error{
+		Code:      code,
+		Message:   message,
+		Resource:  c.Request.URL.Path,
+		RequestID: c.Writer.Header().Get("x-amz-request-id"),
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// verifySigV4 checks the signature of r for region at now
+func verifySigV4(r *http.Request, region string, now time.Time) (*SigV4Auth, error) {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	presigned := query.Get("X-Amz-Algorithm") != ""
+
+	// 1. Collect the signature parameters of either form
+	if presigned {
+		if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+			return nil, &sigV4Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Algorithm must be " + sigV4Algorithm}
+		}
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		payloadHash = UnsignedPayload
+		if hash := query.Get("X-Amz-Content-Sha256"); hash != "" {
+			payloadHash = hash
+		}
+	} else {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			return nil, &sigV4Error{http.StatusForbidden, "AccessDenied", "Anonymous access is not allowed"}
+		}
+		params, ok := strings.CutPrefix(header, sigV4Algorithm+" ")
+		if !ok {
+			return nil, &sigV4Error{http.StatusBadRequest, "InvalidRequest", "Only " + sigV4Algorithm + " signatures are supported"}
+		}
+		for _, param := range strings.Split(params, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch key {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return nil, &sigV4Error{http.StatusBadRequest, "InvalidRequest", "Missing x-amz-content-sha256"}
+		}
+	}
+	if credential == "" || signedHeaders == "" || signature == "" || amzDate == "" {
+		return nil, &sigV4Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "Incomplete signature"}
+	}
+
+	// The host and every x-amz-* header sent, x-amz-content-sha256 and x-amz-date
+	// included, must be signed, or they could be changed without breaking the signature
+	signed := strings.Split(signedHeaders, ";")
+	if !slices.Contains(signed, "host") {
+		return nil, &sigV4Error{http.StatusForbidden, "AccessDenied", "The host header must be signed"}
+	}
+	for name := range r.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") && !slices.Contains(signed, name) {
+			return nil, &sigV4Error{http.StatusForbidden, "AccessDenied", "There were headers present in the request which were not signed: " + name}
+		}
+	}
+
+	// 2. Credential scope: <access key>/<date>/<region>/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != sigV4Service || parts[4] != sigV4Terminal {
+		return nil, &sigV4Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "Malformed credential"}
+	}
+	accessKey, day := parts[0], parts[1]
+	if parts[2] != region {
+		return nil, &sigV4Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", fmt.Sprintf("Region must be %s", region)}
+	}
+	signedAt, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, day) {
+		return nil, &sigV4Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "Malformed X-Amz-Date"}
+	}
+	if presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 1 || time.Duration(expires)*time.Second > maxPresignExpiry {
+			return nil, &sigV4Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 and 604800 seconds"}
+		}
+		if now.Before(signedAt.Add(-maxClockSkew)) || now.After(signedAt.Add(time.Duration(expires)*time.Second)) {
+			return nil, &sigV4Error{http.StatusForbidden, "AccessDenied", "Request has expired"}
+		}
+	} else if d := now.Sub(signedAt); d > maxClockSkew || d < -maxClockSkew {
+		return nil, &sigV4Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	}
+
+	// 3. The secret key of the access key
+	cred, ok := config.Get().S3Gateway.Credentials[accessKey]
+	if !ok || cred.Secret == "" {
+		return nil, &sigV4Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID does not exist"}
+	}
+	secret := cred.Secret
+
+	// 4. Recompute the signature
+	canonical := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(query),
+		canonicalHeaders(r, signedHeaders),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join(parts[1:], "/")
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	signingKey := hmacSHA256([]byte("AWS4"+secret), day)
+	for _, part := range []string{region, sigV4Service, sigV4Terminal} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, &sigV4Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+	}
+
+	return &SigV4Auth{
+		Actor:       "s3:" + accessKey,
+		AccessKey:   accessKey,
+		Buckets:     cred.Buckets,
+		PayloadHash: payloadHash,
+		signingKey:  signingKey,
+		date:        amzDate,
+		scope:       scope,
+		signature:   signature,
+	}, nil
+}
+
+// canonicalQuery is the query string sorted by key and value, without the signature
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		if key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders lists the signed headers as "name:value\n", values trimmed with
+// inner spaces collapsed
+func canonicalHeaders(r *http.Request, signedHeaders string) string {
+	var b strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var value string
+		if name == "host" {
+			value = r.Host
+		} else {
+			var values []string
+			for _, v := range r.Header.Values(name) {
+				values = append(values, strings.Join(strings.Fields(v), " "))
+			}
+			value = strings.Join(values, ",")
+		}
+		b.WriteString(name + ":" + value + "\n")
+	}
+	return b.String()
+}
+
+// uriEncode percent-encodes everything but the unreserved characters, and "/" unless
+// encodeSlash is set, as SigV4 requires
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ('A' <= ch && ch <= 'Z') || ('a' <= ch && ch <= 'z') || ('0' <= ch && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || (ch == '/' && !encodeSlash) {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChunkedBody decodes an aws-chunked request body, verifying the signature of every
+// chunk for the signed streaming modes. Trailers (checksums) are not checked.
+func (a *SigV4Auth) ChunkedBody(body io.Reader) io.Reader {
+	signed := a.PayloadHash == StreamingPayload || a.PayloadHash == StreamingPayloadTrailer
+	return &chunkedReader{r: bufio.NewReader(body), auth: a, signed: signed, prevSignature: a.signature}
+}
+
+// chunkedReader reads the data of "<hex size>;chunk-signature=<sig>\r\n<data>\r\n" chunks
+// up to the final empty chunk
+type chunkedReader struct {
+	r             *bufio.Reader
+	auth          *SigV4Auth
+	signed        bool
+	prevSignature string
+	chunk         []byte
+	pos           int
+	done          bool
+	err           error
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for cr.pos == len(cr.chunk) {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		if cr.done {
+			return 0, io.EOF
+		}
+		cr.err = cr.next()
+	}
+	n := copy(p, cr.chunk[cr.pos:])
+	cr.pos += n
+	return n, nil
+}
+
+// next reads and verifies the next chunk
+func (cr *chunkedReader) next() error {
+	line, err := cr.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrChunkFormat, err)
+	}
+	sizeHex, ext, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return fmt.Errorf("%w: invalid chunk size %q", ErrChunkFormat, sizeHex)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return fmt.Errorf("%w: %v", ErrChunkFormat, err)
+	}
+	if size > 0 {
+		if crlf, err := cr.r.Peek(2); err != nil || !bytes.Equal(crlf, []byte("\r\n")) {
+			return fmt.Errorf("%w: missing CRLF after chunk", ErrChunkFormat)
+		}
+		cr.r.Discard(2)
+	}
+
+	if cr.signed {
+		signature, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok {
+			return ErrChunkSignature
+		}
+		stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + cr.auth.date + "\n" + cr.auth.scope + "\n" + cr.prevSignature + "\n" + emptySHA256 + "\n" + sha256Hex(data)
+		if !hmac.Equal([]byte(hex.EncodeToString(hmacSHA256(cr.auth.signingKey, stringToSign))), []byte(signature)) {
+			return ErrChunkSignature
+		}
+		cr.prevSignature = signature
+	}
+
+	// The final chunk is empty; trailers after it are left unread
+	cr.chunk, cr.pos = data, 0
+	cr.done = size == 0
+	return nil
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// ArchiveEntry is a file or directory inside a stored ZIP or TAR artifact
+type ArchiveEntry struct {
+	Name           string    `json:"name"` // path inside the archive
+	Size           int64     `json:"size"`
+	CompressedSize int64     `json:"compressed_size,omitempty"` // ZIP only
+	Modified       time.Time `json:"modified"`
+	Dir            bool      `json:"dir,omitempty"`
+}
+
+// ArchiveListing lists the entries of an archive artifact in archive order
+type ArchiveListing struct {
+	UUID    string         `json:"uuid"`
+	Format  string         `json:"format"` // zip, tar or tar.gz
+	Entries []ArchiveEntry `json:"entries"`
+}
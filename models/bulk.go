@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+)
+
+// Bulk operations
+const (
+	BulkDelete    = "delete"
+	BulkRelabel   = "relabel"
+	BulkRetention = "retention"
+	BulkToken     = "token"
+)
+
+// Bulk job and item states
+const (
+	BulkQueued    = "queued"
+	BulkRunning   = "running"
+	BulkCompleted = "completed"
+
+	BulkItemPending   = "pending"
+	BulkItemSucceeded = "succeeded"
+	BulkItemFailed    = "failed"
+	BulkItemSkipped   = "skipped"
+)
+
+// BulkFilter selects artifacts for a bulk job; every field that is set must match.
+// DELETED artifacts are only selected with status DELETED.
+type BulkFilter struct {
+	Project       string            `json:"project"`
+	Status        string            `json:"status"`
+	Labels        map[string]string `json:"labels"`
+	CreatedAfter  *time.Time        `json:"created_after"`
+	CreatedBefore *time.Time        `json:"created_before"`
+}
+
+// BulkTokenParams are the constraints of the download tokens issued by a token job
+type BulkTokenParams struct {
+	ValidFrom    *time.Time `json:"valid_from"`
+	ValidTo      *time.Time `json:"valid_to"`
+	MaxDownloads *int64     `json:"max_downloads"`
+	AllowedCIDR  string     `json:"allowed_cidr"`
+}
+
+// BulkRequest starts a bulk job on the artifacts listed in uuids or matching filter
+type BulkRequest struct {
+	Operation string      `json:"operation" binding:"required"` // delete, relabel, retention or token
+	UUIDs     []string    `json:"uuids"`
+	Filter    *BulkFilter `json:"filter"`
+
+	Labels       map[string]string `json:"labels"`        // relabel: labels to add or change
+	RemoveLabels []string          `json:"remove_labels"` // relabel: labels to remove
+	RetainUntil  *time.Time        `json:"retain_until"`  // retention: end of the period, null to clear
+	Token        *BulkTokenParams  `json:"token"`         // token: constraints of the issued tokens
+	Reason       string            `json:"reason"`        // recorded in the audit log
+}
+
+// BulkJob is a bulk operation running in the background, with its progress
+type BulkJob struct {
+	ID         string     `json:"id"`
+	Operation  string     `json:"operation"`
+	Status     string     `json:"status"` // queued, running or completed
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Succeeded  int        `json:"succeeded"`
+	Failed     int        `json:"failed"`
+	Skipped    int        `json:"skipped"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// BulkItemResult is the outcome of a bulk job for one artifact
+type BulkItemResult struct {
+	UUID         string `json:"uuid"`
+	Status       string `json:"status"` // pending, succeeded, failed or skipped
+	Error        string `json:"error,omitempty"`
+	Token        string `json:"token,omitempty"`
+	PresignedURL string `json:"presigned_url,omitempty"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// Bundle archive formats
+const (
+	BundleZip   = "zip"
+	BundleTarGz = "tar.gz"
+)
+
+// BundleRequest selects the artifacts of a bundle, listed in uuids or matching labels
+type BundleRequest struct {
+	UUIDs   []string          `json:"uuids"`
+	Labels  map[string]string `json:"labels"`  // every label must match
+	Project string            `json:"project"` // with labels: only artifacts of this project
+	Format  string            `json:"format"`  // zip (default) or tar.gz
+	Name    string            `json:"name"`    // archive filename without extension, default "artifacts"
+}
+
+// GenBundleTokenRequest creates a token that downloads a bundle, with the same
+// constraints as a download token
+type GenBundleTokenRequest struct {
+	BundleRequest
+	ValidFrom    *time.Time `json:"valid_from"`
+	ValidTo      *time.Time `json:"valid_to"`
+	MaxDownloads *int64     `json:"max_downloads"`
+	AllowedCIDR  string     `json:"allowed_cidr"`
+}
@@ -5,16 +5,60 @@ import (
 )
 
 type Artifact struct {
-	UUID        string    `json:"uuid"`
-	Filename    string    `json:"filename"`
-	ContentType string    `json:"content_type"`
-	Size        int64     `json:"size"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
+	UUID                string            `json:"uuid"`
+	Filename            string            `json:"filename"`
+	ContentType         string            `json:"content_type"`                    // as declared by the client
+	DetectedContentType string            `json:"detected_content_type,omitempty"` // sniffed from the content
+	Project             string            `json:"project,omitempty"`
+	Size                int64             `json:"size"`
+	Status              string            `json:"status"`
+	Digest              string            `json:"digest,omitempty"` // sha256:<hex>, only known for uploads through the service
+	Labels              map[string]string `json:"labels,omitempty"`
+	CreatedAt           time.Time         `json:"created_at"`
+
+	// Compression at rest; Size stays the uncompressed size
+	ContentEncoding string `json:"content_encoding,omitempty"` // gzip or zstd, empty if stored as is
+	StoredSize      int64  `json:"stored_size,omitempty"`      // bytes in storage, known once the upload is complete
+
+	// Encryption at rest: envelope (by the service) or sse-c (by storage), with the master key wrapping the data key
+	Encryption string `json:"encryption,omitempty"`
+	KeyID      string `json:"key_id,omitempty"`
+
+	// Malware scan verdict: clean, infected or error; empty if never scanned
+	ScanResult    string     `json:"scan_result,omitempty"`
+	ScanSignature string     `json:"scan_signature,omitempty"`
+	ScannedAt     *time.Time `json:"scanned_at,omitempty"`
+
+	// Soft delete: set while the artifact is DELETED and can still be restored
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"` // when the purge worker removes it for good
+
+	// Locks: while either is in effect the artifact can't be deleted or overwritten
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+
+	// Storage state, only filled in by the single artifact endpoint
+	ETag      string `json:"etag,omitempty"`
+	InStorage *bool  `json:"in_storage,omitempty"`
+}
+
+// LegalHoldRequest sets or releases a legal hold; releasing needs an admin key
+type LegalHoldRequest struct {
+	Enabled *bool  `json:"enabled" binding:"required"`
+	Reason  string `json:"reason"`
+}
+
+// RetentionRequest sets the retention period. Extending it is open to everyone,
+// shortening or clearing it (retain_until null) needs an admin key.
+type RetentionRequest struct {
+	RetainUntil *time.Time `json:"retain_until"`
+	Reason      string     `json:"reason"`
 }
 
 type UploadRequest struct {
-	Filename    string `json:"filename" binding:"required"`
-	ContentType string `json:"content_type" binding:"required"`
-	Size        int64  `json:"size" binding:"required"`
+	Filename    string            `json:"filename" binding:"required"`
+	ContentType string            `json:"content_type" binding:"required"`
+	Size        int64             `json:"size" binding:"required"`
+	Labels      map[string]string `json:"labels"`
 }
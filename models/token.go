@@ -12,6 +12,9 @@ type Token struct {
 	MaxDownloads     *int64    `json:"max_downloads"` // Optional
 	CurrentDownloads int64     `json:"current_downloads"`
 	AllowedCIDR      string    `json:"allowed_cidr"` // Optional
+	AllowedTypes     []string  `json:"allowed_types"` // Optional, upload tokens only
+	Project          string    `json:"project"`       // Optional, upload tokens only
+	RevokedAt        *time.Time `json:"revoked_at"`   // Set when the artifact is deleted
 	CreatedAt        time.Time `json:"created_at"`
 }
 
@@ -28,4 +31,6 @@ type GenUploadTokenRequest struct {
 	ValidTo      *time.Time `json:"valid_to"`
 	MaxUploads   *int       `json:"max_uploads"`
 	AllowedCIDR  string     `json:"allowed_cidr"`
+	AllowedTypes []string   `json:"allowed_types"` // e.g. ["image/*", "application/pdf"]
+	Project      string     `json:"project"`
 }
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// ArtifactVersion is one version of a named artifact ("project/name")
+type ArtifactVersion struct {
+	Name      string    `json:"name"`
+	Version   int64     `json:"version"`
+	Tags      []string  `json:"tags,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Artifact  *Artifact `json:"artifact,omitempty"` // nil once the artifact is purged
+}
+
+// VersionListing is the version history of a name, newest first
+type VersionListing struct {
+	Name         string            `json:"name"`
+	KeepVersions *int              `json:"keep_versions,omitempty"` // overrides versions.keep
+	Latest       *int64            `json:"latest,omitempty"`        // newest UPLOADED version
+	Tags         map[string]int64  `json:"tags"`
+	Versions     []ArtifactVersion `json:"versions"`
+}
+
+// AddVersionRequest records an existing artifact as the next version of a name
+type AddVersionRequest struct {
+	ArtifactUUID string `json:"artifact_uuid" binding:"required"`
+}
+
+// TagRequest points a tag at a version
+type TagRequest struct {
+	Version int64 `json:"version" binding:"required"`
+}
+
+// VersionRetentionRequest sets how many downloadable versions a name keeps, null for
+// the versions.keep default and 0 to keep all
+type VersionRetentionRequest struct {
+	KeepVersions *int `json:"keep_versions"`
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the INSTREAM chunk size; it must stay below clamd's StreamMaxLength
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner streams content to clamd with the INSTREAM command
+type ClamdScanner struct {
+	network string // unix or tcp
+	address string
+}
+
+// NewClamdScanner returns a scanner for a clamd socket given as unix:///path or tcp://host:port
+func NewClamdScanner(target string) *ClamdScanner {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" {
+		// Validated by config, fall back to treating it as a unix socket path
+		return &ClamdScanner{network: "unix", address: target}
+	}
+	if u.Scheme == "unix" {
+		return &ClamdScanner{network: "unix", address: u.Path}
+	}
+	return &ClamdScanner{network: "tcp", address: u.Host}
+}
+
+func (s *ClamdScanner) Name() string { return "clamd" }
+
+// Scan sends r as "zINSTREAM", each chunk prefixed with its length as a 4-byte big
+// endian integer and terminated by a zero length chunk. clamd answers with
+// "stream: OK", "stream: <signature> FOUND" or "<reason> ERROR".
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock reads/writes when ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return Result{}, fmt.Errorf("failed to send INSTREAM: %w", err)
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				// clamd closes the connection when StreamMaxLength is exceeded, its reply says why
+				return s.readReply(conn, fmt.Errorf("failed to stream to clamd: %w", err))
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, fmt.Errorf("failed to read artifact: %w", readErr)
+		}
+	}
+
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return s.readReply(conn, fmt.Errorf("failed to stream to clamd: %w", err))
+	}
+
+	return s.readReply(conn, nil)
+}
+
+// readReply parses clamd's answer; writeErr is returned if there is no usable reply
+func (s *ClamdScanner) readReply(conn net.Conn, writeErr error) (Result, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && len(reply) == 0 {
+		if writeErr != nil {
+			return Result{}, writeErr
+		}
+		return Result{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	line := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+
+	switch {
+	case strings.HasSuffix(line, " OK"):
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(line, " FOUND"):
+		signature := strings.TrimSuffix(line, " FOUND")
+		if i := strings.Index(signature, ": "); i >= 0 {
+			signature = signature[i+2:]
+		}
+		return Result{Clean: false, Signature: signature}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", line)
+	}
+}
@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// CommandScanner runs an external command with the artifact on stdin, following the
+// clamscan/clamdscan exit codes: 0 clean, 1 infected, anything else is an error.
+type CommandScanner struct {
+	args []string
+}
+
+// NewCommandScanner splits command on whitespace, e.g. "clamdscan --no-summary -"
+func NewCommandScanner(command string) *CommandScanner {
+	return &CommandScanner{args: strings.Fields(command)}
+}
+
+func (s *CommandScanner) Name() string { return "command:" + s.args[0] }
+
+func (s *CommandScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return Result{Clean: true}, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && ctx.Err() == nil {
+		signature := strings.TrimSpace(stdout.String())
+		if signature == "" {
+			signature = "unknown"
+		}
+		// clamscan prints "stdin: <signature> FOUND", keep just the signature
+		if line, _, _ := strings.Cut(signature, "\n"); strings.HasSuffix(line, " FOUND") {
+			signature = strings.TrimSuffix(line, " FOUND")
+			if i := strings.Index(signature, ": "); i >= 0 {
+				signature = signature[i+2:]
+			}
+		}
+		return Result{Clean: false, Signature: signature}, nil
+	}
+
+	return Result{}, fmt.Errorf("scanner command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
@@ -0,0 +1,69 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+
+	"ArtifactService/config"
+)
+
+// Result is the verdict of a scan
+type Result struct {
+	Clean     bool
+	Signature string // name of the detected threat, empty when clean
+}
+
+// Scanner checks artifact content for malware
+type Scanner interface {
+	// Scan reads r to the end and returns the verdict. An error means no verdict
+	// could be reached (scanner unreachable, size limit, ...), not that r is infected.
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+	// Name identifies the scanner in audit records
+	Name() string
+}
+
+// Scanner modes
+const (
+	ModeNone    = "none"
+	ModeClamd   = "clamd"
+	ModeCommand = "command"
+)
+
+var (
+	mu       sync.RWMutex
+	instance Scanner
+)
+
+// Init configures the global scanner from cfg; mode none disables scanning.
+// It may be called again on config reload, scans in progress finish with the old one.
+func Init(cfg config.ScannerConfig) {
+	var next Scanner
+	switch cfg.Mode {
+	case ModeClamd:
+		next = NewClamdScanner(cfg.Address)
+		log.Printf("Scanner initialized in clamd mode (address: %s)", cfg.Address)
+	case ModeCommand:
+		next = NewCommandScanner(cfg.Command)
+		log.Printf("Scanner initialized in command mode (%s)", cfg.Command)
+	default:
+		log.Println("Scanner disabled")
+	}
+
+	mu.Lock()
+	instance = next
+	mu.Unlock()
+}
+
+// Get returns the configured scanner, nil if scanning is disabled
+func Get() Scanner {
+	mu.RLock()
+	defer mu.RUnlock()
+	return instance
+}
+
+// Enabled reports whether uploads go through the SCANNING stage
+func Enabled() bool {
+	return Get() != nil
+}
@@ -8,6 +8,24 @@ CREATE TABLE IF NOT EXISTS Artifacts (
     content_type TEXT NOT NULL,
     size BIGINT NOT NULL,
     status TEXT DEFAULT 'UPLOADED',
+    digest TEXT,
+    labels TEXT,
+    detected_content_type TEXT,
+    project TEXT,
+    upload_token TEXT,
+    scan_result TEXT,
+    scan_signature TEXT,
+    scanned_at TIMESTAMP,
+    content_encoding TEXT,
+    stored_size BIGINT,
+    encryption TEXT,
+    key_id TEXT,
+    wrapped_key TEXT,
+    deleted_at TIMESTAMP,
+    deleted_by TEXT,
+    deleted_status TEXT,
+    legal_hold INTEGER NOT NULL DEFAULT 0,
+    retain_until TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -20,11 +38,105 @@ CREATE TABLE IF NOT EXISTS tokens (
     max_downloads BIGINT,
     current_downloads BIGINT DEFAULT 0,
     allowed_cidr TEXT,
+    allowed_types TEXT,
+    project TEXT,
+    revoked_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY(artifact_uuid) REFERENCES Artifacts(uuid)
 );
 
+-- Storage changes in flight (uploads and deletes), completed or rolled back by the reconciler
+CREATE TABLE IF NOT EXISTS intents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation TEXT NOT NULL,
+    artifact_uuid TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create bulk job tables
+CREATE TABLE IF NOT EXISTS bulk_jobs (
+    id TEXT PRIMARY KEY,
+    operation TEXT NOT NULL,
+    params TEXT NOT NULL,
+    status TEXT NOT NULL,
+    total INTEGER NOT NULL,
+    created_by TEXT,
+    admin TEXT,
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS bulk_items (
+    job_id TEXT NOT NULL,
+    seq INTEGER NOT NULL,
+    artifact_uuid TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    error TEXT,
+    token TEXT,
+    PRIMARY KEY (job_id, seq)
+);
+
+-- Create named version tables
+CREATE TABLE IF NOT EXISTS artifact_names (
+    name TEXT PRIMARY KEY,
+    keep_versions INTEGER,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS artifact_versions (
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    artifact_uuid TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (name, version)
+);
+
+CREATE TABLE IF NOT EXISTS artifact_tags (
+    name TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (name, tag)
+);
+
+-- Create S3 gateway multipart upload tables, the parts are files in s3_gateway.multipart_dir
+CREATE TABLE IF NOT EXISTS s3_multipart_uploads (
+    upload_id TEXT PRIMARY KEY,
+    bucket TEXT NOT NULL,
+    object_key TEXT NOT NULL,
+    content_type TEXT,
+    labels TEXT,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL,
+    state TEXT NOT NULL DEFAULT 'open'
+);
+
+CREATE TABLE IF NOT EXISTS s3_multipart_parts (
+    upload_id TEXT NOT NULL,
+    part_number INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    etag TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (upload_id, part_number)
+);
+
+-- Create bundle tokens table
+CREATE TABLE IF NOT EXISTS bundle_tokens (
+    token TEXT PRIMARY KEY,
+    artifacts TEXT NOT NULL,
+    format TEXT NOT NULL,
+    name TEXT NOT NULL,
+    valid_from TIMESTAMP,
+    valid_to TIMESTAMP,
+    max_downloads BIGINT,
+    current_downloads BIGINT DEFAULT 0,
+    allowed_cidr TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Create indexes for better query performance
 CREATE INDEX IF NOT EXISTS idx_artifacts_created_at ON Artifacts(created_at);
 CREATE INDEX IF NOT EXISTS idx_tokens_artifact_uuid ON tokens(artifact_uuid);
 CREATE INDEX IF NOT EXISTS idx_tokens_valid_to ON tokens(valid_to);
+CREATE INDEX IF NOT EXISTS idx_intents_artifact_uuid ON intents(artifact_uuid);
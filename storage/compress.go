@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"ArtifactService/config"
+	"ArtifactService/contenttype"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of stored objects, as recorded in Artifacts.content_encoding
+// and sent in the object's Content-Encoding header
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// CompressionFor returns the encoding an upload of the given (detected) content type
+// and size is stored with under storage.compression, "" to store it as is. Uploads of
+// unknown size (-1) are compressed if their type qualifies.
+func CompressionFor(contentType string, size int64) string {
+	cfg := config.Get().Storage.Compression
+	if cfg.Algorithm == "none" || (size >= 0 && size < int64(cfg.MinSize)) {
+		return ""
+	}
+	if err := (contenttype.Policy{Allow: cfg.Types}).Check(contentType); err != nil {
+		return ""
+	}
+	return cfg.Algorithm
+}
+
+// compress returns the content of r compressed with encoding. The compression runs in
+// a goroutine feeding the pipe; closing the returned reader stops it.
+func compress(r io.Reader, encoding string, level int) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		enc, err := newEncoder(pw, encoding, level)
+		if err == nil {
+			_, err = io.Copy(enc, r)
+			if closeErr := enc.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func newEncoder(w io.Writer, encoding string, level int) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case EncodingZstd:
+		if level == 0 {
+			level = int(zstd.SpeedDefault)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevel(level)))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// NewDecoder returns a reader decompressing r, which is stored with encoding.
+// An empty encoding returns r as is.
+func NewDecoder(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "":
+		return io.NopCloser(r), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	case EncodingZstd:
+		// Artifacts are read once front to back, a single goroutine is enough
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// decodedBody closes both the decoder and the response body it reads from
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	d.ReadCloser.Close()
+	return d.body.Close()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"ArtifactService/encryption"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Content describes how an artifact's object is stored and holds what is needed to
+// read it back. Uploads are compressed first (Encoding), then envelope encrypted
+// (DataKey). Presigned uploads may instead be encrypted by storage (CustomerKey).
+type Content struct {
+	UUID        string
+	Encoding    string // gzip or zstd, "" if stored uncompressed
+	DataKey     []byte // envelope encryption data key, nil if not encrypted by the service
+	CustomerKey []byte // SSE-C key, nil if not encrypted by storage
+	StoredSize  int64  // object size, 0 if unknown (it is looked up when needed)
+}
+
+// Encrypted reports whether storage holds the content in a form clients can't be
+// redirected to: envelope encrypted or protected by an SSE-C key
+func (c *Content) Encrypted() bool {
+	return c.DataKey != nil || c.CustomerKey != nil
+}
+
+// EncodedSize returns the size of the content as compressed at rest (the uploaded
+// size if it isn't compressed) for an object of storedSize bytes
+func (c *Content) EncodedSize(storedSize int64) int64 {
+	if c.DataKey != nil {
+		return encryption.PlainSize(storedSize)
+	}
+	return storedSize
+}
+
+// Stat returns the object metadata, see StatFile
+func (c *Content) Stat(ctx context.Context) (*ObjectInfo, error) {
+	return StatFile(withCustomerKey(ctx, c.CustomerKey), c.UUID)
+}
+
+// Exists reports whether the object is in storage, see CheckFileExists
+func (c *Content) Exists(ctx context.Context) (bool, error) {
+	return CheckFileExists(withCustomerKey(ctx, c.CustomerKey), c.UUID)
+}
+
+// Open returns the content as uploaded, decrypted and decompressed
+func (c *Content) Open(ctx context.Context) (io.ReadCloser, error) {
+	body, err := c.OpenEncoded(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := NewDecoder(body, c.Encoding)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to decode %s: %w", c.UUID, err)
+	}
+	return &decodedBody{ReadCloser: dec, body: body}, nil
+}
+
+// Head returns the first n bytes of the content as uploaded
+func (c *Content) Head(ctx context.Context, n int) ([]byte, error) {
+	var body io.ReadCloser
+	var err error
+	if c.DataKey == nil && c.Encoding == "" {
+		body, err = DownloadRange(withCustomerKey(ctx, c.CustomerKey), c.UUID, 0, int64(n))
+	} else {
+		body, err = c.Open(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	head := make([]byte, n)
+	read, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read %s: %w", c.UUID, err)
+	}
+	return head[:read], nil
+}
+
+// OpenEncoded returns the content still compressed as at rest but decrypted, starting
+// at offset. Envelope encrypted content is fetched from the chunk holding offset.
+func (c *Content) OpenEncoded(ctx context.Context, offset int64) (io.ReadCloser, error) {
+	ctx = withCustomerKey(ctx, c.CustomerKey)
+	if c.DataKey == nil {
+		return DownloadRange(ctx, c.UUID, offset, -1)
+	}
+
+	if c.StoredSize == 0 {
+		info, err := StatFile(ctx, c.UUID)
+		if err != nil {
+			return nil, err
+		}
+		c.StoredSize = info.Size
+	}
+	chunk, sealedOffset := encryption.ChunkStart(offset)
+	body, err := DownloadRange(ctx, c.UUID, sealedOffset, -1)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := encryption.NewDecryptReader(body, c.DataKey, []byte(c.UUID), chunk, c.StoredSize)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	// Skip to offset within the chunk
+	if skip := offset - chunk*encryption.ChunkSize; skip > 0 {
+		if _, err := io.CopyN(io.Discard, plain, skip); err != nil {
+			body.Close()
+			return nil, fmt.Errorf("failed to read %s: %w", c.UUID, err)
+		}
+	}
+	return &decodedBody{ReadCloser: io.NopCloser(plain), body: body}, nil
+}
+
+// SSECustomerHeaders returns the headers a client must send with a presigned request
+// for an object encrypted with the SSE-C key
+func SSECustomerHeaders(key []byte) map[string]string {
+	sum := md5.Sum(key)
+	return map[string]string{
+		"x-amz-server-side-encryption-customer-algorithm": "AES256",
+		"x-amz-server-side-encryption-customer-key":       base64.StdEncoding.EncodeToString(key),
+		"x-amz-server-side-encryption-customer-key-MD5":   base64.StdEncoding.EncodeToString(sum[:]),
+	}
+}
+
+type customerKeyContext struct{}
+
+// withCustomerKey makes the storage calls made with ctx send the SSE-C key; a nil key
+// returns ctx as is
+func withCustomerKey(ctx context.Context, key []byte) context.Context {
+	if key == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, customerKeyContext{}, key)
+}
+
+// customerKey returns the SSE-C parameters for the request, nil pointers without a key.
+// The SDK base64 encodes the key and adds its MD5.
+func customerKey(ctx context.Context) (algorithm, key *string) {
+	if k, ok := ctx.Value(customerKeyContext{}).([]byte); ok {
+		return aws.String("AES256"), aws.String(string(k))
+	}
+	return nil, nil
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"ArtifactService/metrics"
+	"ArtifactService/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// observe starts a client span for a storage backend call and returns a function
+// that ends it and records the call latency/outcome in the metrics.
+func observe(ctx context.Context, operation, key string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "ArtifactService/storage", "storage."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "aws-api"),
+			attribute.String("rpc.service", "S3"),
+			attribute.String("aws.s3.bucket", bucketName),
+			attribute.String("aws.s3.key", key),
+		),
+	)
+	return ctx, func(err error) {
+		metrics.ObserveStorage(operation, start, err)
+		tracing.End(span, err)
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SetLegalHold turns the S3 Object Lock legal hold of an object on or off
+func SetLegalHold(ctx context.Context, uuid string, on bool) error {
+	status := s3.ObjectLockLegalHoldStatusOff
+	if on {
+		status = s3.ObjectLockLegalHoldStatusOn
+	}
+	ctx, done := observe(ctx, "put_object_legal_hold", uuid)
+	_, err := s3Client.PutObjectLegalHoldWithContext(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(bucketName),
+		Key:       aws.String(uuid),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(status)},
+	})
+	done(err)
+	if err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to set legal hold: %w", err)
+	}
+	return nil
+}
+
+// SetRetention sets the S3 Object Lock retention of an object; a zero until removes it.
+// Shortening or removing a GOVERNANCE retention bypasses it, which needs the
+// s3:BypassGovernanceRetention permission; COMPLIANCE retention can't be shortened.
+func SetRetention(ctx context.Context, uuid string, mode string, until time.Time) error {
+	retention := &s3.ObjectLockRetention{}
+	if !until.IsZero() {
+		retention.Mode = aws.String(mode)
+		retention.RetainUntilDate = aws.Time(until)
+	}
+	ctx, done := observe(ctx, "put_object_retention", uuid)
+	_, err := s3Client.PutObjectRetentionWithContext(ctx, &s3.PutObjectRetentionInput{
+		Bucket:                    aws.String(bucketName),
+		Key:                       aws.String(uuid),
+		Retention:                 retention,
+		BypassGovernanceRetention: aws.Bool(mode == s3.ObjectLockRetentionModeGovernance),
+	})
+	done(err)
+	if err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to set retention: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ObjectReader is an io.ReadSeeker over the stored content of an artifact, decrypted
+// but still compressed as at rest, so it can be served with http.ServeContent. Seeking
+// is free; the next Read issues a ranged GetObject from the current offset (from the
+// enclosing chunk for encrypted content), and seeking away closes the previous body.
+type ObjectReader struct {
+	ctx     context.Context
+	content *Content
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+// NewObjectReader returns a reader over content of the given size (see Content.EncodedSize)
+func NewObjectReader(ctx context.Context, content *Content, size int64) *ObjectReader {
+	return &ObjectReader{ctx: ctx, content: content, size: size}
+}
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.content.OpenEncoded(r.ctx, r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.size {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = r.offset + offset
+	case io.SeekEnd:
+		next = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if next != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = next
+	return next, nil
+}
+
+// Close releases the current response body, if any
+func (r *ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// DecodedReader is an io.ReadSeeker over the decompressed content of a compressed
+// object. A compressed stream can't be read from an offset, so seeking forward
+// skips decompressed bytes and seeking backwards starts over from the beginning.
+type DecodedReader struct {
+	ctx     context.Context
+	content *Content
+	size    int64 // uncompressed size
+	offset  int64 // position requested by Seek
+	pos     int64 // position of body in the decompressed stream
+	body    io.ReadCloser
+}
+
+// NewDecodedReader returns a reader over compressed content whose uncompressed size
+// is size (Artifacts.size)
+func NewDecodedReader(ctx context.Context, content *Content, size int64) *DecodedReader {
+	return &DecodedReader{ctx: ctx, content: content, size: size}
+}
+
+func (r *DecodedReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body != nil && r.pos > r.offset {
+		r.body.Close()
+		r.body = nil
+	}
+	if r.body == nil {
+		body, err := r.content.Open(r.ctx)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+		r.pos = 0
+	}
+	if r.pos < r.offset {
+		skipped, err := io.CopyN(io.Discard, r.body, r.offset-r.pos)
+		r.pos += skipped
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+
+	n, err := r.body.Read(p)
+	r.pos += int64(n)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.size {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *DecodedReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = r.offset + offset
+	case io.SeekEnd:
+		next = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = next
+	return next, nil
+}
+
+// Close releases the current response body, if any
+func (r *DecodedReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// ReaderAt adapts an ObjectReader or DecodedReader to io.ReaderAt, e.g. for
+// archive/zip. A read continuing where the previous one stopped reuses the open
+// response body; any other read seeks, so only the bytes asked for are fetched.
+type ReaderAt struct {
+	mu     sync.Mutex
+	r      io.ReadSeeker
+	size   int64
+	offset int64
+}
+
+// NewReaderAt returns a ReaderAt over r, which holds size bytes
+func NewReaderAt(r io.ReadSeeker, size int64) *ReaderAt {
+	// Seeking to the current position is free and tells where r is
+	offset, _ := r.Seek(0, io.SeekCurrent)
+	return &ReaderAt{r: r, size: size, offset: offset}
+}
+
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if off != r.offset {
+		if _, err := r.r.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.offset = off
+	}
+	// Reads past the end are cut short with io.EOF, as io.ReaderAt requires
+	want := p
+	if remaining := r.size - off; int64(len(want)) > remaining {
+		want = want[:remaining]
+	}
+	n, err := io.ReadFull(r.r, want)
+	r.offset += int64(n)
+	if err == nil && len(want) < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
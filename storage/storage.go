@@ -1,12 +1,19 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"mime"
+	"net/http"
+	"strings"
 	"time"
 
+	"ArtifactService/config"
+	"ArtifactService/encryption"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -22,29 +29,40 @@ var (
 	bucketName string
 )
 
-// InitStorage initializes the S3/Ceph client
-func InitStorage() error {
-	// Get configuration from environment variables
-	accessKey := os.Getenv("CEPH_ACCESS_KEY")
-	secretKey := os.Getenv("CEPH_SECRET_KEY")
-	endpoint := os.Getenv("CEPH_ENDPOINT")
-	bucketName = os.Getenv("CEPH_BUCKET")
+// ErrNotFound is returned when the object doesn't exist in the bucket
+var ErrNotFound = errors.New("object not found")
 
-	// Set default bucket name if not provided
-	if bucketName == "" {
-		bucketName = "artifacts"
-	}
+// ObjectInfo is the object metadata returned by HeadObject
+type ObjectInfo struct {
+	Size         int64
+	ETag         string // quoted, as sent by the backend
+	LastModified time.Time
+	ContentType  string
+}
+
+// InitStorage initializes the S3/Ceph client
+func InitStorage(cfg config.StorageConfig) error {
+	accessKey := cfg.AccessKey
+	secretKey := cfg.SecretKey
+	endpoint := cfg.Endpoint
+	bucketName = cfg.Bucket
 
 	// Validate required configuration
 	if accessKey == "" || secretKey == "" || endpoint == "" {
-		return fmt.Errorf("missing required Ceph configuration: CEPH_ACCESS_KEY, CEPH_SECRET_KEY, and CEPH_ENDPOINT must be set")
+		return fmt.Errorf("missing required Ceph configuration: storage endpoint, access key and secret key must be set")
 	}
 
+	// Objects compressed at rest (storage.compression) are read as stored; Go's transport
+	// would otherwise request gzip itself and transparently decompress gzip objects
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+
 	// Create AWS session with Ceph endpoint
 	sess, err := session.NewSession(&aws.Config{
+		HTTPClient:       &http.Client{Transport: transport},
 		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
 		Endpoint:         aws.String(endpoint),
-		Region:           aws.String("us-east-1"), // Ceph doesn't use regions, but SDK requires it
+		Region:           aws.String(cfg.Region), // Ceph doesn't use regions, but SDK requires it
 		S3ForcePathStyle: aws.Bool(true),          // Required for Ceph compatibility
 	})
 	if err != nil {
@@ -58,66 +76,120 @@ func InitStorage() error {
 
 	log.Printf("Storage initialized: endpoint=%s, bucket=%s", endpoint, bucketName)
 
-	// Configure CORS for the bucket to allow direct browser uploads
-	if err := ConfigureCORS(); err != nil {
-		log.Printf("Warning: Failed to configure CORS for bucket %s: %v", bucketName, err)
-		// We don't return error here because the bucket might already exist and be configured,
-		// or we might not have permission, but we still want the service to start.
+	// Configure CORS for the bucket to allow direct browser uploads. This replaces the
+	// bucket's whole CORS configuration, so it only happens when explicitly enabled.
+	if cfg.BucketCORS.Manage {
+		if err := ConfigureCORS(cfg.BucketCORS); err != nil {
+			log.Printf("Warning: Failed to configure CORS for bucket %s: %v", bucketName, err)
+			// We don't return error here because the bucket might already exist and be configured,
+			// or we might not have permission, but we still want the service to start.
+		}
+	} else {
+		log.Printf("Bucket CORS management disabled, leaving the CORS configuration of %s untouched", bucketName)
 	}
 
 	return nil
 }
 
-// ConfigureCORS configures CORS rules for the S3 bucket
-func ConfigureCORS() error {
-	_, err := s3Client.PutBucketCors(&s3.PutBucketCorsInput{
+// ConfigureCORS replaces the CORS rules of the S3 bucket with cfg
+func ConfigureCORS(cfg config.BucketCORSConfig) error {
+	rule := &s3.CORSRule{
+		AllowedHeaders: aws.StringSlice(cfg.AllowedHeaders),
+		AllowedMethods: aws.StringSlice(cfg.AllowedMethods),
+		AllowedOrigins: aws.StringSlice(cfg.AllowedOrigins),
+	}
+	if len(cfg.ExposedHeaders) > 0 {
+		rule.ExposeHeaders = aws.StringSlice(cfg.ExposedHeaders)
+	}
+	if cfg.MaxAge > 0 {
+		rule.MaxAgeSeconds = aws.Int64(int64(cfg.MaxAge.Std().Seconds()))
+	}
+
+	ctx, done := observe(context.Background(), "put_bucket_cors", "")
+	_, err := s3Client.PutBucketCorsWithContext(ctx, &s3.PutBucketCorsInput{
 		Bucket: aws.String(bucketName),
 		CORSConfiguration: &s3.CORSConfiguration{
-			CORSRules: []*s3.CORSRule{
-				{
-					AllowedHeaders: []*string{aws.String("*")},
-					AllowedMethods: []*string{aws.String("GET"), aws.String("PUT"), aws.String("POST"), aws.String("HEAD")},
-					AllowedOrigins: []*string{aws.String("*"), aws.String("http://10.188.157.24:8000"), aws.String("http://localhost:8000")}, // Explicitly allow frontend origin
-					ExposeHeaders:  []*string{aws.String("ETag")},
-					MaxAgeSeconds:  aws.Int64(3000),
-				},
-			},
+			CORSRules: []*s3.CORSRule{rule},
 		},
 	})
+	done(err)
+	if err == nil {
+		log.Printf("Bucket CORS configured for %s: origins=%v", bucketName, cfg.AllowedOrigins)
+	}
 	return err
 }
 
-// UploadFile uploads a file to Ceph storage
-func UploadFile(uuid, filename string, file io.Reader, contentType string, size int64) error {
+// UploadFile uploads a file to Ceph storage as described by content: compressed with
+// content.Encoding (see CompressionFor) and then encrypted with content.DataKey, if set.
+// It returns the number of bytes stored and records it in content.StoredSize; size is
+// the size of file as uploaded, -1 if unknown.
+func UploadFile(ctx context.Context, content *Content, filename string, file io.Reader, contentType string, size int64) (int64, error) {
 	// Use UUID as the object key in Ceph
-	key := uuid
+	key := content.UUID
 
-	// Upload to S3/Ceph
-	_, err := uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket:      aws.String(bucketName),
 		Key:         aws.String(key),
-		Body:        file,
 		ContentType: aws.String(contentType),
 		Metadata: map[string]*string{
-			"original-filename": aws.String(filename),
-			"file-size":         aws.String(fmt.Sprintf("%d", size)),
+			"original-filename": aws.String(mime.QEncoding.Encode("utf-8", filename)), // metadata headers must be ASCII
 		},
-	})
+	}
+	// Streamed uploads without Content-Length don't know their size up front
+	if size >= 0 {
+		input.Metadata["file-size"] = aws.String(fmt.Sprintf("%d", size))
+	}
+	body := file
+	if content.Encoding != "" {
+		compressed := compress(body, content.Encoding, config.Get().Storage.Compression.Level)
+		// Stops the compressor if the upload fails before reading everything
+		defer compressed.Close()
+		body = compressed
+		input.ContentEncoding = aws.String(content.Encoding)
+	}
+	if content.DataKey != nil {
+		sealed, err := encryption.NewEncryptReader(body, content.DataKey, []byte(content.UUID))
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt file: %w", err)
+		}
+		body = sealed
+		input.Metadata["encryption"] = aws.String(encryption.ModeEnvelope)
+		// Storage can't decode what it can't decrypt, the encoding is only in the metadata
+		if content.Encoding != "" {
+			input.ContentEncoding = nil
+			input.Metadata["content-encoding"] = aws.String(content.Encoding)
+		}
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = customerKey(withCustomerKey(ctx, content.CustomerKey))
+	stored := &countingReader{r: body}
+	input.Body = stored
+
+	// Upload to S3/Ceph
+	ctx, done := observe(ctx, "upload", key)
+	_, err := uploader.UploadWithContext(ctx, input)
+	done(err)
 	if err != nil {
-		return fmt.Errorf("failed to upload file to Ceph: %w", err)
+		return 0, fmt.Errorf("failed to upload file to Ceph: %w", err)
 	}
+	content.StoredSize = stored.n
 
-	log.Printf("File uploaded successfully: uuid=%s, filename=%s, size=%d", uuid, filename, size)
-	return nil
+	log.Printf("File uploaded successfully: uuid=%s, filename=%s, size=%d, stored=%d, encoding=%q, encrypted=%t",
+		key, filename, size, stored.n, content.Encoding, content.Encrypted())
+	return stored.n, nil
 }
 
 // DownloadFile downloads a file from Ceph storage
-func DownloadFile(uuid string) (io.ReadCloser, error) {
+func DownloadFile(ctx context.Context, uuid string) (io.ReadCloser, error) {
 	// Get object from S3/Ceph
-	result, err := s3Client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(uuid),
+	algorithm, key := customerKey(ctx)
+	ctx, done := observe(ctx, "get_object", uuid)
+	result, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(bucketName),
+		Key:                  aws.String(uuid),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
 	})
+	done(err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download file from Ceph: %w", err)
 	}
@@ -125,12 +197,77 @@ func DownloadFile(uuid string) (io.ReadCloser, error) {
 	return result.Body, nil
 }
 
-// DeleteFile deletes a file from Ceph storage
-func DeleteFile(uuid string) error {
-	_, err := s3Client.DeleteObject(&s3.DeleteObjectInput{
+// StatFile returns the object metadata from HeadObject, or ErrNotFound
+func StatFile(ctx context.Context, uuid string) (*ObjectInfo, error) {
+	algorithm, key := customerKey(ctx)
+	ctx, done := observe(ctx, "head_object", uuid)
+	result, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(bucketName),
+		Key:                  aws.String(uuid),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			done(nil)
+			return nil, ErrNotFound
+		}
+		done(err)
+		return nil, fmt.Errorf("failed to stat file in Ceph: %w", err)
+	}
+	done(nil)
+
+	return &ObjectInfo{
+		Size:         aws.Int64Value(result.ContentLength),
+		ETag:         aws.StringValue(result.ETag),
+		LastModified: aws.TimeValue(result.LastModified),
+		ContentType:  aws.StringValue(result.ContentType),
+	}, nil
+}
+
+// DownloadRange returns length bytes of the object starting at offset; a negative
+// length reads to the end of the object.
+func DownloadRange(ctx context.Context, uuid string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	algorithm, key := customerKey(ctx)
+	ctx, done := observe(ctx, "get_object", uuid)
+	result, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(bucketName),
+		Key:                  aws.String(uuid),
+		Range:                aws.String(byteRange),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+		// Ranges past the end of the object (e.g. any range of an empty object) read as empty
+		done(nil)
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download range %s from Ceph: %w", byteRange, err)
+	}
+
+	return result.Body, nil
+}
+
+// DeleteFile deletes a file from Ceph storage. With storage.object_lock the bucket is
+// versioned, where a delete without a version only adds a delete marker, so every
+// version of the object is deleted instead.
+func DeleteFile(ctx context.Context, uuid string) error {
+	if config.Get().Storage.ObjectLock.Enabled {
+		return deleteVersions(ctx, uuid)
+	}
+	ctx, done := observe(ctx, "delete_object", uuid)
+	_, err := s3Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(uuid),
 	})
+	done(err)
 	if err != nil {
 		return fmt.Errorf("failed to delete file from Ceph: %w", err)
 	}
@@ -139,23 +276,101 @@ func DeleteFile(uuid string) error {
 	return nil
 }
 
+// deleteVersions deletes every version and delete marker of an object. Versions still
+// under a legal hold or retention period are refused by storage.
+func deleteVersions(ctx context.Context, uuid string) error {
+	listCtx, done := observe(ctx, "list_object_versions", uuid)
+	var versions []*string
+	err := s3Client.ListObjectVersionsPagesWithContext(listCtx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(uuid),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		// The prefix also matches longer keys
+		for _, v := range page.Versions {
+			if aws.StringValue(v.Key) == uuid {
+				versions = append(versions, v.VersionId)
+			}
+		}
+		for _, m := range page.DeleteMarkers {
+			if aws.StringValue(m.Key) == uuid {
+				versions = append(versions, m.VersionId)
+			}
+		}
+		return true
+	})
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to list versions of %s in Ceph: %w", uuid, err)
+	}
+
+	for _, versionID := range versions {
+		deleteCtx, done := observe(ctx, "delete_object_version", uuid)
+		_, err := s3Client.DeleteObjectWithContext(deleteCtx, &s3.DeleteObjectInput{
+			Bucket:    aws.String(bucketName),
+			Key:       aws.String(uuid),
+			VersionId: versionID,
+		})
+		done(err)
+		if err != nil {
+			return fmt.Errorf("failed to delete version %s of %s from Ceph: %w", aws.StringValue(versionID), uuid, err)
+		}
+	}
+
+	log.Printf("File deleted successfully: uuid=%s versions=%d", uuid, len(versions))
+	return nil
+}
+
+// ListFiles calls fn with the key and metadata of every object in the bucket, page by
+// page. An error returned by fn stops the listing and is returned.
+func ListFiles(ctx context.Context, fn func(key string, info ObjectInfo) error) error {
+	ctx, done := observe(ctx, "list_objects", "")
+	var fnErr error
+	err := s3Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			fnErr = fn(aws.StringValue(obj.Key), ObjectInfo{
+				Size:         aws.Int64Value(obj.Size),
+				ETag:         aws.StringValue(obj.ETag),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+			if fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to list bucket %s: %w", bucketName, err)
+	}
+	return fnErr
+}
+
 // GetBucketName returns the configured bucket name
 func GetBucketName() string {
 	return bucketName
 }
 
-// GeneratePresignedURL generates a presigned URL for downloading a file from Ceph/S3
-func GeneratePresignedURL(uuid string, expirationMinutes int) (string, error) {
+// GeneratePresignedURL generates a presigned URL for downloading a file from Ceph/S3.
+// A non-empty contentDisposition is returned by the backend as the Content-Disposition header.
+func GeneratePresignedURL(ctx context.Context, uuid string, expirationMinutes int, contentDisposition string) (string, error) {
 	if expirationMinutes <= 0 {
 		expirationMinutes = 15 // Default to 15 minutes
 	}
 
-	req, _ := s3Client.GetObjectRequest(&s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(uuid),
-	})
+	}
+	if contentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(contentDisposition)
+	}
+	req, _ := s3Client.GetObjectRequest(input)
 
+	_, done := observe(ctx, "presign_get", uuid)
 	urlStr, err := req.Presign(time.Duration(expirationMinutes) * time.Minute)
+	done(err)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
 	}
@@ -164,18 +379,28 @@ func GeneratePresignedURL(uuid string, expirationMinutes int) (string, error) {
 	return urlStr, nil
 }
 
-// GeneratePresignedUploadURL generates a presigned URL for uploading a file to Ceph/S3
-func GeneratePresignedUploadURL(uuid, filename, contentType string, expirationMinutes int) (string, error) {
+// GeneratePresignedUploadURL generates a presigned URL for uploading a file to Ceph/S3.
+// With a customerKey storage encrypts the object with SSE-C; the client must send
+// SSECustomerHeaders(customerKey) with the upload.
+func GeneratePresignedUploadURL(ctx context.Context, uuid, filename, contentType string, expirationMinutes int, customerKey []byte) (string, error) {
 	if expirationMinutes <= 0 {
 		expirationMinutes = 15 // Default to 15 minutes
 	}
 
-	req, _ := s3Client.PutObjectRequest(&s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket:      aws.String(bucketName),
 		Key:         aws.String(uuid),
-	})
+	}
+	if customerKey != nil {
+		// Signed as headers, not hoisted into the query, so the client has to send them
+		input.SSECustomerAlgorithm = aws.String("AES256")
+		input.SSECustomerKey = aws.String(string(customerKey))
+	}
+	req, _ := s3Client.PutObjectRequest(input)
 
+	_, done := observe(ctx, "presign_put", uuid)
 	urlStr, err := req.Presign(time.Duration(expirationMinutes) * time.Minute)
+	done(err)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate presigned upload URL: %w", err)
 	}
@@ -185,24 +410,48 @@ func GeneratePresignedUploadURL(uuid, filename, contentType string, expirationMi
 }
 
 // CheckFileExists checks if a file exists in Ceph/S3
-func CheckFileExists(uuid string) (bool, error) {
-	_, err := s3Client.HeadObject(&s3.HeadObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(uuid),
+func CheckFileExists(ctx context.Context, uuid string) (bool, error) {
+	algorithm, key := customerKey(ctx)
+	ctx, done := observe(ctx, "head_object", uuid)
+	_, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(bucketName),
+		Key:                  aws.String(uuid),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
 	})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case "NotFound", s3.ErrCodeNoSuchKey, "404":
-				return false, nil
-			}
+		if isNotFound(err) {
+			// A missing object is an expected answer, not a backend failure
+			done(nil)
+			return false, nil
 		}
-		// Fallback for non-awserr cases if needed, but for now propagate error if it's not a 404
-		// Actually, if it is a 404 but SDK didn't wrap it well (rare), we might erroneously return error.
-		// Detailed error logging:
-		// log.Printf("HeadObject error for %s: %v", uuid, err)
+		done(err)
 		return false, err
 	}
+	done(nil)
 	return true, nil
 }
 
+// isNotFound reports whether err is the backend's answer for a missing object
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "NotFound", s3.ErrCodeNoSuchKey, "404":
+			return true
+		}
+	}
+	return false
+}
+
+// HeadBucket checks that the configured bucket exists and is reachable with our credentials
+func HeadBucket(ctx context.Context) error {
+	ctx, done := observe(ctx, "head_bucket", "")
+	_, err := s3Client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to reach bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
@@ -0,0 +1,499 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/models"
+
+	"github.com/google/uuid"
+)
+
+// bulkRequested wakes the bulk worker up when a job is created
+var bulkRequested = make(chan struct{}, 1)
+
+// NotifyBulk makes the bulk worker pick up queued jobs right away
+func NotifyBulk() {
+	select {
+	case bulkRequested <- struct{}{}:
+	default:
+	}
+}
+
+// CreateBulkJob records a job for req on the selected artifacts, each of them an item
+// pending until the bulk worker gets to it. admin is the admin actor of the request,
+// if any, and lifts the restrictions on shortening retention.
+func CreateBulkJob(ctx context.Context, req models.BulkRequest, uuids []string, createdBy, admin string) (*models.BulkJob, error) {
+	// The selection is stored with the items, the rest are the operation's parameters
+	req.UUIDs, req.Filter = nil, nil
+	params, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.BulkJob{
+		ID:        uuid.New().String(),
+		Operation: req.Operation,
+		Status:    models.BulkQueued,
+		Total:     len(uuids),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO bulk_jobs (id, operation, params, status, total, created_by, admin, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Operation, string(params), job.Status, job.Total, createdBy, sql.NullString{String: admin, Valid: admin != ""}, job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO bulk_items (job_id, seq, artifact_uuid) VALUES (?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for i, id := range uuids {
+		if _, err := stmt.ExecContext(ctx, job.ID, i, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	NotifyBulk()
+	return job, nil
+}
+
+// LoadBulkJob returns a job with its progress; sql.ErrNoRows for unknown jobs
+func LoadBulkJob(ctx context.Context, id string) (*models.BulkJob, error) {
+	job := &models.BulkJob{}
+	var createdBy sql.NullString
+	var startedAt, finishedAt sql.NullTime
+	err := db.DB.QueryRowContext(ctx, "SELECT id, operation, status, total, created_by, created_at, started_at, finished_at FROM bulk_jobs WHERE id = ?", id).
+		Scan(&job.ID, &job.Operation, &job.Status, &job.Total, &createdBy, &job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.CreatedBy = createdBy.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT status, COUNT(*) FROM bulk_items WHERE job_id = ? GROUP BY status", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		switch status {
+		case models.BulkItemSucceeded:
+			job.Succeeded = n
+		case models.BulkItemFailed:
+			job.Failed = n
+		case models.BulkItemSkipped:
+			job.Skipped = n
+		}
+	}
+	job.Processed = job.Succeeded + job.Failed + job.Skipped
+	return job, rows.Err()
+}
+
+// ListBulkItems returns the per-artifact results of a job in selection order, only
+// those with status if it is set
+func ListBulkItems(ctx context.Context, id, status string) ([]models.BulkItemResult, error) {
+	query := "SELECT artifact_uuid, status, error, token FROM bulk_items WHERE job_id = ?"
+	args := []any{id}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.DB.QueryContext(ctx, query+" ORDER BY seq", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.BulkItemResult{}
+	for rows.Next() {
+		var item models.BulkItemResult
+		var itemErr, token sql.NullString
+		if err := rows.Scan(&item.UUID, &item.Status, &itemErr, &token); err != nil {
+			return nil, err
+		}
+		item.Error = itemErr.String
+		item.Token = token.String
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// StartBulkWorker runs queued bulk jobs one at a time, oldest first. Jobs interrupted by
+// a shutdown continue with their pending items at the next start. Finished jobs are
+// removed after bulk.job_retention. It stops when ctx is cancelled.
+func StartBulkWorker(ctx context.Context) {
+	log.Println("Starting Bulk Worker")
+	timer := time.NewTimer(0)
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Bulk Worker stopped")
+				return
+			case <-bulkRequested:
+			case <-timer.C:
+				timer.Reset(time.Minute)
+				removeExpiredBulkJobs(ctx)
+			}
+			runBulkJobs(ctx)
+		}
+	}()
+}
+
+func runBulkJobs(ctx context.Context) {
+	for ctx.Err() == nil {
+		var id string
+		err := db.DB.QueryRowContext(ctx, "SELECT id FROM bulk_jobs WHERE status IN (?, ?) ORDER BY created_at LIMIT 1",
+			models.BulkQueued, models.BulkRunning).Scan(&id)
+		if err == sql.ErrNoRows {
+			return
+		}
+		if err != nil {
+			log.Println("Worker: Failed to query bulk jobs:", err)
+			return
+		}
+		if err := runBulkJob(ctx, id); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Worker: Bulk job %s failed: %v", id, err)
+			}
+			return
+		}
+	}
+}
+
+// bulkJob is what processing an item needs to know about its job
+type bulkJob struct {
+	id        string
+	req       models.BulkRequest
+	createdBy string
+	admin     string
+}
+
+func runBulkJob(ctx context.Context, id string) error {
+	job := bulkJob{id: id}
+	var params string
+	var createdBy, admin sql.NullString
+	err := db.DB.QueryRowContext(ctx, "SELECT operation, params, created_by, admin FROM bulk_jobs WHERE id = ?", id).
+		Scan(&job.req.Operation, &params, &createdBy, &admin)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(params), &job.req); err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+	job.createdBy, job.admin = createdBy.String, admin.String
+
+	if _, err := db.DB.ExecContext(ctx, "UPDATE bulk_jobs SET status = ?, started_at = IFNULL(started_at, ?) WHERE id = ?",
+		models.BulkRunning, time.Now().UTC(), id); err != nil {
+		return err
+	}
+	log.Printf("Worker: Running bulk %s job %s", job.req.Operation, id)
+
+	// Pending items only, so a resumed job doesn't repeat finished ones
+	rows, err := db.DB.QueryContext(ctx, "SELECT seq, artifact_uuid FROM bulk_items WHERE job_id = ? AND status = ? ORDER BY seq", id, models.BulkItemPending)
+	if err != nil {
+		return err
+	}
+	type pendingItem struct {
+		seq  int
+		uuid string
+	}
+	var pending []pendingItem
+	for rows.Next() {
+		var item pendingItem
+		if err := rows.Scan(&item.seq, &item.uuid); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range pending {
+		if ctx.Err() != nil {
+			// Stays running, the rest is done after the restart
+			return ctx.Err()
+		}
+		status, token, itemErr := runBulkItem(ctx, job, item.uuid)
+		var message sql.NullString
+		if itemErr != nil {
+			message = sql.NullString{String: itemErr.Error(), Valid: true}
+		}
+		if _, err := db.DB.ExecContext(ctx, "UPDATE bulk_items SET status = ?, error = ?, token = ? WHERE job_id = ? AND seq = ?",
+			status, message, sql.NullString{String: token, Valid: token != ""}, id, item.seq); err != nil {
+			return err
+		}
+		metrics.RecordBulkItem(job.req.Operation, status)
+	}
+
+	if _, err := db.DB.ExecContext(ctx, "UPDATE bulk_jobs SET status = ?, finished_at = ? WHERE id = ?",
+		models.BulkCompleted, time.Now().UTC(), id); err != nil {
+		return err
+	}
+	log.Printf("Worker: Bulk job %s completed", id)
+	return nil
+}
+
+// runBulkItem applies the job's operation to one artifact and returns the item status,
+// the issued token for token jobs and the reason for failed or skipped items
+func runBulkItem(ctx context.Context, job bulkJob, artifactUUID string) (string, string, error) {
+	details := "Bulk job " + job.id
+	if job.req.Reason != "" {
+		details += ": " + job.req.Reason
+	}
+
+	var status sql.NullString
+	err := db.DB.QueryRowContext(ctx, "SELECT status FROM Artifacts WHERE uuid = ?", artifactUUID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return models.BulkItemFailed, "", errors.New("artifact not found")
+	}
+	if err != nil {
+		return models.BulkItemFailed, "", err
+	}
+
+	switch job.req.Operation {
+	case models.BulkDelete:
+		if status.String == "DELETED" {
+			return models.BulkItemSkipped, "", errors.New("artifact is already deleted")
+		}
+		// created_by is the client IP, the admin key identifies who deleted
+		deletedBy := job.admin
+		if deletedBy == "" {
+			deletedBy = "bulk:" + job.id
+		}
+		_, _, err := TrashArtifact(ctx, artifactUUID, deletedBy)
+		switch {
+		case errors.Is(err, ErrLocked):
+			logger.Record(logger.ActionDelete, artifactUUID, job.createdBy, job.admin, "FAILED", details+" (refused: artifact is locked)")
+			return models.BulkItemFailed, "", err
+		case err == sql.ErrNoRows:
+			return models.BulkItemFailed, "", fmt.Errorf("artifact can't be deleted while %s", status.String)
+		case err != nil:
+			return models.BulkItemFailed, "", err
+		}
+		if config.Get().Delete.GracePeriod <= 0 {
+			// Like a single delete; on failure the purge worker retries
+			if _, err := PurgeArtifact(ctx, artifactUUID); err != nil {
+				log.Printf("Worker: Failed to purge %s, left to the purge worker: %v", artifactUUID, err)
+			} else {
+				metrics.RecordTransition("PURGED")
+			}
+		}
+		logger.Record(logger.ActionDelete, artifactUUID, job.createdBy, job.admin, "SUCCESS", details)
+
+	case models.BulkRelabel:
+		if status.String == "DELETED" {
+			return models.BulkItemFailed, "", errors.New("artifact is deleted")
+		}
+		if err := relabel(ctx, artifactUUID, job.req.Labels, job.req.RemoveLabels); err != nil {
+			return models.BulkItemFailed, "", err
+		}
+
+	case models.BulkRetention:
+		_, err := SetRetention(ctx, artifactUUID, job.req.RetainUntil, job.admin != "")
+		if errors.Is(err, ErrNoContent) {
+			return models.BulkItemSkipped, "", err
+		}
+		if err != nil {
+			if errors.Is(err, ErrAdminRequired) || errors.Is(err, ErrComplianceLocked) {
+				logger.Record(logger.ActionLock, artifactUUID, job.createdBy, job.admin, "FAILED", details+" (refused: "+err.Error()+")")
+			}
+			return models.BulkItemFailed, "", err
+		}
+		action := "retention cleared"
+		if job.req.RetainUntil != nil {
+			action = "retention set until " + job.req.RetainUntil.UTC().Format(time.RFC3339)
+		}
+		logger.Record(logger.ActionLock, artifactUUID, job.createdBy, job.admin, "SUCCESS", details+" ("+action+")")
+
+	case models.BulkToken:
+		switch status.String {
+		case "DELETED":
+			return models.BulkItemFailed, "", errors.New("artifact is deleted")
+		case "QUARANTINED":
+			return models.BulkItemFailed, "", errors.New("artifact is quarantined")
+		}
+		token, err := issueDownloadToken(ctx, artifactUUID, job.req.Token)
+		if err != nil {
+			return models.BulkItemFailed, "", err
+		}
+		return models.BulkItemSucceeded, token, nil
+
+	default:
+		return models.BulkItemFailed, "", fmt.Errorf("unknown operation %q", job.req.Operation)
+	}
+	return models.BulkItemSucceeded, "", nil
+}
+
+// relabel adds, changes and removes labels of an artifact
+func relabel(ctx context.Context, artifactUUID string, set map[string]string, remove []string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var raw sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT labels FROM Artifacts WHERE uuid = ?", artifactUUID).Scan(&raw); err != nil {
+		return err
+	}
+	labels := map[string]string{}
+	if raw.String != "" {
+		if err := json.Unmarshal([]byte(raw.String), &labels); err != nil {
+			return fmt.Errorf("invalid labels stored: %w", err)
+		}
+	}
+	for _, key := range remove {
+		delete(labels, key)
+	}
+	for key, value := range set {
+		labels[key] = value
+	}
+
+	column := sql.NullString{}
+	if len(labels) > 0 {
+		data, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		column = sql.NullString{String: string(data), Valid: true}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE Artifacts SET labels = ? WHERE uuid = ?", column, artifactUUID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// issueDownloadToken creates a download token for an artifact with the given constraints
+func issueDownloadToken(ctx context.Context, artifactUUID string, params *models.BulkTokenParams) (string, error) {
+	if params == nil {
+		params = &models.BulkTokenParams{}
+	}
+	token := uuid.New().String()
+	_, err := db.DB.ExecContext(ctx, `
+		INSERT INTO tokens (token, artifact_uuid, valid_from, valid_to, max_downloads, allowed_cidr)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		token, artifactUUID, params.ValidFrom, params.ValidTo, params.MaxDownloads, params.AllowedCIDR)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ValidateBulkRequest checks the operation's parameters before a job is created
+func ValidateBulkRequest(req models.BulkRequest) error {
+	switch {
+	case len(req.UUIDs) > 0 && req.Filter != nil:
+		return errors.New("select artifacts by uuids or filter, not both")
+	case len(req.UUIDs) == 0 && req.Filter == nil:
+		return errors.New("select artifacts by uuids or filter")
+	case req.Filter != nil && req.Filter.Project == "" && req.Filter.Status == "" && len(req.Filter.Labels) == 0 &&
+		req.Filter.CreatedAfter == nil && req.Filter.CreatedBefore == nil:
+		// A filter without criteria would select everything
+		return errors.New("filter must set at least one of project, status, labels, created_after or created_before")
+	}
+
+	switch req.Operation {
+	case models.BulkDelete:
+	case models.BulkRelabel:
+		if len(req.Labels) == 0 && len(req.RemoveLabels) == 0 {
+			return errors.New("relabel needs labels or remove_labels")
+		}
+	case models.BulkRetention:
+		if req.RetainUntil != nil && !req.RetainUntil.After(time.Now()) {
+			return errors.New("retain_until must be in the future")
+		}
+	case models.BulkToken:
+		if req.Token != nil && req.Token.AllowedCIDR != "" {
+			if _, _, err := net.ParseCIDR(req.Token.AllowedCIDR); err != nil {
+				return fmt.Errorf("invalid allowed_cidr: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("operation must be %s, %s, %s or %s", models.BulkDelete, models.BulkRelabel, models.BulkRetention, models.BulkToken)
+	}
+	return nil
+}
+
+// removeExpiredBulkJobs removes jobs finished more than bulk.job_retention ago
+func removeExpiredBulkJobs(ctx context.Context) {
+	cutoff := time.Now().Add(-config.Get().Bulk.JobRetention.Std())
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, finished_at FROM bulk_jobs WHERE status = ?", models.BulkCompleted)
+	if err != nil {
+		log.Println("Worker: Failed to query bulk jobs:", err)
+		return
+	}
+	var expired []string
+	for rows.Next() {
+		var id string
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&id, &finishedAt); err != nil {
+			log.Println("Worker: Failed to scan row:", err)
+			continue
+		}
+		if finishedAt.Valid && finishedAt.Time.Before(cutoff) {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range expired {
+		tx, err := db.DB.BeginTx(ctx, nil)
+		if err != nil {
+			log.Println("Worker: Failed to remove bulk job:", err)
+			return
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM bulk_items WHERE job_id = ?", id)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM bulk_jobs WHERE id = ?", id)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if err != nil {
+			log.Printf("Worker: Failed to remove bulk job %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"ArtifactService/contenttype"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/storage"
+)
+
+// ErrContentRejected is returned by VerifyUpload when the stored content violates the content type policy
+var ErrContentRejected = errors.New("content rejected")
+
+// VerifyUpload sniffs the first bytes of an artifact uploaded through a presigned URL,
+// records the detected type and the object's size, and enforces the policy of its
+// project and upload token. The size requested with the URL is only what the client
+// declared; downloads and bundles rely on the recorded one. A rejected artifact is
+// marked REJECTED and its object is deleted.
+func VerifyUpload(ctx context.Context, uuid string) error {
+	var declared string
+	var project, allowedTypes sql.NullString
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT a.content_type, a.project, t.allowed_types
+		FROM Artifacts a
+		LEFT JOIN tokens t ON t.token = a.upload_token
+		WHERE a.uuid = ?`, uuid).Scan(&declared, &project, &allowedTypes)
+	if err != nil {
+		return fmt.Errorf("failed to load artifact %s: %w", uuid, err)
+	}
+
+	content, err := LoadContent(ctx, uuid)
+	if err != nil {
+		return fmt.Errorf("failed to load artifact %s: %w", uuid, err)
+	}
+	// Presigned uploads are stored as sent, so the object size is the content size
+	info, err := content.Stat(ctx)
+	if err != nil {
+		return err
+	}
+	head, err := content.Head(ctx, contenttype.SniffLen)
+	if err != nil {
+		return err
+	}
+
+	detected := contenttype.Detect(head)
+	if _, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET detected_content_type = ?, size = ?, stored_size = ? WHERE uuid = ?", detected, info.Size, info.Size, uuid); err != nil {
+		return fmt.Errorf("failed to record detected type and size for %s: %w", uuid, err)
+	}
+
+	policyErr := contenttype.CheckUpload(project.String, contenttype.SplitList(allowedTypes.String), declared, detected)
+	if policyErr == nil {
+		return nil
+	}
+
+	// Reject: keep the row for auditing, drop the content
+	if _, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET status = 'REJECTED' WHERE uuid = ?", uuid); err != nil {
+		return fmt.Errorf("failed to mark %s as REJECTED: %w", uuid, err)
+	}
+	metrics.RecordTransition("REJECTED")
+	if err := storage.DeleteFile(ctx, uuid); err != nil {
+		log.Printf("Failed to delete rejected artifact %s from storage: %v", uuid, err)
+	}
+	log.Printf("Artifact %s rejected: %v", uuid, policyErr)
+	logger.Record(logger.ActionUpload, uuid, "", "", "FAILED", "Rejected: "+policyErr.Error())
+
+	return fmt.Errorf("%w: %v", ErrContentRejected, policyErr)
+}
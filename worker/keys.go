@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"ArtifactService/db"
+	"ArtifactService/encryption"
+	"ArtifactService/storage"
+)
+
+// LoadContent returns how an artifact's object is stored, with its data key unwrapped.
+// sql.ErrNoRows is returned as is for unknown artifacts.
+func LoadContent(ctx context.Context, uuid string) (*storage.Content, error) {
+	var encoding, mode, keyID, wrapped sql.NullString
+	var storedSize sql.NullInt64
+	err := db.DB.QueryRowContext(ctx, "SELECT content_encoding, stored_size, encryption, key_id, wrapped_key FROM Artifacts WHERE uuid = ?", uuid).
+		Scan(&encoding, &storedSize, &mode, &keyID, &wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	content := &storage.Content{UUID: uuid, Encoding: encoding.String, StoredSize: storedSize.Int64}
+	if mode.String == "" {
+		return content, nil
+	}
+	key, err := encryption.UnwrapKey(ctx, keyID.String, wrapped.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of %s: %w", uuid, err)
+	}
+	switch mode.String {
+	case encryption.ModeEnvelope:
+		content.DataKey = key
+	case encryption.ModeSSEC:
+		content.CustomerKey = key
+	default:
+		return nil, fmt.Errorf("artifact %s has unknown encryption %q", uuid, mode.String)
+	}
+	return content, nil
+}
+
+// RotateKeys rewraps every data key that isn't wrapped with the current master key.
+// The content itself isn't touched. It returns how many keys were rewrapped; failures
+// are logged and counted, and don't stop the run.
+func RotateKeys(ctx context.Context) (rotated, failed int, err error) {
+	provider := encryption.Get()
+	if provider == nil {
+		return 0, 0, encryption.ErrDisabled
+	}
+	current := provider.CurrentKeyID()
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT uuid, key_id, wrapped_key FROM Artifacts WHERE wrapped_key IS NOT NULL AND key_id != ?", current)
+	if err != nil {
+		return 0, 0, err
+	}
+	type wrappedKey struct{ uuid, keyID, wrapped string }
+	var keys []wrappedKey
+	for rows.Next() {
+		var k wrappedKey
+		if err := rows.Scan(&k.uuid, &k.keyID, &k.wrapped); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, k := range keys {
+		keyID, wrapped, changed, err := encryption.RewrapKey(ctx, k.keyID, k.wrapped)
+		if err != nil {
+			log.Printf("Failed to rewrap data key of %s (key %s): %v", k.uuid, k.keyID, err)
+			failed++
+			continue
+		}
+		if !changed {
+			continue
+		}
+		// Only replace the key we unwrapped, in case it changed meanwhile
+		_, err = db.DB.ExecContext(ctx, "UPDATE Artifacts SET key_id = ?, wrapped_key = ? WHERE uuid = ? AND key_id = ?", keyID, wrapped, k.uuid, k.keyID)
+		if err != nil {
+			log.Printf("Failed to store rewrapped data key of %s: %v", k.uuid, err)
+			failed++
+			continue
+		}
+		rotated++
+	}
+	return rotated, failed, nil
+}
@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/storage"
+)
+
+// ErrLocked is returned for deleting or overwriting an artifact under a legal hold or
+// retention period
+var ErrLocked = errors.New("artifact is locked")
+
+// Lock is the legal hold and retention period of an artifact
+type Lock struct {
+	LegalHold   bool
+	RetainUntil *time.Time
+}
+
+// Active reports whether the lock protects the artifact at t
+func (l Lock) Active(t time.Time) bool {
+	return l.LegalHold || (l.RetainUntil != nil && t.Before(*l.RetainUntil))
+}
+
+// CheckLock returns the lock of an artifact, with ErrLocked while it is active.
+// Everything that deletes or overwrites an artifact's object checks it in the
+// transaction that makes the change. sql.ErrNoRows is returned for unknown artifacts.
+func CheckLock(ctx context.Context, q db.Queryer, uuid string) (Lock, error) {
+	var lock Lock
+	var retainUntil sql.NullTime
+	if err := q.QueryRowContext(ctx, "SELECT legal_hold, retain_until FROM Artifacts WHERE uuid = ?", uuid).Scan(&lock.LegalHold, &retainUntil); err != nil {
+		return lock, err
+	}
+	if retainUntil.Valid {
+		lock.RetainUntil = &retainUntil.Time
+	}
+	if lock.Active(time.Now()) {
+		return lock, ErrLocked
+	}
+	return lock, nil
+}
+
+// Reasons a lock change is refused
+var (
+	ErrNoContent        = errors.New("artifact has no content to lock")
+	ErrAdminRequired    = errors.New("an admin key is required")
+	ErrComplianceLocked = errors.New("retention in COMPLIANCE mode can't be shortened")
+	ErrObjectLock       = errors.New("failed to apply the object lock")
+	ErrLockChanged      = errors.New("the lock was changed concurrently")
+)
+
+// lockAttempts is how often SetRetention tries before giving up on concurrent changes
+const lockAttempts = 3
+
+// noContentStatuses are the statuses of artifacts without content that could be locked
+var noContentStatuses = map[string]bool{"UPLOADING": true, "PENDING": true, "EXPIRED": true, "REJECTED": true}
+
+// Lockable reports whether an artifact with status has content that can be locked
+func Lockable(status string) bool {
+	return !noContentStatuses[status]
+}
+
+// SetRetention sets the retention period of an artifact, or clears it if until is nil,
+// and returns the lock it had before. Shortening a running period needs admin and is
+// impossible once storage enforces it in COMPLIANCE mode. With storage.object_lock the
+// object gets the retention first, so the database never claims one storage doesn't have.
+// The database is only updated if the period is still the one checked; after
+// lockAttempts concurrent changes ErrLockChanged is returned.
+func SetRetention(ctx context.Context, uuid string, until *time.Time, admin bool) (Lock, error) {
+	for attempt := 1; ; attempt++ {
+		lock, err := setRetention(ctx, uuid, until, admin)
+		if !errors.Is(err, ErrLockChanged) {
+			return lock, err
+		}
+		if attempt == lockAttempts {
+			SyncObjectLock(ctx, uuid)
+			return lock, err
+		}
+	}
+}
+
+// setRetention makes one attempt of SetRetention
+func setRetention(ctx context.Context, uuid string, until *time.Time, admin bool) (Lock, error) {
+	var lock Lock
+	var status sql.NullString
+	var retainUntil sql.NullTime
+	// The stored text of the period guards the update: a time read and bound again
+	// needn't be stored the same way
+	var storedRetainUntil sql.NullString
+	err := db.DB.QueryRowContext(ctx, "SELECT status, legal_hold, retain_until, CAST(retain_until AS TEXT) FROM Artifacts WHERE uuid = ?", uuid).
+		Scan(&status, &lock.LegalHold, &retainUntil, &storedRetainUntil)
+	if err != nil {
+		return lock, err
+	}
+	if retainUntil.Valid {
+		lock.RetainUntil = &retainUntil.Time
+	}
+	if !Lockable(status.String) {
+		return lock, ErrNoContent
+	}
+
+	lockCfg := config.Get().Storage.ObjectLock
+	running := lock.RetainUntil != nil && lock.RetainUntil.After(time.Now())
+	if running && (until == nil || until.Before(*lock.RetainUntil)) {
+		if !admin {
+			return lock, ErrAdminRequired
+		}
+		if lockCfg.Enabled && lockCfg.Mode == "COMPLIANCE" {
+			return lock, ErrComplianceLocked
+		}
+	}
+
+	column := sql.NullTime{}
+	if until != nil {
+		column = sql.NullTime{Time: until.UTC(), Valid: true}
+	}
+	if lockCfg.Enabled {
+		if err := storage.SetRetention(ctx, uuid, lockCfg.Mode, column.Time); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return lock, fmt.Errorf("%w: %v", ErrObjectLock, err)
+		}
+	}
+	res, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET retain_until = ? WHERE uuid = ? AND retain_until IS ?", column, uuid, storedRetainUntil)
+	if err != nil {
+		return lock, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return lock, ErrLockChanged
+	}
+	return lock, nil
+}
+
+// SyncObjectLock gives the object of an artifact the legal hold and retention period
+// the database has, after a lock change lost against a concurrent one and may have
+// left storage with its own. Failures are only logged.
+func SyncObjectLock(ctx context.Context, uuid string) {
+	lockCfg := config.Get().Storage.ObjectLock
+	if !lockCfg.Enabled {
+		return
+	}
+	var legalHold bool
+	var retainUntil sql.NullTime
+	if err := db.DB.QueryRowContext(ctx, "SELECT legal_hold, retain_until FROM Artifacts WHERE uuid = ?", uuid).Scan(&legalHold, &retainUntil); err != nil {
+		log.Printf("Failed to load the lock of %s: %v", uuid, err)
+		return
+	}
+	if err := storage.SetLegalHold(ctx, uuid, legalHold); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to restore the legal hold of %s in storage: %v", uuid, err)
+	}
+	if err := storage.SetRetention(ctx, uuid, lockCfg.Mode, retainUntil.Time); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to restore the retention of %s in storage: %v", uuid, err)
+	}
+}
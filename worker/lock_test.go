@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ArtifactService/db"
+)
+
+func TestSetRetention(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	uuids := addVersions(t, "proj/locked", 0, []testVersion{
+		{status: "UPLOADED"},
+		{status: "UPLOADED", retain: time.Now().Add(time.Hour)},
+		{status: "PENDING"},
+	})
+
+	retainUntil := func(uuid string) *time.Time {
+		t.Helper()
+		lock, err := CheckLock(ctx, db.DB, uuid)
+		if err != nil && !errors.Is(err, ErrLocked) {
+			t.Fatal(err)
+		}
+		return lock.RetainUntil
+	}
+	at := func(d time.Duration) *time.Time {
+		until := time.Now().Add(d).Truncate(time.Second)
+		return &until
+	}
+
+	tests := []struct {
+		name  string
+		uuid  string
+		until *time.Time
+		admin bool
+		err   error
+	}{
+		{"set", uuids[0], at(time.Hour), false, nil},
+		// The period written by the first step must match in the conditional update
+		{"extend", uuids[0], at(2 * time.Hour), false, nil},
+		{"shorten", uuids[0], at(time.Minute), false, ErrAdminRequired},
+		{"clear", uuids[0], nil, false, ErrAdminRequired},
+		{"admin clears", uuids[0], nil, true, nil},
+		{"extend existing", uuids[1], at(3 * time.Hour), false, nil},
+		{"no content", uuids[2], at(time.Hour), false, ErrNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := retainUntil(tt.uuid)
+			if _, err := SetRetention(ctx, tt.uuid, tt.until, tt.admin); !errors.Is(err, tt.err) {
+				t.Fatalf("SetRetention: got %v, want %v", err, tt.err)
+			}
+			want := tt.until
+			if tt.err != nil {
+				want = before
+			}
+			got := retainUntil(tt.uuid)
+			if (got == nil) != (want == nil) || (got != nil && !got.Equal(*want)) {
+				t.Errorf("retain_until %v, want %v", got, want)
+			}
+		})
+	}
+}
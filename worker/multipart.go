@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+)
+
+// multipartCleanInterval is how often expired multipart uploads are looked for
+const multipartCleanInterval = time.Hour
+
+// MultipartDir returns the directory holding the parts of the S3 gateway's unfinished
+// multipart uploads, one subdirectory per upload. Parts are only found by the instances
+// that see this directory, so replicas must share it.
+func MultipartDir() string {
+	if dir := config.Get().S3Gateway.MultipartDir; dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "artifact-service-multipart")
+}
+
+// PartPath returns the file of one part of a multipart upload
+func PartPath(uploadID string, partNumber int) string {
+	return filepath.Join(MultipartDir(), uploadID, strconv.Itoa(partNumber))
+}
+
+// RemoveMultipartUpload forgets a multipart upload and removes its parts
+func RemoveMultipartUpload(ctx context.Context, uploadID string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM s3_multipart_parts WHERE upload_id = ?", uploadID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM s3_multipart_uploads WHERE upload_id = ?", uploadID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(MultipartDir(), uploadID))
+}
+
+// StartMultipartCleaner removes multipart uploads that weren't completed or aborted
+// within s3_gateway.multipart_expiry, and part directories no upload refers to, every
+// hour. It stops when ctx is cancelled.
+func StartMultipartCleaner(ctx context.Context) {
+	log.Println("Starting Multipart Cleaner")
+	timer := time.NewTimer(0)
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Multipart Cleaner stopped")
+				return
+			case <-timer.C:
+				removeExpiredMultipartUploads(ctx)
+				timer.Reset(multipartCleanInterval)
+			}
+		}
+	}()
+}
+
+func removeExpiredMultipartUploads(ctx context.Context) {
+	cutoff := time.Now().UTC().Add(-config.Get().S3Gateway.MultipartExpiry.Std())
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT upload_id, created_at FROM s3_multipart_uploads")
+	if err != nil {
+		log.Println("Worker: Failed to query multipart uploads:", err)
+		return
+	}
+	known := map[string]bool{}
+	var expired []string
+	for rows.Next() {
+		var uploadID string
+		var createdAt sql.NullTime
+		if err := rows.Scan(&uploadID, &createdAt); err != nil {
+			log.Println("Worker: Failed to scan row:", err)
+			continue
+		}
+		known[uploadID] = true
+		if !createdAt.Valid || createdAt.Time.Before(cutoff) {
+			expired = append(expired, uploadID)
+		}
+	}
+	rows.Close()
+
+	for _, uploadID := range expired {
+		if err := RemoveMultipartUpload(ctx, uploadID); err != nil {
+			log.Printf("Worker: Failed to remove expired multipart upload %s: %v", uploadID, err)
+			continue
+		}
+		log.Printf("Worker: Removed expired multipart upload %s", uploadID)
+	}
+
+	// Parts of uploads whose row is gone, e.g. after a crash while completing; uploads
+	// created since the query above are left for the next run
+	entries, err := os.ReadDir(MultipartDir())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Worker: Failed to read multipart directory:", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || known[entry.Name()] || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(MultipartDir(), entry.Name())); err != nil {
+			log.Printf("Worker: Failed to remove orphan multipart parts %s: %v", entry.Name(), err)
+		}
+	}
+}
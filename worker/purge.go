@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/storage"
+)
+
+// ErrNotDeleted is returned by PurgeArtifact for an artifact that isn't DELETED (any more)
+var ErrNotDeleted = errors.New("artifact is not deleted")
+
+// AnonymousActor is recorded as deleted_by for deletes no key identifies
+const AnonymousActor = "anonymous"
+
+// DeletedBy is who a delete by actor is recorded as: the actor, such as
+// "admin:<fingerprint>", or AnonymousActor without one
+func DeletedBy(actor string) string {
+	if actor == "" {
+		return AnonymousActor
+	}
+	return actor
+}
+
+// TrashArtifact moves an artifact to the trash: it becomes DELETED with the time and who
+// deleted it (see DeletedBy), and its tokens are revoked in the same transaction. Locked
+// artifacts are refused with ErrLocked and their lock; unknown and already deleted
+// artifacts with sql.ErrNoRows.
+func TrashArtifact(ctx context.Context, uuid, deletedBy string) (time.Time, Lock, error) {
+	deletedAt := time.Now().UTC()
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return deletedAt, Lock{}, err
+	}
+	defer tx.Rollback()
+
+	lock, err := CheckLock(ctx, tx, uuid)
+	if err != nil {
+		return deletedAt, lock, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE Artifacts
+		SET deleted_status = status, status = 'DELETED', deleted_at = ?, deleted_by = ?
+		WHERE uuid = ? AND IFNULL(status, '') NOT IN ('DELETED', 'UPLOADING')`,
+		deletedAt, deletedBy, uuid)
+	if err != nil {
+		return deletedAt, lock, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return deletedAt, lock, sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE tokens SET revoked_at = ? WHERE artifact_uuid = ? AND revoked_at IS NULL", deletedAt, uuid); err != nil {
+		return deletedAt, lock, err
+	}
+	if err := tx.Commit(); err != nil {
+		return deletedAt, lock, err
+	}
+	metrics.RecordTransition("DELETED")
+	return deletedAt, lock, nil
+}
+
+// PurgeArtifact removes a DELETED artifact for good: its row and tokens are deleted
+// together with recording a delete intent, then its object is deleted. If storage fails
+// the intent stays and the reconciler finishes the delete; pending reports that case.
+// Locked artifacts stay in the trash (ErrLocked).
+func PurgeArtifact(ctx context.Context, uuid string) (pending bool, err error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := CheckLock(ctx, tx, uuid); err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrNotDeleted
+		}
+		return false, err
+	}
+	// Checked in the transaction, so a concurrent restore wins or loses as a whole
+	res, err := tx.ExecContext(ctx, "DELETE FROM Artifacts WHERE uuid = ? AND status = 'DELETED'", uuid)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, ErrNotDeleted
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tokens WHERE artifact_uuid = ?", uuid); err != nil {
+		return false, err
+	}
+	intentID, err := db.BeginIntent(ctx, tx, db.IntentDelete, uuid)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if err := storage.DeleteFile(ctx, uuid); err != nil {
+		log.Printf("Failed to delete file %s from storage, left to the reconciler: %v", uuid, err)
+		return true, nil
+	}
+	if err := db.EndIntent(ctx, db.DB, intentID); err != nil {
+		log.Printf("Failed to end delete intent of %s: %v", uuid, err)
+	}
+	return false, nil
+}
+
+// StartPurgeWorker removes DELETED artifacts once delete.grace_period has passed, every
+// worker.purge_interval. It stops when ctx is cancelled.
+func StartPurgeWorker(ctx context.Context) {
+	log.Printf("Starting Purge Worker with interval %v", config.Get().Worker.PurgeInterval.Std())
+	timer := time.NewTimer(0)
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Purge Worker stopped")
+				return
+			case <-timer.C:
+				purgeExpired(ctx)
+				timer.Reset(config.Get().Worker.PurgeInterval.Std())
+			}
+		}
+	}()
+}
+
+func purgeExpired(ctx context.Context) {
+	cutoff := time.Now().Add(-config.Get().Delete.GracePeriod.Std())
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT uuid, deleted_at FROM Artifacts WHERE status = 'DELETED'")
+	if err != nil {
+		log.Println("Worker: Failed to query deleted artifacts:", err)
+		return
+	}
+	var expired []string
+	for rows.Next() {
+		var uuid string
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&uuid, &deletedAt); err != nil {
+			log.Println("Worker: Failed to scan row:", err)
+			continue
+		}
+		// Artifacts deleted without a timestamp can't be restored anyway
+		if !deletedAt.Valid || deletedAt.Time.Before(cutoff) {
+			expired = append(expired, uuid)
+		}
+	}
+	rows.Close()
+
+	for _, uuid := range expired {
+		if ctx.Err() != nil {
+			return
+		}
+		_, err := PurgeArtifact(ctx, uuid)
+		if errors.Is(err, ErrNotDeleted) {
+			// Restored since the query
+			continue
+		}
+		if errors.Is(err, ErrLocked) {
+			log.Printf("Worker: Artifact %s is locked, kept in the trash", uuid)
+			continue
+		}
+		if err != nil {
+			log.Printf("Worker: Failed to purge %s: %v", uuid, err)
+			continue
+		}
+		log.Printf("Worker: Artifact %s purged from the trash", uuid)
+		metrics.RecordTransition("PURGED")
+		logger.Record(logger.ActionDelete, uuid, "", "", "SUCCESS", "Purged after the delete grace period")
+	}
+}
@@ -0,0 +1,296 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/storage"
+	"ArtifactService/tracing"
+)
+
+// contentStatuses are the statuses of artifacts whose content must be in storage
+var contentStatuses = map[string]bool{"UPLOADED": true, "SCANNING": true, "QUARANTINED": true}
+
+// ReconcileReport is the outcome of a Reconcile run
+type ReconcileReport struct {
+	Objects        int      // objects in the bucket
+	Artifacts      int      // artifacts in the database
+	OrphanObjects  []string // objects without an artifact
+	MissingContent []string // artifacts that should have content but have no object
+	Reappeared     []string // MISSING artifacts whose object is back
+	Fixed          bool     // whether the findings were fixed (worker.reconcile_fix)
+}
+
+// StartReconciler finishes the uploads and deletes interrupted by the last shutdown, then
+// compares the bucket with the database at startup and every worker.reconcile_interval
+// (only at startup while it is 0). It stops when ctx is cancelled.
+func StartReconciler(ctx context.Context) {
+	started := time.Now()
+	log.Printf("Starting Reconciler with interval %v", config.Get().Worker.ReconcileInterval.Std())
+	timer := time.NewTimer(0)
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer timer.Stop()
+
+		// Intents from before this process started can't belong to a running request
+		if _, err := RecoverIntents(ctx, started); err != nil {
+			log.Println("Reconciler: Failed to recover intents:", err)
+		}
+
+		ran := false
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Reconciler stopped")
+				return
+			case <-timer.C:
+			}
+
+			// With worker.reconcile_interval 0 only the startup run happens, but a reload
+			// may set an interval
+			cfg := config.Get().Worker
+			if ran && cfg.ReconcileInterval <= 0 {
+				timer.Reset(time.Minute)
+				continue
+			}
+			if _, err := RecoverIntents(ctx, time.Now().Add(-cfg.IntentTimeout.Std())); err != nil {
+				log.Println("Reconciler: Failed to recover intents:", err)
+			}
+			if _, err := Reconcile(ctx, cfg.ReconcileFix); err != nil {
+				log.Println("Reconciler: Run failed:", err)
+			}
+			ran = true
+
+			if interval := config.Get().Worker.ReconcileInterval.Std(); interval > 0 {
+				timer.Reset(interval)
+			} else {
+				timer.Reset(time.Minute)
+			}
+		}
+	}()
+}
+
+// RecoverIntents finishes the operations whose intents were recorded before cutoff:
+// interrupted uploads are rolled back (object and reservation removed), interrupted
+// deletes are completed. It returns how many intents it resolved.
+func RecoverIntents(ctx context.Context, cutoff time.Time) (int, error) {
+	intents, err := db.ListIntents(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, intent := range intents {
+		if !intent.CreatedAt.Before(cutoff) {
+			continue
+		}
+		if err := recoverIntent(ctx, intent); err != nil {
+			log.Printf("Reconciler: Failed to recover %s of %s: %v", intent.Operation, intent.ArtifactUUID, err)
+			continue
+		}
+		metrics.RecordIntentRecovered(intent.Operation)
+		recovered++
+	}
+	return recovered, nil
+}
+
+func recoverIntent(ctx context.Context, intent db.Intent) error {
+	switch intent.Operation {
+	case db.IntentUpload:
+		var status string
+		err := db.DB.QueryRowContext(ctx, "SELECT status FROM Artifacts WHERE uuid = ?", intent.ArtifactUUID).Scan(&status)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && status != "UPLOADING" {
+			// The metadata made it, only the intent was left behind
+			return db.EndIntent(ctx, db.DB, intent.ID)
+		}
+
+		// The upload never completed: drop whatever reached storage and the reservation
+		if err := storage.DeleteFile(ctx, intent.ArtifactUUID); err != nil {
+			return err
+		}
+		tx, err := db.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, "DELETE FROM Artifacts WHERE uuid = ? AND status = 'UPLOADING'", intent.ArtifactUUID); err != nil {
+			return err
+		}
+		if err := db.EndIntent(ctx, tx, intent.ID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Reconciler: Rolled back interrupted upload of %s", intent.ArtifactUUID)
+		logger.Record(logger.ActionUpload, intent.ArtifactUUID, "", "", "FAILED", "Interrupted upload rolled back by the reconciler")
+
+	case db.IntentDelete:
+		// The artifact is gone from the database, finish removing its content
+		if err := storage.DeleteFile(ctx, intent.ArtifactUUID); err != nil {
+			return err
+		}
+		if err := db.EndIntent(ctx, db.DB, intent.ID); err != nil {
+			return err
+		}
+		log.Printf("Reconciler: Completed interrupted delete of %s", intent.ArtifactUUID)
+		logger.Record(logger.ActionDelete, intent.ArtifactUUID, "", "", "SUCCESS", "Interrupted delete completed by the reconciler")
+
+	default:
+		return fmt.Errorf("unknown operation %q", intent.Operation)
+	}
+	return nil
+}
+
+// Reconcile compares the objects in the bucket with the artifacts in the database and
+// reports objects without an artifact and artifacts without their object. With fix,
+// orphan objects are deleted, artifacts without content are marked MISSING and MISSING
+// artifacts whose object is back are restored.
+func Reconcile(ctx context.Context, fix bool) (*ReconcileReport, error) {
+	ctx, span := tracing.Start(ctx, "ArtifactService/worker", "worker.reconcile")
+	var runErr error
+	defer func() { tracing.End(span, runErr) }()
+
+	report := &ReconcileReport{Fixed: fix}
+
+	// 1. List the bucket first: whatever is uploaded afterwards has an intent or a row by
+	// the time the database is read
+	objects := map[string]bool{}
+	runErr = storage.ListFiles(ctx, func(key string, info storage.ObjectInfo) error {
+		objects[key] = true
+		return nil
+	})
+	if runErr != nil {
+		return nil, runErr
+	}
+	report.Objects = len(objects)
+
+	// 2. Intents before artifacts: an upload ends its intent in the transaction that
+	// records the artifact, so it shows up in at least one of them
+	intents, err := db.ListIntents(ctx)
+	if err != nil {
+		runErr = err
+		return nil, err
+	}
+	inFlight := map[string]bool{}
+	for _, intent := range intents {
+		inFlight[intent.ArtifactUUID] = true
+	}
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT uuid, status FROM Artifacts")
+	if err != nil {
+		runErr = err
+		return nil, err
+	}
+	statuses := map[string]string{}
+	for rows.Next() {
+		var uuid string
+		var status sql.NullString
+		if err := rows.Scan(&uuid, &status); err != nil {
+			rows.Close()
+			runErr = err
+			return nil, err
+		}
+		statuses[uuid] = status.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		runErr = err
+		return nil, err
+	}
+	report.Artifacts = len(statuses)
+
+	// 3. Objects without an artifact
+	for key := range objects {
+		if _, ok := statuses[key]; ok || inFlight[key] {
+			continue
+		}
+		report.OrphanObjects = append(report.OrphanObjects, key)
+		log.Printf("Reconciler: Object %s has no artifact", key)
+		if fix {
+			fixOrphanObject(ctx, key)
+		}
+	}
+
+	// 4. Artifacts without their object. Uploads that finished after the listing look
+	// the same, so every candidate is checked again before it is reported.
+	for uuid, status := range statuses {
+		switch {
+		case contentStatuses[status] && !objects[uuid] && !inFlight[uuid]:
+			exists, err := contentExists(ctx, uuid)
+			if err != nil {
+				log.Printf("Reconciler: Failed to check %s: %v", uuid, err)
+				continue
+			}
+			if exists {
+				continue
+			}
+			report.MissingContent = append(report.MissingContent, uuid)
+			log.Printf("Reconciler: Artifact %s (%s) has no content in storage", uuid, status)
+			if fix {
+				if _, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET status = 'MISSING' WHERE uuid = ? AND status = ?", uuid, status); err != nil {
+					log.Printf("Reconciler: Failed to mark %s as MISSING: %v", uuid, err)
+				} else {
+					metrics.RecordTransition("MISSING")
+				}
+			}
+		case status == "MISSING" && objects[uuid]:
+			report.Reappeared = append(report.Reappeared, uuid)
+			log.Printf("Reconciler: Content of MISSING artifact %s is back in storage", uuid)
+			if fix {
+				if newStatus, err := MarkStored(ctx, uuid); err != nil {
+					log.Printf("Reconciler: Failed to restore %s: %v", uuid, err)
+				} else {
+					log.Printf("Reconciler: Artifact %s restored as %s", uuid, newStatus)
+				}
+			}
+		}
+	}
+
+	sort.Strings(report.OrphanObjects)
+	sort.Strings(report.MissingContent)
+	sort.Strings(report.Reappeared)
+	metrics.RecordReconcile(len(report.OrphanObjects), len(report.MissingContent))
+	log.Printf("Reconciler: %d objects, %d artifacts, %d orphan objects, %d artifacts without content, %d reappeared (fix=%t)",
+		report.Objects, report.Artifacts, len(report.OrphanObjects), len(report.MissingContent), len(report.Reappeared), fix)
+	return report, nil
+}
+
+// fixOrphanObject deletes an object without an artifact, unless an artifact or an upload
+// for it appeared since the database was read
+func fixOrphanObject(ctx context.Context, key string) {
+	var exists bool
+	if err := db.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM Artifacts WHERE uuid = ?)", key).Scan(&exists); err != nil || exists {
+		return
+	}
+	if inFlight, err := db.HasIntent(ctx, key); err != nil || inFlight {
+		return
+	}
+	if err := storage.DeleteFile(ctx, key); err != nil {
+		log.Printf("Reconciler: Failed to delete orphan object %s: %v", key, err)
+		return
+	}
+	logger.Record(logger.ActionDelete, key, "", "", "SUCCESS", "Orphan object deleted by the reconciler")
+}
+
+// contentExists checks a single artifact's object, with its SSE-C key if it has one
+func contentExists(ctx context.Context, uuid string) (bool, error) {
+	content, err := LoadContent(ctx, uuid)
+	if err != nil {
+		return false, err
+	}
+	return content.Exists(ctx)
+}
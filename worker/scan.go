@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+	"ArtifactService/scanner"
+	"ArtifactService/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// scanRequested wakes the scan worker up when a new artifact enters SCANNING
+var scanRequested = make(chan struct{}, 1)
+
+// StoredStatus is the status of an artifact whose content has just been stored:
+// SCANNING while a scanner is configured, UPLOADED otherwise.
+func StoredStatus() string {
+	if scanner.Enabled() {
+		return "SCANNING"
+	}
+	return "UPLOADED"
+}
+
+// ErrArtifactDeleted is returned by MarkStored for an artifact that was deleted meanwhile
+var ErrArtifactDeleted = errors.New("artifact was deleted")
+
+// MarkStored moves an artifact whose content is in storage to StoredStatus and
+// returns the new status. Artifacts in the trash keep the DELETED status.
+func MarkStored(ctx context.Context, uuid string) (string, error) {
+	status := StoredStatus()
+	res, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET status = ? WHERE uuid = ? AND IFNULL(status, '') != 'DELETED'", status, uuid)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrArtifactDeleted
+	}
+	metrics.RecordTransition(status)
+	if status == "SCANNING" {
+		NotifyScan()
+	}
+	return status, nil
+}
+
+// NotifyScan makes the scan worker pick up SCANNING artifacts without waiting for its interval
+func NotifyScan() {
+	select {
+	case scanRequested <- struct{}{}:
+	default:
+	}
+}
+
+// StartScanWorker scans SCANNING artifacts when notified and every worker.scan_interval,
+// so artifacts whose scan failed are retried. It stops when ctx is cancelled.
+func StartScanWorker(ctx context.Context) {
+	log.Printf("Starting Scan Worker with interval %v", config.Get().Worker.ScanInterval.Std())
+	timer := time.NewTimer(0)
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Scan Worker stopped")
+				return
+			case <-scanRequested:
+			case <-timer.C:
+				timer.Reset(config.Get().Worker.ScanInterval.Std())
+			}
+			scanPendingArtifacts(ctx)
+		}
+	}()
+}
+
+func scanPendingArtifacts(ctx context.Context) {
+	s := scanner.Get()
+	if s == nil {
+		// Scanning was disabled by a config reload, release what was waiting for it
+		if _, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET status = 'UPLOADED' WHERE status = 'SCANNING'"); err != nil {
+			log.Println("Worker: Failed to release SCANNING artifacts:", err)
+		}
+		return
+	}
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT uuid FROM Artifacts WHERE status = 'SCANNING' ORDER BY created_at")
+	if err != nil {
+		log.Println("Worker: Failed to query artifacts to scan:", err)
+		return
+	}
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			log.Println("Worker: Failed to scan row:", err)
+			continue
+		}
+		uuids = append(uuids, uuid)
+	}
+	rows.Close()
+
+	for _, uuid := range uuids {
+		if ctx.Err() != nil {
+			return
+		}
+		scanArtifact(ctx, s, uuid)
+	}
+}
+
+// scanArtifact streams one artifact through the scanner and records the verdict.
+// Infected artifacts are QUARANTINED (content kept for investigation, downloads blocked);
+// on scanner errors the artifact stays SCANNING and is retried on the next run.
+func scanArtifact(ctx context.Context, s scanner.Scanner, uuid string) {
+	ctx, span := tracing.Start(ctx, "ArtifactService/worker", "worker.scan")
+	var scanErr error
+	defer func() { tracing.End(span, scanErr) }()
+	span.SetAttributes(attribute.String("artifact.uuid", uuid), attribute.String("scanner", s.Name()))
+
+	// The timeout covers the download and the scan, not recording the result
+	scanCtx, cancel := context.WithTimeout(ctx, config.Get().Scanner.Timeout.Std())
+	defer cancel()
+
+	start := time.Now()
+	// Scanners need the content as uploaded, not as compressed or encrypted at rest
+	var result scanner.Result
+	stored, err := LoadContent(ctx, uuid)
+	if err == nil {
+		var content io.ReadCloser
+		content, err = stored.Open(scanCtx)
+		if err == nil {
+			result, err = s.Scan(scanCtx, content)
+			content.Close()
+		}
+	}
+
+	if err != nil {
+		scanErr = err
+		metrics.RecordScan("error", start)
+		log.Printf("Worker: Scan of %s failed, will retry: %v", uuid, err)
+		if _, dbErr := db.DB.ExecContext(ctx, "UPDATE Artifacts SET scan_result = 'error', scan_signature = NULL, scanned_at = ? WHERE uuid = ?", time.Now(), uuid); dbErr != nil {
+			log.Printf("Worker: Failed to record scan error for %s: %v", uuid, dbErr)
+		}
+		logger.Record(logger.ActionScan, uuid, "", "", "FAILED", s.Name()+": "+err.Error())
+		return
+	}
+
+	status, scanResult, details := "UPLOADED", "clean", s.Name()+": clean"
+	if !result.Clean {
+		status, scanResult, details = "QUARANTINED", "infected", s.Name()+": "+result.Signature
+	}
+	metrics.RecordScan(scanResult, start)
+	span.SetAttributes(attribute.String("scan.result", scanResult))
+
+	// Only move artifacts that are still SCANNING, e.g. not ones deleted meanwhile
+	_, err = db.DB.ExecContext(ctx, `
+		UPDATE Artifacts SET status = ?, scan_result = ?, scan_signature = ?, scanned_at = ?
+		WHERE uuid = ? AND status = 'SCANNING'`,
+		status, scanResult, result.Signature, time.Now(), uuid)
+	if err != nil {
+		log.Printf("Worker: Failed to record scan result for %s: %v", uuid, err)
+		return
+	}
+	metrics.RecordTransition(status)
+
+	if result.Clean {
+		log.Printf("Worker: Artifact %s scanned clean", uuid)
+		logger.Record(logger.ActionScan, uuid, "", "", "SUCCESS", details)
+	} else {
+		log.Printf("Worker: Artifact %s QUARANTINED (%s)", uuid, result.Signature)
+		logger.Record(logger.ActionScan, uuid, "", "", "QUARANTINED", details)
+	}
+}
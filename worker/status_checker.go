@@ -1,38 +1,106 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"ArtifactService/config"
 	"ArtifactService/db"
-	"ArtifactService/storage"
+	"ArtifactService/metrics"
+	"ArtifactService/tracing"
 )
 
-// StartStatusChecker starts a background worker that periodically checks the status of pending artifacts
-func StartStatusChecker(interval time.Duration) {
+var (
+	// running tracks worker goroutines so shutdown can wait for them
+	running sync.WaitGroup
+
+	// lastSuccessfulRun holds the UnixNano time of the last run that completed without errors
+	lastSuccessfulRun atomic.Int64
+	checkInterval     atomic.Int64
+
+	// intervalChanged carries a new interval to the running status checker
+	intervalChanged = make(chan time.Duration, 1)
+)
+
+// StatusCheckerFreshness reports when the status checker last completed a run without
+// errors (zero if it never has) and the interval it is configured to run at.
+func StatusCheckerFreshness() (time.Time, time.Duration) {
+	var last time.Time
+	if ns := lastSuccessfulRun.Load(); ns != 0 {
+		last = time.Unix(0, ns)
+	}
+	return last, time.Duration(checkInterval.Load())
+}
+
+// StartStatusChecker starts a background worker that periodically checks the status of pending artifacts.
+// The worker stops when ctx is cancelled; use Wait to block until it has exited.
+func StartStatusChecker(ctx context.Context, interval time.Duration) {
 	log.Printf("Starting Status Check Worker with interval %v", interval)
+	checkInterval.Store(int64(interval))
 	ticker := time.NewTicker(interval)
-	
-	// Run immediately on start
-	go checkPendingArtifacts()
 
+	running.Add(1)
 	go func() {
-		for range ticker.C {
-			checkPendingArtifacts()
+		defer running.Done()
+		defer ticker.Stop()
+
+		// Run immediately on start
+		checkPendingArtifacts(ctx)
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Status Check Worker stopped")
+				return
+			case interval := <-intervalChanged:
+				ticker.Reset(interval)
+			case <-ticker.C:
+				checkPendingArtifacts(ctx)
+			}
 		}
 	}()
 }
 
-func checkPendingArtifacts() {
+// SetStatusCheckInterval changes the interval of the running status checker.
+// The next run happens one new interval from now.
+func SetStatusCheckInterval(interval time.Duration) {
+	checkInterval.Store(int64(interval))
+	log.Printf("Status Check Worker interval set to %v", interval)
+
+	// Only the latest interval matters, replace one the worker hasn't picked up yet
+	select {
+	case <-intervalChanged:
+	default:
+	}
+	intervalChanged <- interval
+}
+
+// Wait blocks until all background workers have exited
+func Wait() {
+	running.Wait()
+}
+
+func checkPendingArtifacts(ctx context.Context) {
+	// Each run is its own trace so slow storage checks show up per run
+	ctx, span := tracing.Start(ctx, "ArtifactService/worker", "worker.status_check")
+	defer span.End()
+
 	// Find artifacts that are PENDING
 	// We assume items created recently might not be uploaded yet, but we check anyway.
-	rows, err := db.DB.Query("SELECT uuid, created_at FROM Artifacts WHERE status = 'PENDING'")
+	rows, err := db.DB.QueryContext(ctx, "SELECT uuid, created_at FROM Artifacts WHERE status = 'PENDING'")
 	if err != nil {
 		log.Println("Worker: Failed to query pending artifacts:", err)
+		metrics.RecordStatusCheck(false)
 		return
 	}
 	defer rows.Close()
 
+	// Storage errors don't abort the run, but the run doesn't count as successful either
+	failed := false
 	for rows.Next() {
 		var uuid string
 		var createdAt time.Time
@@ -41,36 +109,63 @@ func checkPendingArtifacts() {
 			continue
 		}
 
-		// Check if file exists in S3/Ceph
-		exists, err := storage.CheckFileExists(uuid)
+		// Check if file exists in S3/Ceph; SSE-C objects can only be checked with their key
+		content, err := LoadContent(ctx, uuid)
+		if err != nil {
+			log.Printf("Worker: Failed to load %s: %v", uuid, err)
+			failed = true
+			continue
+		}
+		exists, err := content.Exists(ctx)
 		if err != nil {
 			// If generic error (network, auth), log and skip
 			// We don't change status on error
 			log.Printf("Worker: CheckFileExists error for %s: %v", uuid, err)
+			failed = true
 			continue
 		}
 
 		if exists {
-			// File found! Update status to UPLOADED
-			_, err := db.DB.Exec("UPDATE Artifacts SET status = 'UPLOADED' WHERE uuid = ?", uuid)
+			// File found! Check what was actually uploaded before accepting it
+			if err := VerifyUpload(ctx, uuid); err != nil {
+				if !errors.Is(err, ErrContentRejected) {
+					log.Printf("Worker: Failed to verify content of %s: %v", uuid, err)
+					failed = true
+				}
+				continue
+			}
+
+			// Update status to UPLOADED, or SCANNING first if a scanner is configured
+			status, err := MarkStored(ctx, uuid)
 			if err != nil {
 				log.Printf("Worker: Failed to update status for %s: %v", uuid, err)
 			} else {
-				log.Printf("Worker: Artifact %s status updated to UPLOADED", uuid)
+				log.Printf("Worker: Artifact %s status updated to %s", uuid, status)
 			}
 		} else {
 			// File not found. Check if it has been pending for too long.
-			// Default presigned URL expiry is usually around 15 minutes.
-			// We give it a buffer, say 30 minutes.
-			if time.Since(createdAt) > 30*time.Minute {
+			// Default presigned URL expiry is usually around 15 minutes,
+			// worker.pending_expiry (default 30 minutes) gives it a buffer.
+			if time.Since(createdAt) > config.Get().Worker.PendingExpiry.Std() {
 				// Mark as EXPIRED or FAILED
-				_, err := db.DB.Exec("UPDATE Artifacts SET status = 'EXPIRED' WHERE uuid = ?", uuid)
+				_, err := db.DB.ExecContext(ctx, "UPDATE Artifacts SET status = 'EXPIRED' WHERE uuid = ? AND status = 'PENDING'", uuid)
 				if err != nil {
 					log.Printf("Worker: Failed to mark %s as EXPIRED: %v", uuid, err)
 				} else {
 					log.Printf("Worker: Artifact %s marked as EXPIRED (timeout)", uuid)
+					metrics.RecordTransition("EXPIRED")
 				}
 			}
 		}
 	}
+
+	if err := rows.Err(); err != nil {
+		log.Println("Worker: Failed to iterate pending artifacts:", err)
+		metrics.RecordStatusCheck(false)
+		return
+	}
+	metrics.RecordStatusCheck(!failed)
+	if !failed {
+		lastSuccessfulRun.Store(time.Now().UnixNano())
+	}
 }
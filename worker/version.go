@@ -0,0 +1,237 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ArtifactService/config"
+	"ArtifactService/db"
+	"ArtifactService/logger"
+	"ArtifactService/metrics"
+)
+
+// Errors of named versions
+var (
+	ErrVersionNotFound  = errors.New("version not found")
+	ErrAlreadyVersioned = errors.New("artifact is already a version")
+)
+
+// LatestRef is the version reference that resolves to the newest downloadable version
+const LatestRef = "latest"
+
+var (
+	// namePattern is what the project and name parts of a versioned name may look like
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+	// tagPattern starts with a letter, so tags can't be mistaken for version numbers
+	tagPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]{0,127}$`)
+	// pathSegmentPattern is one segment of a repository path; no leading dot rules out
+	// "." and ".." and hidden files
+	pathSegmentPattern = regexp.MustCompile(`^[A-Za-z0-9_@+-][A-Za-z0-9._@+=-]{0,254}$`)
+)
+
+// maxPathLen is the longest repository path
+const maxPathLen = 1024
+
+// VersionName returns the versioned name "project/name", checking both parts
+func VersionName(project, name string) (string, error) {
+	if !namePattern.MatchString(project) || !namePattern.MatchString(name) {
+		return "", errors.New("project and name must be 1-128 letters, digits, '.', '_' or '-', starting with a letter or digit")
+	}
+	return project + "/" + name, nil
+}
+
+// ValidProject checks a project name used as the first part of a versioned name
+func ValidProject(project string) error {
+	if !namePattern.MatchString(project) {
+		return errors.New("project must be 1-128 letters, digits, '.', '_' or '-', starting with a letter or digit")
+	}
+	return nil
+}
+
+// RepoName returns the versioned name "project/path" of a file in the generic
+// repository, e.g. "libs/com/acme/app/1.0/app-1.0.jar", checking every path segment
+func RepoName(project, path string) (string, error) {
+	if err := ValidProject(project); err != nil {
+		return "", err
+	}
+	path = strings.TrimPrefix(path, "/")
+	if path == "" || len(path) > maxPathLen {
+		return "", fmt.Errorf("path must be 1-%d characters", maxPathLen)
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if !pathSegmentPattern.MatchString(segment) {
+			return "", fmt.Errorf("invalid path segment %q: letters, digits and '.', '_', '-', '@', '+', '=', not starting with '.'", segment)
+		}
+	}
+	return project + "/" + path, nil
+}
+
+// ValidTag checks a tag name; "latest" is reserved
+func ValidTag(tag string) error {
+	if !tagPattern.MatchString(tag) || tag == LatestRef {
+		return errors.New(`tag must be 1-128 letters, digits, '.', '_' or '-', starting with a letter, and not "latest"`)
+	}
+	return nil
+}
+
+// AddVersion records an artifact as the next version of name in tx and returns its
+// number. Versions are never reassigned, so a version number always means the same
+// artifact; an artifact can be a version of one name only (ErrAlreadyVersioned).
+func AddVersion(ctx context.Context, tx *sql.Tx, name, artifactUUID string) (int64, error) {
+	var versioned bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM artifact_versions WHERE artifact_uuid = ?)", artifactUUID).Scan(&versioned); err != nil {
+		return 0, err
+	}
+	if versioned {
+		return 0, ErrAlreadyVersioned
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO artifact_names (name, created_at) VALUES (?, ?) ON CONFLICT(name) DO NOTHING", name, now); err != nil {
+		return 0, err
+	}
+	var version int64
+	if err := tx.QueryRowContext(ctx, "SELECT IFNULL(MAX(version), 0) + 1 FROM artifact_versions WHERE name = ?", name).Scan(&version); err != nil {
+		return 0, err
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO artifact_versions (name, version, artifact_uuid, created_at) VALUES (?, ?, ?, ?)",
+		name, version, artifactUUID, now)
+	return version, err
+}
+
+// latestQuery selects the newest version of a name that can be downloaded; versions
+// still being uploaded or scanned, and deleted ones, are passed over
+const latestQuery = `
+	SELECT v.version, v.artifact_uuid
+	FROM artifact_versions v
+	JOIN Artifacts a ON a.uuid = v.artifact_uuid
+	WHERE v.name = ? AND IFNULL(a.status, '') IN ('', 'UPLOADED')
+	ORDER BY v.version DESC LIMIT 1`
+
+// ResolveVersion returns the version a reference points at and its artifact. ref is a
+// version number, "latest" for the newest UPLOADED version, or a tag.
+func ResolveVersion(ctx context.Context, name, ref string) (int64, string, error) {
+	var version int64
+	var artifactUUID string
+	var err error
+	switch number, parseErr := strconv.ParseInt(ref, 10, 64); {
+	case ref == LatestRef:
+		err = db.DB.QueryRowContext(ctx, latestQuery, name).Scan(&version, &artifactUUID)
+	case parseErr == nil:
+		err = db.DB.QueryRowContext(ctx, "SELECT version, artifact_uuid FROM artifact_versions WHERE name = ? AND version = ?", name, number).
+			Scan(&version, &artifactUUID)
+	default:
+		err = db.DB.QueryRowContext(ctx, `
+			SELECT v.version, v.artifact_uuid
+			FROM artifact_tags t
+			JOIN artifact_versions v ON v.name = t.name AND v.version = t.version
+			WHERE t.name = ? AND t.tag = ?`, name, ref).Scan(&version, &artifactUUID)
+	}
+	if err == sql.ErrNoRows {
+		return 0, "", ErrVersionNotFound
+	}
+	return version, artifactUUID, err
+}
+
+// CheckNameLock returns the lock of the latest version of name, with ErrLocked while
+// it is active. Adding a version through a path that replaces what the name serves
+// counts as overwriting that version. A name without versions isn't locked.
+func CheckNameLock(ctx context.Context, q db.Queryer, name string) (Lock, error) {
+	var version int64
+	var artifactUUID string
+	err := q.QueryRowContext(ctx, latestQuery, name).Scan(&version, &artifactUUID)
+	if err == sql.ErrNoRows {
+		return Lock{}, nil
+	}
+	if err != nil {
+		return Lock{}, err
+	}
+	return CheckLock(ctx, q, artifactUUID)
+}
+
+// ApplyVersionRetention moves old versions of name to the trash once it has more
+// downloadable versions than it keeps (artifact_names.keep_versions, else
+// versions.keep; 0 keeps all). The newest kept versions are the downloadable ones;
+// tagged versions, versions newer than the oldest kept one and locked artifacts are
+// never trashed. It returns the UUIDs of the trashed artifacts.
+func ApplyVersionRetention(ctx context.Context, name string) ([]string, error) {
+	var keepVersions sql.NullInt64
+	if err := db.DB.QueryRowContext(ctx, "SELECT keep_versions FROM artifact_names WHERE name = ?", name).Scan(&keepVersions); err != nil {
+		return nil, err
+	}
+	keep := int64(config.Get().Versions.Keep)
+	if keepVersions.Valid {
+		keep = keepVersions.Int64
+	}
+	if keep <= 0 {
+		return nil, nil
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT v.artifact_uuid, IFNULL(a.status, ''),
+		       EXISTS(SELECT 1 FROM artifact_tags t WHERE t.name = v.name AND t.version = v.version)
+		FROM artifact_versions v
+		JOIN Artifacts a ON a.uuid = v.artifact_uuid
+		WHERE v.name = ? AND IFNULL(a.status, '') != 'DELETED'
+		ORDER BY v.version DESC`, name)
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	var kept int64
+	for rows.Next() {
+		var artifactUUID, status string
+		var tagged bool
+		if err := rows.Scan(&artifactUUID, &status, &tagged); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		switch {
+		case tagged:
+		case kept < keep:
+			if status == "" || status == "UPLOADED" {
+				kept++
+			}
+		default:
+			expired = append(expired, artifactUUID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	details := fmt.Sprintf("Version retention of %s keeps %d versions", name, keep)
+	var trashed []string
+	for _, artifactUUID := range expired {
+		_, _, err := TrashArtifact(ctx, artifactUUID, "version-retention")
+		switch {
+		case errors.Is(err, ErrLocked):
+			log.Printf("Version retention: %s of %s is locked, kept", artifactUUID, name)
+			continue
+		case err == sql.ErrNoRows:
+			// Deleted or still uploading in the meantime
+			continue
+		case err != nil:
+			return trashed, err
+		}
+		if config.Get().Delete.GracePeriod <= 0 {
+			// Like a single delete; on failure the purge worker retries
+			if _, err := PurgeArtifact(ctx, artifactUUID); err != nil {
+				log.Printf("Version retention: Failed to purge %s, left to the purge worker: %v", artifactUUID, err)
+			} else {
+				metrics.RecordTransition("PURGED")
+			}
+		}
+		logger.Record(logger.ActionDelete, artifactUUID, "", "", "SUCCESS", details)
+		trashed = append(trashed, artifactUUID)
+	}
+	return trashed, nil
+}
@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"ArtifactService/db"
+)
+
+// openTestDB points db.DB at a fresh database for the test
+func openTestDB(t *testing.T) {
+	t.Helper()
+	db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { db.Close() })
+}
+
+// testVersion is one version of a name created by addVersions
+type testVersion struct {
+	status string
+	tag    string
+	hold   bool      // legal hold
+	retain time.Time // retention period, zero for none
+}
+
+// addVersions creates an artifact for every version of name, oldest first, and
+// returns their UUIDs
+func addVersions(t *testing.T, name string, keep int64, versions []testVersion) []string {
+	t.Helper()
+	ctx := context.Background()
+	var uuids []string
+	for i, v := range versions {
+		artifactUUID := fmt.Sprintf("%s-v%d", name, i+1)
+		retain := sql.NullTime{Time: v.retain, Valid: !v.retain.IsZero()}
+		_, err := db.DB.ExecContext(ctx, "INSERT INTO Artifacts (uuid, filename, content_type, size, status, legal_hold, retain_until) VALUES (?, 'app.jar', 'application/java-archive', 1, ?, ?, ?)",
+			artifactUUID, v.status, v.hold, retain)
+		if err != nil {
+			t.Fatalf("insert artifact: %v", err)
+		}
+
+		tx, err := db.DB.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version, err := AddVersion(ctx, tx, name, artifactUUID)
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("AddVersion: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if v.tag != "" {
+			if _, err := db.DB.ExecContext(ctx, "INSERT INTO artifact_tags (name, tag, version, updated_at) VALUES (?, ?, ?, ?)", name, v.tag, version, time.Now()); err != nil {
+				t.Fatalf("insert tag: %v", err)
+			}
+		}
+		uuids = append(uuids, artifactUUID)
+	}
+	if _, err := db.DB.ExecContext(ctx, "UPDATE artifact_names SET keep_versions = ? WHERE name = ?", keep, name); err != nil {
+		t.Fatal(err)
+	}
+	return uuids
+}
+
+func TestApplyVersionRetention(t *testing.T) {
+	openTestDB(t)
+
+	uploaded := testVersion{status: "UPLOADED"}
+	future := time.Now().Add(24 * time.Hour)
+	tests := []struct {
+		name     string
+		keep     int64
+		versions []testVersion
+		trashed  []int // 1-based version numbers
+	}{
+		{"keeps the newest", 2, []testVersion{uploaded, uploaded, uploaded, uploaded}, []int{1, 2}},
+		{"keep 0 keeps all", 0, []testVersion{uploaded, uploaded, uploaded}, nil},
+		{"fewer than keep", 5, []testVersion{uploaded, uploaded}, nil},
+		{"tagged versions are kept", 1, []testVersion{{status: "UPLOADED", tag: "stable"}, uploaded, uploaded}, []int{2}},
+		{"tagged versions don't count", 2, []testVersion{uploaded, uploaded, {status: "UPLOADED", tag: "rc"}}, nil},
+		{"legal hold is kept", 1, []testVersion{{status: "UPLOADED", hold: true}, uploaded, uploaded}, []int{2}},
+		{"retention period is kept", 1, []testVersion{{status: "UPLOADED", retain: future}, uploaded, uploaded}, []int{2}},
+		{"expired retention period", 1, []testVersion{{status: "UPLOADED", retain: time.Now().Add(-time.Hour)}, uploaded}, []int{1}},
+		// Pending and scanning versions don't count as kept, and as they are newer than
+		// the oldest kept version they aren't trashed either
+		{"pending versions don't count", 2, []testVersion{uploaded, uploaded, uploaded, {status: "PENDING"}, {status: "SCANNING"}}, []int{1}},
+		{"only pending versions", 1, []testVersion{{status: "PENDING"}, {status: "SCANNING"}}, nil},
+		// Older than the kept versions, still uploading: left alone by the trash
+		{"uploading versions are skipped", 2, []testVersion{{status: "UPLOADING"}, uploaded, uploaded, uploaded}, []int{2}},
+		{"deleted versions don't count", 1, []testVersion{{status: "DELETED"}, uploaded, uploaded}, []int{2}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			name := fmt.Sprintf("proj/app%d", i)
+			uuids := addVersions(t, name, tt.keep, tt.versions)
+
+			trashed, err := ApplyVersionRetention(ctx, name)
+			if err != nil {
+				t.Fatalf("ApplyVersionRetention: %v", err)
+			}
+			var want []string
+			for _, version := range tt.trashed {
+				want = append(want, uuids[version-1])
+			}
+			slices.Sort(trashed)
+			if !slices.Equal(trashed, want) {
+				t.Fatalf("trashed %v, want %v", trashed, want)
+			}
+
+			for i, artifactUUID := range uuids {
+				var status, deletedBy sql.NullString
+				if err := db.DB.QueryRowContext(ctx, "SELECT status, deleted_by FROM Artifacts WHERE uuid = ?", artifactUUID).Scan(&status, &deletedBy); err != nil {
+					t.Fatal(err)
+				}
+				switch {
+				case slices.Contains(want, artifactUUID):
+					if status.String != "DELETED" || deletedBy.String != "version-retention" {
+						t.Errorf("version %d: status %q deleted by %q, want DELETED by version-retention", i+1, status.String, deletedBy.String)
+					}
+				case status.String != tt.versions[i].status:
+					t.Errorf("version %d: status %q, want %q", i+1, status.String, tt.versions[i].status)
+				}
+			}
+		})
+	}
+}